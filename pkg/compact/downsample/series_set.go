@@ -0,0 +1,113 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package downsample
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// NewDownsamplingSeriesSet returns a series set which downsamples raw chunks of every series on the fly to the
+// given resolution, e.g. to reduce the data sent for long range queries without downsampled blocks. It uses the
+// same logic as the downsampling of raw blocks, producing count, sum, min, max and counter aggregates. Chunks
+// which are already downsampled are passed through untouched.
+func NewDownsamplingSeriesSet(s storepb.SeriesSet, resolution int64) (storepb.SeriesSet, error) {
+	if resolution <= 0 {
+		return nil, errors.Errorf("invalid downsampling resolution %d", resolution)
+	}
+	return &downsamplingSeriesSet{set: s, resolution: resolution}, nil
+}
+
+type downsamplingSeriesSet struct {
+	set        storepb.SeriesSet
+	resolution int64
+
+	lset   []storepb.Label
+	chunks []storepb.AggrChunk
+	buf    []sample
+	err    error
+}
+
+func (s *downsamplingSeriesSet) Next() bool {
+	if s.err != nil || !s.set.Next() {
+		return false
+	}
+	lset, chks := s.set.At()
+
+	s.lset = lset
+	s.chunks = make([]storepb.AggrChunk, 0, len(chks))
+	s.buf = s.buf[:0]
+
+	for i, c := range chks {
+		if c.Raw == nil {
+			s.chunks = append(s.chunks, c)
+			continue
+		}
+		chk, err := c.Raw.Decode()
+		if err != nil {
+			s.err = errors.Wrapf(err, "chunk %d, series %s", i, storepb.LabelsToString(lset))
+			return false
+		}
+		if err := expandChunkIterator(chk.Iterator(nil), &s.buf); err != nil {
+			s.err = errors.Wrapf(err, "expand chunk %d, series %s", i, storepb.LabelsToString(lset))
+			return false
+		}
+	}
+
+	// Raw chunks of merged series may overlap, so samples have to be ordered and deduplicated before aggregating.
+	sort.SliceStable(s.buf, func(i, j int) bool { return s.buf[i].t < s.buf[j].t })
+	samples := s.buf[:0]
+	for _, smpl := range s.buf {
+		if len(samples) > 0 && samples[len(samples)-1].t == smpl.t {
+			continue
+		}
+		samples = append(samples, smpl)
+	}
+
+	for _, m := range downsampleRaw(samples, s.resolution) {
+		c, err := toStoreAggrChunk(m.MinTime, m.MaxTime, *m.Chunk.(*AggrChunk))
+		if err != nil {
+			s.err = errors.Wrapf(err, "series %s", storepb.LabelsToString(lset))
+			return false
+		}
+		s.chunks = append(s.chunks, c)
+	}
+	sort.SliceStable(s.chunks, func(i, j int) bool { return s.chunks[i].MinTime < s.chunks[j].MinTime })
+	return true
+}
+
+func (s *downsamplingSeriesSet) At() ([]storepb.Label, []storepb.AggrChunk) {
+	return s.lset, s.chunks
+}
+
+func (s *downsamplingSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.set.Err()
+}
+
+// toStoreAggrChunk converts an aggregated chunk to its StoreAPI representation with all aggregates set.
+func toStoreAggrChunk(mint, maxt int64, ac AggrChunk) (storepb.AggrChunk, error) {
+	ret := storepb.AggrChunk{MinTime: mint, MaxTime: maxt}
+	for _, a := range []struct {
+		typ AggrType
+		out **storepb.Chunk
+	}{
+		{typ: AggrCount, out: &ret.Count},
+		{typ: AggrSum, out: &ret.Sum},
+		{typ: AggrMin, out: &ret.Min},
+		{typ: AggrMax, out: &ret.Max},
+		{typ: AggrCounter, out: &ret.Counter},
+	} {
+		x, err := ac.Get(a.typ)
+		if err != nil {
+			return ret, errors.Wrapf(err, "get %s aggregate", a.typ)
+		}
+		*a.out = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: x.Bytes()}
+	}
+	return ret, nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package downsample
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type testSeriesSet struct {
+	series []storepb.Series
+	idx    int
+}
+
+func (s *testSeriesSet) Next() bool {
+	s.idx++
+	return s.idx < len(s.series)
+}
+
+func (s *testSeriesSet) At() ([]storepb.Label, []storepb.AggrChunk) {
+	return s.series[s.idx].Labels, s.series[s.idx].Chunks
+}
+
+func (s *testSeriesSet) Err() error { return nil }
+
+func encodeRawChunk(t *testing.T, samples []sample) storepb.AggrChunk {
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	testutil.Ok(t, err)
+	for _, s := range samples {
+		app.Append(s.t, s.v)
+	}
+	return storepb.AggrChunk{
+		MinTime: samples[0].t,
+		MaxTime: samples[len(samples)-1].t,
+		Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+	}
+}
+
+func decodeStoreChunk(t *testing.T, c *storepb.Chunk) []sample {
+	testutil.Assert(t, c != nil, "expected chunk to be set")
+	chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+	testutil.Ok(t, err)
+
+	var res []sample
+	it := chk.Iterator(nil)
+	for it.Next() {
+		ts, v := it.At()
+		res = append(res, sample{ts, v})
+	}
+	testutil.Ok(t, it.Err())
+	return res
+}
+
+func TestDownsamplingSeriesSet(t *testing.T) {
+	_, err := NewDownsamplingSeriesSet(&testSeriesSet{idx: -1}, 0)
+	testutil.NotOk(t, err)
+
+	var first, second []sample
+	for i := 0; i < 10; i++ {
+		first = append(first, sample{t: int64(i * 10), v: float64(i)})
+		// Counter reset in the second window.
+		second = append(second, sample{t: int64(100 + i*10), v: float64(i + 100)})
+	}
+	second[5].v = 1
+
+	aggr := storepb.AggrChunk{MinTime: 1000, MaxTime: 2000, Count: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte{0, 0}}}
+	set := &testSeriesSet{idx: -1, series: []storepb.Series{
+		{
+			Labels: []storepb.Label{{Name: "a", Value: "1"}},
+			// Overlapping chunk is deduplicated.
+			Chunks: []storepb.AggrChunk{encodeRawChunk(t, second), encodeRawChunk(t, first), encodeRawChunk(t, first[5:]), aggr},
+		},
+	}}
+
+	s, err := NewDownsamplingSeriesSet(set, 100)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, s.Next(), "expected series")
+	lset, chks := s.At()
+	testutil.Equals(t, []storepb.Label{{Name: "a", Value: "1"}}, lset)
+	testutil.Equals(t, 2, len(chks))
+	testutil.Equals(t, aggr, chks[1])
+
+	c := chks[0]
+	testutil.Equals(t, int64(99), c.MinTime)
+	testutil.Equals(t, int64(190), c.MaxTime)
+	testutil.Assert(t, c.Raw == nil, "expected no raw chunk")
+
+	testutil.Equals(t, []sample{{99, 10}, {190, 10}}, decodeStoreChunk(t, c.Count))
+	testutil.Equals(t, []sample{{99, 45}, {190, 100 + 101 + 102 + 103 + 104 + 1 + 106 + 107 + 108 + 109}}, decodeStoreChunk(t, c.Sum))
+	testutil.Equals(t, []sample{{99, 0}, {190, 1}}, decodeStoreChunk(t, c.Min))
+	testutil.Equals(t, []sample{{99, 9}, {190, 109}}, decodeStoreChunk(t, c.Max))
+	// Counter has the first and last raw value in addition to the aggregated counter value per window.
+	// The counter reset from 104 to 1 is accounted for.
+	counter := decodeStoreChunk(t, c.Counter)
+	testutil.Equals(t, 4, len(counter))
+	testutil.Equals(t, sample{0, 0}, counter[0])
+	testutil.Equals(t, sample{99, 9}, counter[1])
+	testutil.Equals(t, sample{190, 104 + 1 + 105 + 3}, counter[2])
+	testutil.Equals(t, sample{190, 109}, counter[3])
+
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+}
+
+func TestDownsamplingSeriesSet_DoubleDelta(t *testing.T) {
+	// Legacy double-delta chunk with samples 1000:1, 1015:1.5, 1031:2.25 and 1043:2.
+	raw := storepb.AggrChunk{MinTime: 1000, MaxTime: 1043, Raw: &storepb.Chunk{Type: storepb.Chunk_DOUBLE_DELTA, Data: []byte{
+		0x2f, 0x00, // Used bytes.
+		0x01, 0x04, 0x00, // Time bytes, value bytes, is integer.
+		0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Base time 1000.
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f, // Base value 1.0.
+		0x0f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Base time delta 15.
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xe0, 0x3f, // Base value delta 0.5.
+		0x01, 0x00, 0x00, 0x80, 0x3e, // +1, +0.25.
+		0xfe, 0x00, 0x00, 0x00, 0xbf, // -2, -0.5.
+		0x00, 0x00, 0x00, 0x00, // Unused.
+	}}}
+	set := &testSeriesSet{idx: -1, series: []storepb.Series{
+		{Labels: []storepb.Label{{Name: "a", Value: "1"}}, Chunks: []storepb.AggrChunk{raw}},
+	}}
+
+	s, err := NewDownsamplingSeriesSet(set, 1000)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, s.Next(), "expected series")
+	_, chks := s.At()
+	testutil.Equals(t, 1, len(chks))
+	testutil.Equals(t, []sample{{1043, 4}}, decodeStoreChunk(t, chks[0].Count))
+	testutil.Equals(t, []sample{{1043, 6.75}}, decodeStoreChunk(t, chks[0].Sum))
+	testutil.Equals(t, []sample{{1043, 1}}, decodeStoreChunk(t, chks[0].Min))
+	testutil.Equals(t, []sample{{1043, 2.25}}, decodeStoreChunk(t, chks[0].Max))
+
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+}
@@ -186,34 +186,19 @@ func (s *chunkSeries) Iterator() storage.SeriesIterator {
 
 	if len(s.aggrs) == 1 {
 		switch s.aggrs[0] {
-		case storepb.Aggr_COUNT:
+		case storepb.Aggr_COUNT, storepb.Aggr_SUM, storepb.Aggr_MIN, storepb.Aggr_MAX, storepb.Aggr_COUNTER:
 			for _, c := range s.chunks {
-				its = append(its, getFirstIterator(c.Count, c.Raw))
+				chk, _ := c.Get(s.aggrs[0])
+				its = append(its, getFirstIterator(chk, c.Raw))
 			}
-			sit = newChunkSeriesIterator(its)
-		case storepb.Aggr_SUM:
-			for _, c := range s.chunks {
-				its = append(its, getFirstIterator(c.Sum, c.Raw))
-			}
-			sit = newChunkSeriesIterator(its)
-		case storepb.Aggr_MIN:
-			for _, c := range s.chunks {
-				its = append(its, getFirstIterator(c.Min, c.Raw))
-			}
-			sit = newChunkSeriesIterator(its)
-		case storepb.Aggr_MAX:
-			for _, c := range s.chunks {
-				its = append(its, getFirstIterator(c.Max, c.Raw))
-			}
-			sit = newChunkSeriesIterator(its)
-		case storepb.Aggr_COUNTER:
-			for _, c := range s.chunks {
-				its = append(its, getFirstIterator(c.Counter, c.Raw))
-			}
-			sit = downsample.NewCounterSeriesIterator(its...)
 		default:
 			return errSeriesIterator{err: errors.Errorf("unexpected result aggregate type %v", s.aggrs)}
 		}
+		if s.aggrs[0] == storepb.Aggr_COUNTER {
+			sit = downsample.NewCounterSeriesIterator(its...)
+		} else {
+			sit = newChunkSeriesIterator(its)
+		}
 		return newBoundedSeriesIterator(sit, s.mint, s.maxt)
 	}
 
@@ -245,7 +230,7 @@ func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 		if c == nil {
 			continue
 		}
-		chk, err := chunkenc.FromData(chunkEncoding(c.Type), c.Data)
+		chk, err := c.Decode()
 		if err != nil {
 			return errSeriesIterator{err}
 		}
@@ -254,14 +239,6 @@ func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 	return errSeriesIterator{errors.New("no valid chunk found")}
 }
 
-func chunkEncoding(e storepb.Chunk_Encoding) chunkenc.Encoding {
-	switch e {
-	case storepb.Chunk_XOR:
-		return chunkenc.EncXOR
-	}
-	return 255 // Invalid.
-}
-
 type errSeriesIterator struct {
 	err error
 }
@@ -721,9 +721,7 @@ func blockSeries(
 				Value: lv,
 			})
 		}
-		sort.Slice(s.lset, func(i, j int) bool {
-			return s.lset[i].Name < s.lset[j].Name
-		})
+		storepb.SortLabels(s.lset)
 
 		for _, meta := range chks {
 			if meta.MaxTime < req.MinTime {
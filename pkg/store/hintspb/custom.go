@@ -3,10 +3,36 @@
 
 package hintspb
 
-import "github.com/oklog/ulid"
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+)
 
 func (m *SeriesResponseHints) AddQueriedBlock(id ulid.ULID) {
 	m.QueriedBlocks = append(m.QueriedBlocks, Block{
 		Id: id.String(),
 	})
 }
+
+// MergeHints unmarshals all given hints and merges them into merged, e.g. to aggregate hints returned by
+// many stores into a single response. Only *SeriesResponseHints is supported, for which the queried blocks are
+// concatenated. Nil hints are ignored.
+func MergeHints(hints []*types.Any, merged proto.Message) error {
+	switch m := merged.(type) {
+	case *SeriesResponseHints:
+		for _, h := range hints {
+			if h == nil {
+				continue
+			}
+			var x SeriesResponseHints
+			if err := types.UnmarshalAny(h, &x); err != nil {
+				return errors.Wrap(err, "unmarshal series response hints")
+			}
+			m.QueriedBlocks = append(m.QueriedBlocks, x.QueriedBlocks...)
+		}
+		return nil
+	}
+	return errors.Errorf("unsupported hints type %T", merged)
+}
@@ -0,0 +1,36 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package hintspb
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestMergeHints(t *testing.T) {
+	var hints []*types.Any
+	for _, h := range []SeriesResponseHints{
+		{QueriedBlocks: []Block{{Id: "a"}, {Id: "b"}}},
+		{},
+		{QueriedBlocks: []Block{{Id: "c"}}},
+	} {
+		h := h
+		any, err := types.MarshalAny(&h)
+		testutil.Ok(t, err)
+		hints = append(hints, any)
+	}
+	hints = append(hints, nil)
+
+	merged := &SeriesResponseHints{}
+	testutil.Ok(t, MergeHints(hints, merged))
+	testutil.Equals(t, []Block{{Id: "a"}, {Id: "b"}, {Id: "c"}}, merged.QueriedBlocks)
+
+	testutil.NotOk(t, MergeHints(hints, &Block{}))
+
+	wrong, err := types.MarshalAny(&Block{Id: "a"})
+	testutil.Ok(t, err)
+	testutil.NotOk(t, MergeHints([]*types.Any{wrong}, &SeriesResponseHints{}))
+}
@@ -179,9 +179,7 @@ func (p *PrometheusStore) Series(r *storepb.SeriesRequest, s storepb.Store_Serie
 				lset = append(lset, storepb.Label{Name: k, Value: v})
 			}
 			lset = append(lset, storepb.PromLabelsToLabelsUnsafe(externalLabels)...)
-			sort.Slice(lset, func(i, j int) bool {
-				return lset[i].Name < lset[j].Name
-			})
+			storepb.SortLabels(lset)
 			if err = s.Send(storepb.NewSeriesResponse(&storepb.Series{Labels: lset})); err != nil {
 				return err
 			}
@@ -0,0 +1,111 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package storearrow converts StoreAPI series to Apache Arrow records, e.g. to feed them into DataFrame tooling.
+package storearrow
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/pkg/errors"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+const (
+	// SeriesIDColumn is the name of the column holding the ID of the series of each sample.
+	SeriesIDColumn = "series_id"
+	// TimestampColumn is the name of the column holding the sample timestamps, in milliseconds.
+	TimestampColumn = "timestamp"
+	// ValueColumn is the name of the column holding the sample values.
+	ValueColumn = "value"
+
+	// SeriesMetadataPrefix prefixes the schema metadata keys holding the labels of each series, followed by the
+	// series ID. The labels are in the Prometheus text format, e.g. {a="1", b="2"}.
+	SeriesMetadataPrefix = "thanos.series."
+	// WarningsMetadataKey is the schema metadata key holding the warnings of the conversion, separated by new lines.
+	WarningsMetadataKey = "thanos.warnings"
+)
+
+// SeriesSetToArrow decodes the raw chunks of all series into a record with one row per sample, consisting of the
+// series ID, timestamp and value columns. Series IDs are assigned in order of the series, starting at 0. The label
+// sets are stored once per series in the schema metadata, see SeriesMetadataPrefix, as a dictionary of series IDs
+// to labels.
+//
+// The labels are not a dictionary-encoded column, as the Arrow Go version used does not support dictionary arrays
+// yet. The series ID column takes the role of the dictionary indices instead, so labels are not repeated per sample,
+// but consumers have to look them up in the metadata. As the metadata grows with the number of series, converting
+// sets of many series is expensive; the labels should move to a dictionary column once Arrow is upgraded.
+//
+// Aggregated chunks of downsampled data can't be represented by single samples, so they are skipped and a warning
+// is added to the schema metadata, see WarningsMetadataKey. The caller has to release the returned record.
+func SeriesSetToArrow(s storepb.SeriesSet, alloc memory.Allocator) (array.Record, error) {
+	var (
+		ids    = array.NewUint32Builder(alloc)
+		ts     = array.NewTimestampBuilder(alloc, arrow.FixedWidthTypes.Timestamp_ms.(*arrow.TimestampType))
+		vals   = array.NewFloat64Builder(alloc)
+		keys   []string
+		values []string
+		warns  []string
+	)
+	defer ids.Release()
+	defer ts.Release()
+	defer vals.Release()
+
+	for id := uint32(0); s.Next(); id++ {
+		lset, chks := s.At()
+		keys = append(keys, SeriesMetadataPrefix+strconv.FormatUint(uint64(id), 10))
+		values = append(values, storepb.LabelsToPromLabels(lset).String())
+
+		skipped := 0
+		for i, c := range chks {
+			if c.Raw == nil {
+				skipped++
+				continue
+			}
+			chk, err := c.Raw.Decode()
+			if err != nil {
+				return nil, errors.Wrapf(err, "series %s chunk %d", storepb.LabelsToString(lset), i)
+			}
+			it := chk.Iterator(nil)
+			for it.Next() {
+				t, v := it.At()
+				ids.Append(id)
+				ts.Append(arrow.Timestamp(t))
+				vals.Append(v)
+			}
+			if err := it.Err(); err != nil {
+				return nil, errors.Wrapf(err, "series %s chunk %d: decode samples", storepb.LabelsToString(lset), i)
+			}
+		}
+		if skipped > 0 {
+			warns = append(warns, errors.Errorf("series %s: skipped %d downsampled chunks", storepb.LabelsToString(lset), skipped).Error())
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(warns) > 0 {
+		keys = append(keys, WarningsMetadataKey)
+		values = append(values, strings.Join(warns, "\n"))
+	}
+	md := arrow.NewMetadata(keys, values)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: SeriesIDColumn, Type: arrow.PrimitiveTypes.Uint32},
+		{Name: TimestampColumn, Type: arrow.FixedWidthTypes.Timestamp_ms},
+		{Name: ValueColumn, Type: arrow.PrimitiveTypes.Float64},
+	}, &md)
+
+	cols := []array.Interface{ids.NewArray(), ts.NewArray(), vals.NewArray()}
+	defer func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}()
+	return array.NewRecord(schema, cols, int64(cols[0].Len())), nil
+}
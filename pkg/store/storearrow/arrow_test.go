@@ -0,0 +1,108 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storearrow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+type listSeriesSet struct {
+	series []storepb.Series
+	idx    int
+}
+
+func (s *listSeriesSet) Next() bool {
+	s.idx++
+	return s.idx < len(s.series)
+}
+
+func (s *listSeriesSet) At() ([]storepb.Label, []storepb.AggrChunk) {
+	return s.series[s.idx].Labels, s.series[s.idx].Chunks
+}
+
+func (s *listSeriesSet) Err() error { return nil }
+
+func rawChunk(t *testing.T, samples ...sample) storepb.AggrChunk {
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	testutil.Ok(t, err)
+	for _, s := range samples {
+		app.Append(s.t, s.v)
+	}
+	return storepb.AggrChunk{
+		MinTime: samples[0].t,
+		MaxTime: samples[len(samples)-1].t,
+		Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+	}
+}
+
+func TestSeriesSetToArrow(t *testing.T) {
+	alloc := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer alloc.AssertSize(t, 0)
+
+	downsampled := rawChunk(t, sample{20, 1})
+	downsampled.Count, downsampled.Raw = downsampled.Raw, nil
+
+	s := &listSeriesSet{idx: -1, series: []storepb.Series{
+		{
+			Labels: []storepb.Label{{Name: "a", Value: "1"}},
+			Chunks: []storepb.AggrChunk{rawChunk(t, sample{1, 1}, sample{2, 2}), rawChunk(t, sample{3, 3})},
+		},
+		{
+			Labels: []storepb.Label{{Name: "a", Value: "2"}, {Name: "b", Value: "x"}},
+			Chunks: []storepb.AggrChunk{rawChunk(t, sample{10, 0.5}), downsampled},
+		},
+	}}
+
+	rec, err := SeriesSetToArrow(s, alloc)
+	testutil.Ok(t, err)
+	defer rec.Release()
+
+	testutil.Equals(t, int64(4), rec.NumRows())
+	testutil.Equals(t, SeriesIDColumn, rec.ColumnName(0))
+	testutil.Equals(t, TimestampColumn, rec.ColumnName(1))
+	testutil.Equals(t, ValueColumn, rec.ColumnName(2))
+
+	testutil.Equals(t, []uint32{0, 0, 0, 1}, rec.Column(0).(*array.Uint32).Uint32Values())
+	testutil.Equals(t, []arrow.Timestamp{1, 2, 3, 10}, rec.Column(1).(*array.Timestamp).TimestampValues())
+	testutil.Equals(t, []float64{1, 2, 3, 0.5}, rec.Column(2).(*array.Float64).Float64Values())
+
+	md := rec.Schema().Metadata()
+	lookup := func(key string) string {
+		i := md.FindKey(key)
+		testutil.Assert(t, i >= 0, "missing metadata key %s", key)
+		return md.Values()[i]
+	}
+	testutil.Equals(t, `{a="1"}`, lookup(SeriesMetadataPrefix+"0"))
+	testutil.Equals(t, `{a="2", b="x"}`, lookup(SeriesMetadataPrefix+"1"))
+
+	warns := lookup(WarningsMetadataKey)
+	testutil.Assert(t, strings.Contains(warns, "skipped 1 downsampled chunks"), "unexpected warnings %q", warns)
+}
+
+func TestSeriesSetToArrow_Empty(t *testing.T) {
+	alloc := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer alloc.AssertSize(t, 0)
+
+	rec, err := SeriesSetToArrow(storepb.EmptySeriesSet(), alloc)
+	testutil.Ok(t, err)
+	defer rec.Release()
+
+	testutil.Equals(t, int64(0), rec.NumRows())
+	testutil.Equals(t, -1, rec.Schema().Metadata().FindKey(WarningsMetadataKey))
+}
@@ -0,0 +1,145 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// NewTimestampAlignSeriesSet returns a series set which snaps sample timestamps of the raw chunks of every series
+// that are within tolerance of each other to a shared timestamp, e.g. so that chunks of replicas of an HA pair
+// scraping a few milliseconds apart become bit-identical and can be deduplicated, see MergeOptions.StrictDedup.
+// It is meant to be applied to merged series, as only timestamps of the same series are compared.
+//
+// Timestamps are clustered in time order: a cluster starts at the earliest timestamp not in any cluster yet and
+// contains all timestamps at most tolerance after it, which are all snapped to that earliest one. Unlike rounding
+// to a fixed grid, close timestamps on different sides of a grid midpoint are snapped together. If several samples
+// of a chunk end up with the same timestamp, only the first one is kept. Affected chunks are decoded and re-encoded
+// as XOR chunks and their time range is set to their first and last sample, other chunks and aggregated chunks are
+// passed through. The chunks are copied only if any of them changes, so the wrapped set is not modified.
+//
+// A tolerance of 0 disables the alignment.
+func NewTimestampAlignSeriesSet(s SeriesSet, tolerance int64) (SeriesSet, error) {
+	if tolerance < 0 {
+		return nil, errors.Errorf("invalid tolerance %d", tolerance)
+	}
+	if tolerance == 0 {
+		return s, nil
+	}
+	return &timestampAlignSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, tolerance: tolerance}, nil
+}
+
+type timestampAlignSeriesSet struct {
+	erroringSeriesSet
+
+	tolerance int64
+	lset      []Label
+	chunks    []AggrChunk
+}
+
+func (s *timestampAlignSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	s.lset, s.chunks = s.SeriesSet.At()
+
+	var (
+		decoded = make([][]Sample, len(s.chunks))
+		ts      []int64
+	)
+	for i, c := range s.chunks {
+		if c.Raw == nil {
+			continue
+		}
+		samples, err := c.Raw.Samples()
+		if err != nil {
+			s.err = errors.Wrapf(err, "series %s chunk %d", LabelsToString(s.lset), i)
+			return false
+		}
+		decoded[i] = samples
+		for _, smpl := range samples {
+			ts = append(ts, smpl.T)
+		}
+	}
+	snapped := snapTimestamps(ts, s.tolerance)
+	if len(snapped) == 0 {
+		return true
+	}
+
+	ret := make([]AggrChunk, 0, len(s.chunks))
+	for i, c := range s.chunks {
+		if c.Raw != nil {
+			aligned, err := alignChunk(c, decoded[i], snapped)
+			if err != nil {
+				s.err = errors.Wrapf(err, "series %s chunk %d", LabelsToString(s.lset), i)
+				return false
+			}
+			c = aligned
+		}
+		ret = append(ret, c)
+	}
+	s.chunks = ret
+	return true
+}
+
+// snapTimestamps clusters the given timestamps, see NewTimestampAlignSeriesSet, and returns the timestamp to snap
+// to for all timestamps which change.
+func snapTimestamps(ts []int64, tolerance int64) map[int64]int64 {
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+
+	var ret map[int64]int64
+	for i, start := 0, int64(0); i < len(ts); i++ {
+		if i == 0 || ts[i]-start > tolerance {
+			start = ts[i]
+			continue
+		}
+		if ts[i] == start {
+			continue
+		}
+		if ret == nil {
+			ret = map[int64]int64{}
+		}
+		ret[ts[i]] = start
+	}
+	return ret
+}
+
+// alignChunk returns the chunk with its samples snapped to the given timestamps. The chunk is returned as is if none
+// of its samples change.
+func alignChunk(c AggrChunk, samples []Sample, snapped map[int64]int64) (AggrChunk, error) {
+	changed := false
+	for i := range samples {
+		if t, ok := snapped[samples[i].T]; ok {
+			samples[i].T = t
+			changed = true
+		}
+	}
+	if !changed || len(samples) == 0 {
+		return c, nil
+	}
+
+	xor := chunkenc.NewXORChunk()
+	app, err := xor.Appender()
+	if err != nil {
+		return c, err
+	}
+	for i, smpl := range samples {
+		if i > 0 && smpl.T <= samples[i-1].T {
+			continue
+		}
+		app.Append(smpl.T, smpl.V)
+	}
+
+	c.MinTime = samples[0].T
+	c.MaxTime = samples[len(samples)-1].T
+	c.Raw = &Chunk{Type: Chunk_XOR, Data: xor.Bytes()}
+	return c, nil
+}
+
+func (s *timestampAlignSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
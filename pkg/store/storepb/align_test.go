@@ -0,0 +1,89 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestTimestampAlignSeriesSet(t *testing.T) {
+	// Two replicas scraping the same target every 15s, a few milliseconds apart.
+	replicaA := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{{{1000, 1}, {15998, 2}, {31001, 3}}, {{46000, 4}, {61004, 5}}},
+	}})
+	replicaB := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{{{997, 1}, {16003, 2}, {30996, 3}}, {{46002, 4}, {60998, 5}}},
+	}})
+
+	aligned, err := NewTimestampAlignSeriesSet(MergeSeriesSets(replicaA, replicaB), 10)
+	testutil.Ok(t, err)
+
+	ss := MergeSeriesSetsWithOptions(MergeOptions{StrictDedup: true}, aligned)
+	seriesEquals(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{{{997, 1}, {15998, 2}, {30996, 3}}, {{46000, 4}, {60998, 5}}},
+	}}, ss)
+	testutil.Ok(t, ss.Err())
+
+	// The wrapped set is not modified.
+	testutil.Equals(t, int64(1000), replicaA.series[0].Chunks[0].MinTime)
+}
+
+func TestTimestampAlignSeriesSet_GridMidpoint(t *testing.T) {
+	// With tolerance 10, rounding to a grid would move 1004 to 1000 and 1006 to 1010.
+	replicaA := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{{{1004, 1}, {2004, 2}}},
+	}})
+	replicaB := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{{{1006, 1}, {2006, 2}}},
+	}})
+
+	aligned, err := NewTimestampAlignSeriesSet(MergeSeriesSets(replicaA, replicaB), 10)
+	testutil.Ok(t, err)
+
+	ss := MergeSeriesSetsWithOptions(MergeOptions{StrictDedup: true}, aligned)
+	seriesEquals(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{{{1004, 1}, {2004, 2}}},
+	}}, ss)
+	testutil.Ok(t, ss.Err())
+}
+
+func TestTimestampAlignSeriesSet_Collisions(t *testing.T) {
+	in := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{{{-6, 1}, {4, 2}, {6, 3}, {12, 4}, {14, 5}, {20, 6}}},
+	}})
+	agg := AggrChunk{MinTime: 3, MaxTime: 7, Count: in.series[0].Chunks[0].Raw}
+	in.series[0].Chunks = append(in.series[0].Chunks, agg)
+
+	ss, err := NewTimestampAlignSeriesSet(in, 10)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, ss.Next(), "expected series")
+	_, chks := ss.At()
+	testutil.Equals(t, 2, len(chks))
+	testutil.Equals(t, int64(-6), chks[0].MinTime)
+	testutil.Equals(t, int64(20), chks[0].MaxTime)
+
+	// Clusters start at -6, 6 and 20, only the first sample is kept for every snapped timestamp.
+	samples, err := chks[0].Raw.Samples()
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Sample{{T: -6, V: 1}, {T: 6, V: 3}, {T: 20, V: 6}}, samples)
+
+	// Aggregated chunks are passed through.
+	testutil.Equals(t, agg, chks[1])
+	testutil.Assert(t, !ss.Next(), "expected end of stream")
+	testutil.Ok(t, ss.Err())
+
+	_, err = NewTimestampAlignSeriesSet(in, -1)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,196 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ChunkTimeBounds returns the timestamps of the first and the last sample of the XOR chunk, e.g. to build an index
+// of chunk time ranges. It reads the timestamps straight from the chunk data and skips over the values instead of
+// decoding them, so it is somewhat cheaper than iterating the chunk. Chunks of other encodings and chunks without
+// samples return an error.
+func ChunkTimeBounds(c *Chunk) (mint, maxt int64, err error) {
+	if c.Type != Chunk_XOR {
+		return 0, 0, errors.Errorf("unsupported chunk encoding %s", c.Type)
+	}
+	if len(c.Data) < 2 {
+		return 0, 0, errors.Errorf("chunk data too short: %d bytes", len(c.Data))
+	}
+	n := int(binary.BigEndian.Uint16(c.Data))
+	if n == 0 {
+		return 0, 0, errors.New("chunk has no samples")
+	}
+
+	r := &bitReader{b: c.Data[2:]}
+	t, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "read timestamp of sample 0")
+	}
+	mint = t
+	if err := r.skip(64); err != nil {
+		return 0, 0, errors.Wrap(err, "read value of sample 0")
+	}
+
+	var (
+		tDelta            int64
+		leading, trailing uint64
+	)
+	for i := 1; i < n; i++ {
+		if i == 1 {
+			d, err := binary.ReadUvarint(r)
+			if err != nil {
+				return 0, 0, errors.Wrapf(err, "read timestamp of sample %d", i)
+			}
+			tDelta = int64(d)
+		} else {
+			dod, err := readXORDeltaOfDelta(r)
+			if err != nil {
+				return 0, 0, errors.Wrapf(err, "read timestamp of sample %d", i)
+			}
+			tDelta += dod
+		}
+		t += tDelta
+
+		if leading, trailing, err = skipXORValue(r, leading, trailing); err != nil {
+			return 0, 0, errors.Wrapf(err, "read value of sample %d", i)
+		}
+	}
+	return mint, t, nil
+}
+
+// readXORDeltaOfDelta reads a timestamp delta of delta as written by the Prometheus XOR chunk encoder.
+func readXORDeltaOfDelta(r *bitReader) (int64, error) {
+	var d uint64
+	for i := 0; i < 4; i++ {
+		b, err := r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		d = d<<1 | b
+		if b == 0 {
+			break
+		}
+	}
+
+	var sz int
+	switch d {
+	case 0x00:
+		return 0, nil
+	case 0x02:
+		sz = 14
+	case 0x06:
+		sz = 17
+	case 0x0e:
+		sz = 20
+	case 0x0f:
+		bits, err := r.readBits(64)
+		return int64(bits), err
+	}
+
+	bits, err := r.readBits(sz)
+	if err != nil {
+		return 0, err
+	}
+	if bits > 1<<uint(sz-1) {
+		bits -= 1 << uint(sz)
+	}
+	return int64(bits), nil
+}
+
+// skipXORValue skips a value as written by the Prometheus XOR chunk encoder and returns the number of leading and
+// trailing zero bits needed to skip the next one.
+func skipXORValue(r *bitReader, leading, trailing uint64) (uint64, uint64, error) {
+	changed, err := r.readBits(1)
+	if err != nil || changed == 0 {
+		return leading, trailing, err
+	}
+	newWindow, err := r.readBits(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if newWindow == 1 {
+		if leading, err = r.readBits(5); err != nil {
+			return 0, 0, err
+		}
+		sig, err := r.readBits(6)
+		if err != nil {
+			return 0, 0, err
+		}
+		// 0 significant bits mean 64, as 64 does not fit into 6 bits.
+		if sig == 0 {
+			sig = 64
+		}
+		if leading+sig > 64 {
+			return 0, 0, errors.Errorf("invalid value window of %d leading and %d significant bits", leading, sig)
+		}
+		trailing = 64 - leading - sig
+	}
+	return leading, trailing, r.skip(int(64 - leading - trailing))
+}
+
+// bitReader reads a byte slice bit by bit, starting at the most significant bit of each byte. It buffers up to
+// 64 bits, so that most reads are a shift and a mask.
+type bitReader struct {
+	b     []byte
+	buf   uint64
+	valid uint
+}
+
+// readBits reads n <= 64 bits.
+func (r *bitReader) readBits(n int) (uint64, error) {
+	if uint(n) <= r.valid {
+		r.valid -= uint(n)
+		return r.buf >> r.valid & lowBits(uint(n)), nil
+	}
+
+	// Take the remaining buffered bits and the rest from the next buffer.
+	var (
+		v    = r.buf & lowBits(r.valid)
+		need = uint(n) - r.valid
+	)
+	r.fill()
+	if need > r.valid {
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.valid -= need
+	return v<<need | r.buf>>r.valid&lowBits(need), nil
+}
+
+// fill replaces the buffer with the next up to 64 bits of the data.
+func (r *bitReader) fill() {
+	if len(r.b) >= 8 {
+		r.buf, r.valid = binary.BigEndian.Uint64(r.b), 64
+		r.b = r.b[8:]
+		return
+	}
+	r.buf, r.valid = 0, 0
+	for _, b := range r.b {
+		r.buf = r.buf<<8 | uint64(b)
+		r.valid += 8
+	}
+	r.b = nil
+}
+
+func (r *bitReader) skip(n int) error {
+	_, err := r.readBits(n)
+	return err
+}
+
+// ReadByte implements io.ByteReader, so that varints can be read at any bit offset.
+func (r *bitReader) ReadByte() (byte, error) {
+	b, err := r.readBits(8)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return byte(b), err
+}
+
+// lowBits returns a mask of the n <= 64 lowest bits.
+func lowBits(n uint) uint64 {
+	return 1<<n - 1
+}
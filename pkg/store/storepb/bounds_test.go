@@ -0,0 +1,88 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestChunkTimeBounds(t *testing.T) {
+	xorChunk := func(samples []Sample) *Chunk {
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		testutil.Ok(t, err)
+		for _, s := range samples {
+			app.Append(s.T, s.V)
+		}
+		return &Chunk{Type: Chunk_XOR, Data: c.Bytes()}
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	// Deltas exercising all sizes of delta of delta encodings.
+	deltas := []int64{15000, 15000, 15001, 14000, 30000, 100000, 1000000, 1, 1 << 40, 15000}
+	var varying []Sample
+	for i, t := 0, int64(-5000); i < 200; i++ {
+		varying = append(varying, Sample{T: t, V: rnd.NormFloat64() * math.Pow(10, float64(rnd.Intn(20)))})
+		t += deltas[i%len(deltas)]
+	}
+
+	for _, tcase := range []struct {
+		desc    string
+		samples []Sample
+	}{
+		{desc: "single sample", samples: []Sample{{T: 1000, V: 1}}},
+		{desc: "two samples", samples: []Sample{{T: 1000, V: 1}, {T: 2000, V: 1}}},
+		{desc: "constant values", samples: []Sample{{T: 1, V: 5}, {T: 2, V: 5}, {T: 3, V: 5}, {T: 5, V: 5}}},
+		{desc: "special values", samples: []Sample{{T: 1, V: math.NaN()}, {T: 2, V: math.Inf(1)}, {T: 4, V: 0}, {T: 8, V: -math.MaxFloat64}}},
+		{desc: "varying deltas and values", samples: varying},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			mint, maxt, err := ChunkTimeBounds(xorChunk(tcase.samples))
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.samples[0].T, mint)
+			testutil.Equals(t, tcase.samples[len(tcase.samples)-1].T, maxt)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		c := xorChunk(varying)
+		for _, chk := range []*Chunk{
+			{Type: Chunk_XOR},
+			{Type: Chunk_XOR, Data: []byte{0, 0}},
+			{Type: Chunk_XOR, Data: c.Data[:len(c.Data)/2]},
+			{Type: Chunk_DOUBLE_DELTA, Data: doubleDeltaData(1, 4, false, 0, 0, 0, 0)},
+		} {
+			_, _, err := ChunkTimeBounds(chk)
+			testutil.NotOk(t, err)
+		}
+	})
+}
+
+func BenchmarkChunkTimeBounds(b *testing.B) {
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	testutil.Ok(b, err)
+	for i := int64(0); i < 120; i++ {
+		app.Append(i*15000, float64(i)*1.5)
+	}
+	chk := &Chunk{Type: Chunk_XOR, Data: c.Bytes()}
+
+	b.Run("bounds", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _, _ = ChunkTimeBounds(chk)
+		}
+	})
+	b.Run("iterate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			it := c.Iterator(nil)
+			for it.Next() {
+			}
+		}
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"github.com/pkg/errors"
+)
+
+// AggrChunkBuilder builds aggregated chunks of downsampled data. The zero value is ready to use.
+type AggrChunkBuilder struct {
+	chk      AggrChunk
+	timesSet bool
+}
+
+// SetTimes sets the time range of the chunk.
+func (b *AggrChunkBuilder) SetTimes(mint, maxt int64) *AggrChunkBuilder {
+	b.chk.MinTime, b.chk.MaxTime = mint, maxt
+	b.timesSet = true
+	return b
+}
+
+// SetCount sets the count aggregate of the chunk.
+func (b *AggrChunkBuilder) SetCount(c *Chunk) *AggrChunkBuilder {
+	b.chk.Count = c
+	return b
+}
+
+// SetSum sets the sum aggregate of the chunk.
+func (b *AggrChunkBuilder) SetSum(c *Chunk) *AggrChunkBuilder {
+	b.chk.Sum = c
+	return b
+}
+
+// SetMin sets the min aggregate of the chunk.
+func (b *AggrChunkBuilder) SetMin(c *Chunk) *AggrChunkBuilder {
+	b.chk.Min = c
+	return b
+}
+
+// SetMax sets the max aggregate of the chunk.
+func (b *AggrChunkBuilder) SetMax(c *Chunk) *AggrChunkBuilder {
+	b.chk.Max = c
+	return b
+}
+
+// SetCounter sets the counter aggregate of the chunk.
+func (b *AggrChunkBuilder) SetCounter(c *Chunk) *AggrChunkBuilder {
+	b.chk.Counter = c
+	return b
+}
+
+// Build returns the aggregated chunk. It errors if the time range was not set or is invalid, or if no aggregate
+// was set.
+func (b *AggrChunkBuilder) Build() (AggrChunk, error) {
+	if !b.timesSet {
+		return AggrChunk{}, errors.New("chunk time range not set")
+	}
+	if b.chk.MinTime > b.chk.MaxTime {
+		return AggrChunk{}, errors.Errorf("chunk min time %d is after max time %d", b.chk.MinTime, b.chk.MaxTime)
+	}
+	if len(b.chk.Aggregates()) == 0 {
+		return AggrChunk{}, errors.New("no aggregate set")
+	}
+	return b.chk, nil
+}
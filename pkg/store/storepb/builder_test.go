@@ -0,0 +1,58 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestAggrChunkBuilder(t *testing.T) {
+	chks := newSeries(t, labels.FromStrings("a", "1"), [][]sample{
+		{{10, 2}, {20, 3}},
+		{{10, 5}, {20, 7}},
+		{{10, 1}, {20, 2}},
+		{{10, 4}, {20, 5}},
+		{{10, 4}, {20, 11}},
+	}).Chunks
+
+	t.Run("all aggregates", func(t *testing.T) {
+		var b AggrChunkBuilder
+		c, err := b.SetTimes(10, 20).
+			SetCount(chks[0].Raw).
+			SetSum(chks[1].Raw).
+			SetMin(chks[2].Raw).
+			SetMax(chks[3].Raw).
+			SetCounter(chks[4].Raw).
+			Build()
+		testutil.Ok(t, err)
+		testutil.Equals(t, AggrChunk{
+			MinTime: 10,
+			MaxTime: 20,
+			Count:   chks[0].Raw,
+			Sum:     chks[1].Raw,
+			Min:     chks[2].Raw,
+			Max:     chks[3].Raw,
+			Counter: chks[4].Raw,
+		}, c)
+		testutil.Ok(t, c.Validate())
+	})
+	t.Run("times not set", func(t *testing.T) {
+		var b AggrChunkBuilder
+		_, err := b.SetCount(chks[0].Raw).Build()
+		testutil.NotOk(t, err)
+	})
+	t.Run("invalid times", func(t *testing.T) {
+		var b AggrChunkBuilder
+		_, err := b.SetTimes(20, 10).SetCount(chks[0].Raw).Build()
+		testutil.NotOk(t, err)
+	})
+	t.Run("no aggregate", func(t *testing.T) {
+		var b AggrChunkBuilder
+		_, err := b.SetTimes(10, 20).Build()
+		testutil.NotOk(t, err)
+	})
+}
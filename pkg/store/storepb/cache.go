@@ -0,0 +1,73 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/cespare/xxhash"
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/pkg/errors"
+)
+
+// ChunkDecodeCache caches decoded samples of chunks keyed by the hash of their encoding and data, so that chunks
+// which are queried repeatedly are decoded only once. Cached entries keep a copy of the chunk data, which is compared
+// on every hit, so that hash collisions never return samples of another chunk. It is safe for concurrent use.
+type ChunkDecodeCache struct {
+	mtx sync.Mutex
+	lru *lru.LRU
+}
+
+type chunkCacheKey struct {
+	typ  Chunk_Encoding
+	hash uint64
+}
+
+type chunkCacheEntry struct {
+	data    []byte
+	samples []Sample
+}
+
+// NewChunkDecodeCache returns a new cache holding decoded samples of up to size chunks.
+func NewChunkDecodeCache(size int) (*ChunkDecodeCache, error) {
+	l, err := lru.NewLRU(size, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create LRU")
+	}
+	return &ChunkDecodeCache{lru: l}, nil
+}
+
+// DecodeRaw returns the decoded samples of the given chunk, decoding it only if it is not cached yet.
+// The returned samples are shared with other callers and must not be modified.
+func (c *ChunkDecodeCache) DecodeRaw(chk *Chunk) ([]Sample, error) {
+	key := chunkCacheKey{typ: chk.Type, hash: xxhash.Sum64(chk.Data)}
+
+	c.mtx.Lock()
+	v, ok := c.lru.Get(key)
+	c.mtx.Unlock()
+	if ok {
+		if e := v.(chunkCacheEntry); bytes.Equal(e.data, chk.Data) {
+			return e.samples, nil
+		}
+	}
+
+	// Decode without holding the lock, concurrent misses for the same chunk just decode it twice.
+	samples, err := chk.Samples()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	c.lru.Add(key, chunkCacheEntry{data: append([]byte(nil), chk.Data...), samples: samples})
+	c.mtx.Unlock()
+	return samples, nil
+}
+
+// Len returns the number of cached chunks.
+func (c *ChunkDecodeCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,106 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cespare/xxhash"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestChunkDecodeCache(t *testing.T) {
+	_, err := NewChunkDecodeCache(0)
+	testutil.NotOk(t, err)
+
+	c, err := NewChunkDecodeCache(2)
+	testutil.Ok(t, err)
+
+	chks := newSeries(t, labels.FromStrings("a", "a"), [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{4, 4}}}).Chunks
+
+	samples, err := c.DecodeRaw(chks[0].Raw)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Sample{{T: 1, V: 1}, {T: 2, V: 2}}, samples)
+	testutil.Equals(t, 1, c.Len())
+
+	// Chunk with the same data hits the cache.
+	samples, err = c.DecodeRaw(&Chunk{Type: Chunk_XOR, Data: append([]byte(nil), chks[0].Raw.Data...)})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Sample{{T: 1, V: 1}, {T: 2, V: 2}}, samples)
+	testutil.Equals(t, 1, c.Len())
+
+	for _, chk := range chks[1:] {
+		_, err := c.DecodeRaw(chk.Raw)
+		testutil.Ok(t, err)
+	}
+	testutil.Equals(t, 2, c.Len())
+
+	_, err = c.DecodeRaw(&Chunk{Type: Chunk_XOR, Data: []byte{0, 5, 0xff}})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 2, c.Len())
+
+	// An entry with a colliding hash but different data is not returned.
+	c.lru.Add(chunkCacheKey{typ: Chunk_XOR, hash: xxhash.Sum64(chks[1].Raw.Data)}, chunkCacheEntry{data: chks[2].Raw.Data, samples: []Sample{{T: 4, V: 4}}})
+	samples, err = c.DecodeRaw(chks[1].Raw)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Sample{{T: 3, V: 3}}, samples)
+
+	// Concurrent access.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, chk := range chks {
+				_, err := c.DecodeRaw(chk.Raw)
+				testutil.Ok(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkChunkDecodeCache(b *testing.B) {
+	smpls := make([]sample, 0, 120)
+	for i := 0; i < 120; i++ {
+		smpls = append(smpls, sample{t: int64(i * 15000), v: float64(i)})
+	}
+	chks := make([]*Chunk, 0, 100)
+	for i := 0; i < 100; i++ {
+		smpls[0].v = float64(i)
+		chks = append(chks, newSeries(b, labels.FromStrings("a", fmt.Sprint(i)), [][]sample{smpls}).Chunks[0].Raw)
+	}
+
+	b.Run("miss", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c, err := NewChunkDecodeCache(len(chks))
+			testutil.Ok(b, err)
+			for _, chk := range chks {
+				_, err := c.DecodeRaw(chk)
+				testutil.Ok(b, err)
+			}
+		}
+	})
+	b.Run("hit", func(b *testing.B) {
+		c, err := NewChunkDecodeCache(len(chks))
+		testutil.Ok(b, err)
+		for _, chk := range chks {
+			_, err := c.DecodeRaw(chk)
+			testutil.Ok(b, err)
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, chk := range chks {
+				_, err := c.DecodeRaw(chk)
+				testutil.Ok(b, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"encoding/binary"
+	"hash"
+
+	"github.com/cespare/xxhash"
+)
+
+// NewChecksummingSeriesSet returns a series set that feeds the labels and chunks of each series into a running hash.
+// The returned function yields the checksum of all series iterated so far, so it is only final once the set is
+// fully drained. The checksum is deterministic for identical data and can be used e.g. as an ETag of a response.
+func NewChecksummingSeriesSet(s SeriesSet) (SeriesSet, func() uint64) {
+	cs := &checksummingSeriesSet{SeriesSet: s, h: xxhash.New()}
+	return cs, cs.h.Sum64
+}
+
+type checksummingSeriesSet struct {
+	SeriesSet
+
+	h   hash.Hash64
+	buf []byte
+}
+
+func (s *checksummingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	s.buf = AppendLabels(s.buf[:0], lset)
+	s.buf = appendUvarint(s.buf, uint64(len(chks)))
+	_, _ = s.h.Write(s.buf)
+
+	for _, c := range chks {
+		s.buf = hashAggrChunk(s.h, s.buf, c)
+	}
+	return true
+}
+
+// hashAggrChunk writes the time range and all chunks of c to h, using buf as scratch space, and returns buf for
+// reuse.
+func hashAggrChunk(h hash.Hash64, buf []byte, c AggrChunk) []byte {
+	buf = appendVarint(buf[:0], c.MinTime)
+	buf = appendVarint(buf, c.MaxTime)
+	_, _ = h.Write(buf)
+
+	for _, x := range []*Chunk{c.Raw, c.Count, c.Sum, c.Min, c.Max, c.Counter} {
+		// Write a marker for absent chunks, so that moving data between aggregates changes the hash.
+		if x == nil {
+			_, _ = h.Write([]byte{0})
+			continue
+		}
+		buf = append(buf[:0], 1)
+		buf = appendUvarint(buf, uint64(x.Type))
+		buf = appendUvarint(buf, uint64(len(x.Data)))
+		_, _ = h.Write(buf)
+		_, _ = h.Write(x.Data)
+	}
+	return buf
+}
+
+func appendVarint(dst []byte, x int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	return append(dst, buf[:n]...)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestChecksummingSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+	}
+
+	checksum := func(mod func(l *listSeriesSet)) uint64 {
+		l := newListSeriesSet(t, in)
+		if mod != nil {
+			mod(l)
+		}
+		s, sum := NewChecksummingSeriesSet(l)
+		seriesCount := 0
+		for s.Next() {
+			seriesCount++
+		}
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, len(in), seriesCount)
+		return sum()
+	}
+
+	expected := checksum(nil)
+	testutil.Equals(t, expected, checksum(nil))
+
+	testutil.Assert(t, expected != checksum(func(l *listSeriesSet) {
+		l.series[1].Labels[0].Value = "c"
+	}), "expected checksum to change for changed label")
+	testutil.Assert(t, expected != checksum(func(l *listSeriesSet) {
+		l.series[0].Chunks[1].MaxTime++
+	}), "expected checksum to change for changed chunk time")
+	testutil.Assert(t, expected != checksum(func(l *listSeriesSet) {
+		data := append([]byte(nil), l.series[0].Chunks[1].Raw.Data...)
+		data[len(data)-1] ^= 1
+		l.series[0].Chunks[1].Raw = &Chunk{Type: Chunk_XOR, Data: data}
+	}), "expected checksum to change for a single changed byte")
+	testutil.Assert(t, expected != checksum(func(l *listSeriesSet) {
+		c := l.series[0].Chunks[1]
+		l.series[0].Chunks[1] = AggrChunk{MinTime: c.MinTime, MaxTime: c.MaxTime, Count: c.Raw}
+	}), "expected checksum to change for chunk moved to different aggregate")
+}
+
+func TestChecksummingSeriesSet_Empty(t *testing.T) {
+	s, sum := NewChecksummingSeriesSet(EmptySeriesSet())
+	testutil.Assert(t, !s.Next(), "expected no series")
+	testutil.Equals(t, uint64(0xef46db3751d8e999), sum())
+}
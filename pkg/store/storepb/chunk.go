@@ -0,0 +1,272 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// ErrEmptyChunkData is returned when decoding a chunk without any data. Valid chunks of all encodings carry at
+// least a header, even if they have no samples.
+var ErrEmptyChunkData = errors.New("empty chunk data")
+
+// Decode returns the Prometheus chunk for the given proto chunk. Legacy double-delta chunks are decoded into a
+// read-only chunk, use ReEncode to convert them into an encoding supported by TSDB.
+func (m *Chunk) Decode() (chunkenc.Chunk, error) {
+	if len(m.Data) == 0 {
+		return nil, ErrEmptyChunkData
+	}
+	switch m.Type {
+	case Chunk_XOR:
+		// XOR chunks start with a 2 bytes samples count header which the decoder reads unconditionally.
+		if len(m.Data) < 2 {
+			return nil, errors.Errorf("chunk data too short: %d bytes", len(m.Data))
+		}
+		return chunkenc.FromData(chunkenc.EncXOR, m.Data)
+	case Chunk_DOUBLE_DELTA:
+		return newDoubleDeltaChunk(m.Data)
+	}
+	return nil, errors.Errorf("unsupported chunk encoding %s", m.Type)
+}
+
+// ReEncode decodes the chunk and returns a new chunk with all of its samples encoded with the target encoding.
+func (m *Chunk) ReEncode(target Chunk_Encoding) (*Chunk, error) {
+	var dst chunkenc.Chunk
+	switch target {
+	case Chunk_XOR:
+		dst = chunkenc.NewXORChunk()
+	default:
+		return nil, errors.Errorf("unsupported re-encoding from %s to %s", m.Type, target)
+	}
+
+	src, err := m.Decode()
+	if err != nil {
+		return nil, err
+	}
+	app, err := dst.Appender()
+	if err != nil {
+		return nil, err
+	}
+
+	it := src.Iterator(nil)
+	for it.Next() {
+		app.Append(it.At())
+	}
+	if err := it.Err(); err != nil {
+		return nil, errors.Wrap(err, "decode samples")
+	}
+	return &Chunk{Type: target, Data: dst.Bytes()}, nil
+}
+
+// Sample is a single decoded sample of a chunk.
+type Sample struct {
+	T int64
+	V float64
+}
+
+// Samples decodes all samples of the chunk.
+func (m *Chunk) Samples() ([]Sample, error) {
+	c, err := m.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Sample, 0, c.NumSamples())
+	it := c.Iterator(nil)
+	for it.Next() {
+		t, v := it.At()
+		ret = append(ret, Sample{T: t, V: v})
+	}
+	return ret, errors.Wrap(it.Err(), "decode samples")
+}
+
+// Validate checks whether the chunk has a known encoding and that all of its samples can be decoded.
+func (m *Chunk) Validate() error {
+	c, err := m.Decode()
+	if err != nil {
+		return err
+	}
+	it := c.Iterator(nil)
+	for it.Next() {
+	}
+	return errors.Wrap(it.Err(), "decode samples")
+}
+
+// Validate checks whether the chunk has a sane time range and that all present chunks are valid.
+func (m *AggrChunk) Validate() error {
+	if m.MinTime > m.MaxTime {
+		return errors.Errorf("chunk min time %d is after max time %d", m.MinTime, m.MaxTime)
+	}
+
+	aggrs := m.Aggregates()
+	if len(aggrs) == 0 {
+		return errors.New("no chunk data")
+	}
+	for _, aggr := range aggrs {
+		c, _ := m.Get(aggr)
+		if err := c.Validate(); err != nil {
+			return errors.Wrapf(err, "%s chunk", aggr)
+		}
+	}
+	return nil
+}
+
+// allAggrs lists all chunk types in the order of the AggrChunk fields.
+var allAggrs = []Aggr{Aggr_RAW, Aggr_COUNT, Aggr_SUM, Aggr_MIN, Aggr_MAX, Aggr_COUNTER}
+
+// Get returns the chunk of the given type and whether it is present. Aggr_RAW returns the raw chunk.
+func (m *AggrChunk) Get(aggr Aggr) (*Chunk, bool) {
+	var c *Chunk
+	switch aggr {
+	case Aggr_RAW:
+		c = m.Raw
+	case Aggr_COUNT:
+		c = m.Count
+	case Aggr_SUM:
+		c = m.Sum
+	case Aggr_MIN:
+		c = m.Min
+	case Aggr_MAX:
+		c = m.Max
+	case Aggr_COUNTER:
+		c = m.Counter
+	}
+	return c, c != nil
+}
+
+// Aggregates returns the types of all present chunks, including Aggr_RAW for a raw chunk.
+func (m *AggrChunk) Aggregates() []Aggr {
+	var ret []Aggr
+	for _, aggr := range allAggrs {
+		if _, ok := m.Get(aggr); ok {
+			ret = append(ret, aggr)
+		}
+	}
+	return ret
+}
+
+// ClampTimes resets MinTime and MaxTime of a raw chunk to the timestamps of its first and last sample, e.g. to
+// fix chunks which declare a time range wider than their data. Aggregated chunks and chunks without samples are
+// left unchanged.
+func (m *AggrChunk) ClampTimes() error {
+	if m.Raw == nil {
+		return nil
+	}
+	c, err := m.Raw.Decode()
+	if err != nil {
+		return err
+	}
+
+	var (
+		it         = c.Iterator(nil)
+		mint, maxt int64
+		found      bool
+	)
+	for it.Next() {
+		t, _ := it.At()
+		if !found {
+			mint, found = t, true
+		}
+		maxt = t
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "decode samples")
+	}
+	if found {
+		m.MinTime, m.MaxTime = mint, maxt
+	}
+	return nil
+}
+
+// NewTimeClampingSeriesSet returns a series set which applies AggrChunk.ClampTimes to all chunks, so that time
+// based pruning done later on is accurate. The chunks are copied, so the wrapped set is not modified.
+func NewTimeClampingSeriesSet(s SeriesSet) SeriesSet {
+	return &timeClampingSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}
+}
+
+type timeClampingSeriesSet struct {
+	erroringSeriesSet
+
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *timeClampingSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	s.lset = lset
+	s.chunks = make([]AggrChunk, len(chks))
+	copy(s.chunks, chks)
+	for i := range s.chunks {
+		if err := s.chunks[i].ClampTimes(); err != nil {
+			s.err = errors.Wrapf(err, "series %s chunk %d", LabelsToString(lset), i)
+			return false
+		}
+	}
+	return true
+}
+
+func (s *timeClampingSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
+
+// NewAggregateToRawSeriesSet returns a series set which replaces every downsampled chunk with a raw chunk made from
+// the samples of its aggregate agg, e.g. the Sum or Count aggregate, so that clients only understanding raw data
+// can plot downsampled series. This loses the other aggregates of the chunks. Raw chunks are passed through as is,
+// and the set fails on downsampled chunks without the aggregate. The chunks are copied, so the wrapped set is not
+// modified.
+func NewAggregateToRawSeriesSet(s SeriesSet, agg Aggr) (SeriesSet, error) {
+	if _, ok := Aggr_name[int32(agg)]; !ok || agg == Aggr_RAW {
+		return nil, errors.Errorf("invalid aggregate %s", agg)
+	}
+	return &aggregateToRawSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, agg: agg}, nil
+}
+
+type aggregateToRawSeriesSet struct {
+	erroringSeriesSet
+
+	agg    Aggr
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *aggregateToRawSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	s.lset = lset
+	s.chunks = make([]AggrChunk, 0, len(chks))
+	for i, c := range chks {
+		if c.Raw != nil {
+			s.chunks = append(s.chunks, c)
+			continue
+		}
+		a, ok := c.Get(s.agg)
+		if !ok {
+			s.err = errors.Errorf("series %s: chunk %d has no %s aggregate", LabelsToString(lset), i, s.agg)
+			return false
+		}
+		// Aggregates are encoded like raw chunks, so XOR ones can be used as is.
+		raw := a
+		if a.Type != Chunk_XOR {
+			var err error
+			if raw, err = a.ReEncode(Chunk_XOR); err != nil {
+				s.err = errors.Wrapf(err, "series %s chunk %d", LabelsToString(lset), i)
+				return false
+			}
+		}
+		s.chunks = append(s.chunks, AggrChunk{MinTime: c.MinTime, MaxTime: c.MaxTime, Raw: raw})
+	}
+	return true
+}
+
+func (s *aggregateToRawSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
@@ -0,0 +1,254 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestAggrChunkValidate(t *testing.T) {
+	valid := newSeries(t, labels.FromStrings("a", "a"), [][]sample{{{1, 1}, {2, 2}}}).Chunks[0]
+
+	for _, tcase := range []struct {
+		desc  string
+		chunk AggrChunk
+		ok    bool
+		err   error
+	}{
+		{
+			desc:  "valid raw chunk",
+			chunk: valid,
+			ok:    true,
+		},
+		{
+			desc:  "valid aggregated chunk",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2, Count: valid.Raw, Sum: valid.Raw},
+			ok:    true,
+		},
+		{
+			desc:  "no chunks",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2},
+		},
+		{
+			desc:  "min time after max time",
+			chunk: AggrChunk{MinTime: 3, MaxTime: 2, Raw: valid.Raw},
+		},
+		{
+			desc:  "unknown encoding",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2, Raw: &Chunk{Type: 12, Data: valid.Raw.Data}},
+		},
+		{
+			desc:  "empty data",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2, Raw: &Chunk{Type: Chunk_XOR}},
+			err:   ErrEmptyChunkData,
+		},
+		{
+			desc:  "empty aggregate data",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2, Count: valid.Raw, Sum: &Chunk{Type: Chunk_XOR, Data: []byte{}}},
+			err:   ErrEmptyChunkData,
+		},
+		{
+			desc:  "too short data",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2, Raw: &Chunk{Type: Chunk_XOR, Data: []byte{0}}},
+		},
+		{
+			desc:  "corrupted samples",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2, Raw: &Chunk{Type: Chunk_XOR, Data: []byte{0, 5, 0xff}}},
+		},
+		{
+			desc:  "corrupted aggregate",
+			chunk: AggrChunk{MinTime: 1, MaxTime: 2, Count: valid.Raw, Sum: &Chunk{Type: Chunk_XOR, Data: []byte{0, 5, 0xff}}},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			err := tcase.chunk.Validate()
+			if tcase.ok {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotOk(t, err)
+			if tcase.err != nil {
+				testutil.Equals(t, tcase.err, errors.Cause(err))
+			}
+		})
+	}
+}
+
+func TestAggrChunkClampTimes(t *testing.T) {
+	c := newSeries(t, labels.FromStrings("a", "a"), [][]sample{{{10, 1}, {15, 2}, {20, 3}}}).Chunks[0]
+
+	wide := c
+	wide.MinTime, wide.MaxTime = 0, 100
+	testutil.Ok(t, wide.ClampTimes())
+	testutil.Equals(t, int64(10), wide.MinTime)
+	testutil.Equals(t, int64(20), wide.MaxTime)
+
+	aggr := AggrChunk{MinTime: 0, MaxTime: 100, Count: c.Raw}
+	testutil.Ok(t, aggr.ClampTimes())
+	testutil.Equals(t, int64(0), aggr.MinTime)
+	testutil.Equals(t, int64(100), aggr.MaxTime)
+
+	corrupted := AggrChunk{MinTime: 0, MaxTime: 100, Raw: &Chunk{Type: Chunk_XOR, Data: []byte{0, 5, 0xff}}}
+	testutil.NotOk(t, corrupted.ClampTimes())
+}
+
+func TestTimeClampingSeriesSet(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "a"),
+		chunks: [][]sample{{{10, 1}, {20, 2}}, {{30, 3}, {40, 4}}},
+	}})
+	l.series[0].Chunks[0].MinTime = 0
+	l.series[0].Chunks[1].MaxTime = 100
+
+	s := NewTimeClampingSeriesSet(l)
+	testutil.Assert(t, s.Next(), "expected series")
+	_, chks := s.At()
+	testutil.Equals(t, int64(10), chks[0].MinTime)
+	testutil.Equals(t, int64(40), chks[1].MaxTime)
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+
+	// The wrapped set is untouched.
+	testutil.Equals(t, int64(0), l.series[0].Chunks[0].MinTime)
+	testutil.Equals(t, int64(100), l.series[0].Chunks[1].MaxTime)
+}
+
+func TestAggregateToRawSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{10, 1}, {20, 2}}, {{30, 3}, {40, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{10, 5}}},
+		},
+	}
+	chunks := func(smpls [][]sample) []*Chunk {
+		var ret []*Chunk
+		for _, c := range newSeries(t, nil, smpls).Chunks {
+			ret = append(ret, c.Raw)
+		}
+		return ret
+	}
+	l := newListSeriesSet(t, in)
+	// The first series is downsampled, the second one is raw.
+	sums := chunks([][]sample{{{10, 100}, {20, 200}}, {{30, 300}, {40, 400}}})
+	counts := chunks([][]sample{{{10, 2}, {20, 2}}, {{30, 2}, {40, 2}}})
+	for i := range l.series[0].Chunks {
+		l.series[0].Chunks[i].Raw, l.series[0].Chunks[i].Sum, l.series[0].Chunks[i].Count = nil, sums[i], counts[i]
+	}
+
+	s, err := NewAggregateToRawSeriesSet(l, Aggr_SUM)
+	testutil.Ok(t, err)
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{10, 100}, {20, 200}}, {{30, 300}, {40, 400}}},
+		},
+		in[1],
+	}, s)
+	testutil.Ok(t, s.Err())
+
+	// The wrapped set is untouched.
+	testutil.Assert(t, l.series[0].Chunks[0].Raw == nil, "expected no raw chunk")
+	testutil.Equals(t, sums[0], l.series[0].Chunks[0].Sum)
+
+	l.idx = -1
+	s, err = NewAggregateToRawSeriesSet(l, Aggr_MAX)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !s.Next(), "expected no series")
+	testutil.NotOk(t, s.Err())
+
+	_, err = NewAggregateToRawSeriesSet(l, Aggr_RAW)
+	testutil.NotOk(t, err)
+	_, err = NewAggregateToRawSeriesSet(l, Aggr(100))
+	testutil.NotOk(t, err)
+}
+
+func TestChunkReEncode(t *testing.T) {
+	in := []sample{{1, 1.5}, {2, -2.25}, {10, 1e10}, {11, 0.1}}
+	c := newSeries(t, labels.FromStrings("a", "a"), [][]sample{in}).Chunks[0].Raw
+
+	out, err := c.ReEncode(Chunk_XOR)
+	testutil.Ok(t, err)
+	testutil.Equals(t, Chunk_XOR, out.Type)
+
+	chk, err := out.Decode()
+	testutil.Ok(t, err)
+	var got []sample
+	it := chk.Iterator(nil)
+	for it.Next() {
+		ts, v := it.At()
+		got = append(got, sample{ts, v})
+	}
+	testutil.Ok(t, it.Err())
+	testutil.Equals(t, len(in), len(got))
+	for i := range in {
+		testutil.Equals(t, in[i].t, got[i].t)
+		testutil.Assert(t, math.Abs(in[i].v-got[i].v) < 1e-9, "unexpected value %v, expected %v", got[i].v, in[i].v)
+	}
+
+	_, err = c.ReEncode(Chunk_Encoding(42))
+	testutil.NotOk(t, err)
+
+	_, err = (&Chunk{Type: Chunk_XOR, Data: []byte{0, 5, 0xff}}).ReEncode(Chunk_XOR)
+	testutil.NotOk(t, err)
+}
+
+func TestAggrChunkGet(t *testing.T) {
+	chks := make([]*Chunk, len(allAggrs))
+	for i := range chks {
+		chks[i] = &Chunk{Type: Chunk_XOR, Data: []byte{byte(i)}}
+	}
+	full := AggrChunk{Raw: chks[0], Count: chks[1], Sum: chks[2], Min: chks[3], Max: chks[4], Counter: chks[5]}
+	testutil.Equals(t, []Aggr{Aggr_RAW, Aggr_COUNT, Aggr_SUM, Aggr_MIN, Aggr_MAX, Aggr_COUNTER}, full.Aggregates())
+
+	var empty AggrChunk
+	testutil.Equals(t, 0, len(empty.Aggregates()))
+
+	for i, aggr := range allAggrs {
+		t.Run(aggr.String(), func(t *testing.T) {
+			c, ok := full.Get(aggr)
+			testutil.Assert(t, ok, "expected chunk to be present")
+			testutil.Equals(t, chks[i], c)
+
+			c, ok = empty.Get(aggr)
+			testutil.Assert(t, !ok, "expected chunk to be absent")
+			testutil.Assert(t, c == nil, "expected nil chunk")
+		})
+	}
+
+	partial := AggrChunk{Sum: chks[2], Count: chks[1]}
+	testutil.Equals(t, []Aggr{Aggr_COUNT, Aggr_SUM}, partial.Aggregates())
+	_, ok := partial.Get(Aggr_MAX)
+	testutil.Assert(t, !ok, "expected chunk to be absent")
+	_, ok = partial.Get(Aggr(100))
+	testutil.Assert(t, !ok, "expected unknown type to be absent")
+}
+
+func TestMergeSeriesSetsWithOptions_SkipEmptyChunks(t *testing.T) {
+	a := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "a"),
+		chunks: [][]sample{{{1, 1}, {2, 2}}},
+	}})
+	a.series[0].Chunks = append(a.series[0].Chunks, AggrChunk{MinTime: 3, MaxTime: 4, Raw: &Chunk{Type: Chunk_XOR}})
+
+	ss := MergeSeriesSetsWithOptions(MergeOptions{SkipInvalidChunks: true}, a)
+	seriesEquals(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "a"),
+		chunks: [][]sample{{{1, 1}, {2, 2}}},
+	}}, ss)
+	testutil.Ok(t, ss.Err())
+
+	warns := ss.Warnings()
+	testutil.Equals(t, 1, len(warns))
+	testutil.Assert(t, strings.Contains(warns[0], ErrEmptyChunkData.Error()), "unexpected warning %q", warns[0])
+}
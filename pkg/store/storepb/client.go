@@ -0,0 +1,295 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+)
+
+// HintsSeriesSet is a WarningsSeriesSet that also collects the hints received along with the series.
+type HintsSeriesSet interface {
+	WarningsSeriesSet
+	// Hints returns the hints received so far. The result is only complete after the set is fully drained.
+	Hints() []*types.Any
+	// QueriedBlocks returns the sorted union of the IDs of the queried blocks reported through
+	// hintspb.SeriesResponseHints, e.g. to find blocks queried by more than one store. The result is only complete
+	// after the set is fully drained.
+	QueriedBlocks() []string
+	// MergedHints returns a single hintspb.SeriesResponseHints with the union of the queried blocks of all
+	// received series response hints, e.g. to forward it to the client. The result is only complete after the set
+	// is fully drained.
+	MergedHints() (*types.Any, error)
+}
+
+// MergeSeriesClients adapts the given Series streams to series sets and merges them, separating series from
+// warnings and hints of the responses. Each stream has to return its series sorted.
+//
+// A stream failing to receive, or sending series response hints which cannot be decoded, is handled according to
+// the strategy, see MergeSeriesSetsWithStrategy. The first series of every stream is received on creation, so with
+// the ABORT strategy errors of streams failing right away are returned directly.
+func MergeSeriesClients(strategy PartialResponseStrategy, clients ...Store_SeriesClient) (HintsSeriesSet, error) {
+	s := &clientsSeriesSet{}
+
+	sets := make([]SeriesSet, 0, len(clients))
+	for i, c := range clients {
+		p := NewPeekSeriesSet(&seriesClientSeriesSet{client: c, name: i, parent: s})
+		_, _, _ = p.Peek()
+		if err := p.Err(); err != nil && strategy == PartialResponseStrategy_ABORT {
+			return nil, err
+		}
+		sets = append(sets, p)
+	}
+	s.SeriesSet = mergeSeriesSetsWithStrategy(strategy, &s.warns, sets...)
+	return s, nil
+}
+
+type clientsSeriesSet struct {
+	warningsSeriesSet
+
+	hints []*types.Any
+	// queried holds the queried blocks of all received series response hints, including duplicates.
+	queried hintspb.SeriesResponseHints
+}
+
+func (s *clientsSeriesSet) Hints() []*types.Any {
+	return s.hints
+}
+
+func (s *clientsSeriesSet) QueriedBlocks() []string {
+	seen := make(map[string]struct{}, len(s.queried.QueriedBlocks))
+	ret := make([]string, 0, len(s.queried.QueriedBlocks))
+	for _, b := range s.queried.QueriedBlocks {
+		if _, ok := seen[b.Id]; ok {
+			continue
+		}
+		seen[b.Id] = struct{}{}
+		ret = append(ret, b.Id)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *clientsSeriesSet) MergedHints() (*types.Any, error) {
+	var merged hintspb.SeriesResponseHints
+	for _, id := range s.QueriedBlocks() {
+		merged.QueriedBlocks = append(merged.QueriedBlocks, hintspb.Block{Id: id})
+	}
+	return types.MarshalAny(&merged)
+}
+
+func (s *clientsSeriesSet) addHints(h *types.Any) error {
+	s.hints = append(s.hints, h)
+	if !types.Is(h, &s.queried) {
+		return nil
+	}
+	return hintspb.MergeHints([]*types.Any{h}, &s.queried)
+}
+
+// seriesClientSeriesSet is a series set receiving series from a Series stream.
+type seriesClientSeriesSet struct {
+	client Store_SeriesClient
+	name   int
+	parent *clientsSeriesSet
+
+	curr *Series
+	done bool
+	err  error
+}
+
+func (s *seriesClientSeriesSet) Next() bool {
+	if s.done {
+		return false
+	}
+	for {
+		r, err := s.client.Recv()
+		if err == io.EOF {
+			s.done = true
+			return false
+		}
+		if err != nil {
+			s.done, s.err = true, errors.Wrapf(err, "receive series from stream %d", s.name)
+			return false
+		}
+
+		if w := r.GetWarning(); w != "" {
+			s.parent.warns = append(s.parent.warns, w)
+		}
+		if h := r.GetHints(); h != nil {
+			if err := s.parent.addHints(h); err != nil {
+				s.done, s.err = true, errors.Wrapf(err, "stream %d", s.name)
+				return false
+			}
+		}
+		if series := r.GetSeries(); series != nil {
+			s.curr = series
+			return true
+		}
+	}
+}
+
+func (s *seriesClientSeriesSet) At() ([]Label, []AggrChunk) {
+	if s.curr == nil {
+		return nil, nil
+	}
+	return s.curr.Labels, s.curr.Chunks
+}
+
+func (s *seriesClientSeriesSet) Err() error {
+	return s.err
+}
+
+// ClosableHintsSeriesSet is a HintsSeriesSet which has to be closed if it is not drained.
+type ClosableHintsSeriesSet interface {
+	HintsSeriesSet
+	// Close releases the resources of the set. Next returns false afterwards.
+	Close()
+}
+
+// NewBoundedMergeSeriesSet works like MergeSeriesClients with the ABORT strategy, but receives from every stream
+// in a separate goroutine, buffering at most bufferPerSource responses per stream. Streams are received from only
+// as fast as the merged set is drained, so fast stores are slowed down by gRPC flow control instead of being
+// buffered in memory while the consumer is slow.
+//
+// cancel has to cancel the context all streams were created with. The set calls it once it is drained or fails,
+// e.g. on the first stream error, and on Close, which cancels the streams and so stops all goroutines, including
+// those blocked in Recv. The goroutines also stop on cancellation of the context by the caller; Next returns false
+// with the context error then.
+func NewBoundedMergeSeriesSet(cancel context.CancelFunc, bufferPerSource int, clients ...Store_SeriesClient) (ClosableHintsSeriesSet, error) {
+	if bufferPerSource < 0 {
+		return nil, errors.Errorf("invalid buffer size %d", bufferPerSource)
+	}
+
+	s := &boundedMergeSeriesSet{cancel: cancel}
+	sets := make([]SeriesSet, 0, len(clients))
+	for i, c := range clients {
+		b := &boundedClientSeriesSet{
+			ctx:    c.Context(),
+			ch:     make(chan boundedResponse, bufferPerSource),
+			name:   i,
+			parent: &s.clientsSeriesSet,
+		}
+		go b.receive(c)
+		sets = append(sets, b)
+	}
+	s.SeriesSet = mergeSeriesSetsWithStrategy(PartialResponseStrategy_ABORT, &s.warns, sets...)
+	return s, nil
+}
+
+// boundedMergeSeriesSet stops all receiving goroutines once the merge is finished or closed.
+type boundedMergeSeriesSet struct {
+	clientsSeriesSet
+
+	cancel context.CancelFunc
+	closed bool
+}
+
+func (s *boundedMergeSeriesSet) Next() bool {
+	if s.closed {
+		return false
+	}
+	if s.clientsSeriesSet.Next() {
+		return true
+	}
+	s.Close()
+	return false
+}
+
+func (s *boundedMergeSeriesSet) Close() {
+	s.closed = true
+	s.cancel()
+}
+
+type boundedResponse struct {
+	r   *SeriesResponse
+	err error
+}
+
+// boundedClientSeriesSet is a series set of the responses received from a Series stream by receive.
+type boundedClientSeriesSet struct {
+	ctx    context.Context
+	ch     chan boundedResponse
+	name   int
+	parent *clientsSeriesSet
+
+	curr *Series
+	done bool
+	err  error
+}
+
+// receive sends all responses of the stream to the channel and closes it at the end of the stream. It stops early
+// on errors, which are sent as the last response, and on cancellation of the stream context.
+func (s *boundedClientSeriesSet) receive(c Store_SeriesClient) {
+	defer close(s.ch)
+	for {
+		r, err := c.Recv()
+		if err == io.EOF {
+			return
+		}
+		select {
+		case s.ch <- boundedResponse{r: r, err: err}:
+		case <-s.ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *boundedClientSeriesSet) Next() bool {
+	if s.done {
+		return false
+	}
+	for {
+		var (
+			resp boundedResponse
+			ok   bool
+		)
+		select {
+		case resp, ok = <-s.ch:
+		case <-s.ctx.Done():
+			s.done, s.err = true, errors.Wrapf(s.ctx.Err(), "receive series from stream %d", s.name)
+			return false
+		}
+		if !ok {
+			s.done = true
+			return false
+		}
+		if resp.err != nil {
+			s.done, s.err = true, errors.Wrapf(resp.err, "receive series from stream %d", s.name)
+			return false
+		}
+
+		if w := resp.r.GetWarning(); w != "" {
+			s.parent.warns = append(s.parent.warns, w)
+		}
+		if h := resp.r.GetHints(); h != nil {
+			if err := s.parent.addHints(h); err != nil {
+				s.done, s.err = true, errors.Wrapf(err, "stream %d", s.name)
+				return false
+			}
+		}
+		if series := resp.r.GetSeries(); series != nil {
+			s.curr = series
+			return true
+		}
+	}
+}
+
+func (s *boundedClientSeriesSet) At() ([]Label, []AggrChunk) {
+	if s.curr == nil {
+		return nil, nil
+	}
+	return s.curr.Labels, s.curr.Chunks
+}
+
+func (s *boundedClientSeriesSet) Err() error {
+	return s.err
+}
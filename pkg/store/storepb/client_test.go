@@ -0,0 +1,286 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/hintspb"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+)
+
+type testSeriesClient struct {
+	grpc.ClientStream
+
+	responses []*SeriesResponse
+	err       error
+}
+
+func (c *testSeriesClient) Context() context.Context { return context.Background() }
+
+func (c *testSeriesClient) Recv() (*SeriesResponse, error) {
+	if len(c.responses) == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, io.EOF
+	}
+	r := c.responses[0]
+	c.responses = c.responses[1:]
+	return r, nil
+}
+
+func TestMergeSeriesClients(t *testing.T) {
+	a := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}, {2, 2}}})
+	b := newSeries(t, labels.FromStrings("a", "2"), [][]sample{{{1, 1}}})
+	c := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{3, 3}}})
+	hint := &types.Any{TypeUrl: "hint"}
+
+	newClients := func(err error) []Store_SeriesClient {
+		return []Store_SeriesClient{
+			&testSeriesClient{responses: []*SeriesResponse{
+				NewWarnSeriesResponse(errors.New("warning 1")),
+				NewSeriesResponse(&a),
+				NewHintsSeriesResponse(hint),
+				NewSeriesResponse(&b),
+			}},
+			&testSeriesClient{responses: []*SeriesResponse{
+				NewSeriesResponse(&c),
+			}, err: err},
+		}
+	}
+	expected := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}
+
+	t.Run("no errors", func(t *testing.T) {
+		s, err := MergeSeriesClients(PartialResponseStrategy_ABORT, newClients(nil)...)
+		testutil.Ok(t, err)
+		seriesEquals(t, expected, s)
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, []string{"warning 1"}, s.Warnings())
+		testutil.Equals(t, []*types.Any{hint}, s.Hints())
+	})
+	t.Run("stream error with warn", func(t *testing.T) {
+		s, err := MergeSeriesClients(PartialResponseStrategy_WARN, newClients(errors.New("connection reset"))...)
+		testutil.Ok(t, err)
+		seriesEquals(t, expected, s)
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, []string{"warning 1", "receive series from stream 1: connection reset"}, s.Warnings())
+	})
+	t.Run("stream error with abort", func(t *testing.T) {
+		s, err := MergeSeriesClients(PartialResponseStrategy_ABORT, newClients(errors.New("connection reset"))...)
+		testutil.Ok(t, err)
+		for s.Next() {
+		}
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "receive series from stream 1: connection reset", s.Err().Error())
+	})
+	t.Run("first receive error with abort", func(t *testing.T) {
+		_, err := MergeSeriesClients(PartialResponseStrategy_ABORT, &testSeriesClient{err: errors.New("unavailable")})
+		testutil.NotOk(t, err)
+	})
+}
+
+func TestMergeSeriesClients_QueriedBlocks(t *testing.T) {
+	blockHints := func(ids ...string) *types.Any {
+		h := &hintspb.SeriesResponseHints{}
+		for _, id := range ids {
+			h.QueriedBlocks = append(h.QueriedBlocks, hintspb.Block{Id: id})
+		}
+		ret, err := types.MarshalAny(h)
+		testutil.Ok(t, err)
+		return ret
+	}
+	a := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}}})
+	other := &types.Any{TypeUrl: "other"}
+
+	s, err := MergeSeriesClients(PartialResponseStrategy_ABORT,
+		&testSeriesClient{responses: []*SeriesResponse{
+			NewSeriesResponse(&a),
+			NewHintsSeriesResponse(blockHints("block-3", "block-1")),
+		}},
+		&testSeriesClient{responses: []*SeriesResponse{
+			NewHintsSeriesResponse(other),
+			NewHintsSeriesResponse(blockHints("block-2", "block-3")),
+		}},
+	)
+	testutil.Ok(t, err)
+	for s.Next() {
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, []string{"block-1", "block-2", "block-3"}, s.QueriedBlocks())
+	testutil.Equals(t, 3, len(s.Hints()))
+
+	merged, err := s.MergedHints()
+	testutil.Ok(t, err)
+	var hints hintspb.SeriesResponseHints
+	testutil.Ok(t, types.UnmarshalAny(merged, &hints))
+	testutil.Equals(t, []hintspb.Block{{Id: "block-1"}, {Id: "block-2"}, {Id: "block-3"}}, hints.QueriedBlocks)
+
+	t.Run("invalid hints", func(t *testing.T) {
+		invalid := &types.Any{TypeUrl: blockHints().TypeUrl, Value: []byte{0xff}}
+		newClient := func() Store_SeriesClient {
+			return &testSeriesClient{responses: []*SeriesResponse{NewHintsSeriesResponse(invalid)}}
+		}
+
+		_, err := MergeSeriesClients(PartialResponseStrategy_ABORT, newClient())
+		testutil.NotOk(t, err)
+
+		s, err := MergeSeriesClients(PartialResponseStrategy_WARN, newClient())
+		testutil.Ok(t, err)
+		testutil.Assert(t, !s.Next(), "expected no series")
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, 1, len(s.Warnings()))
+		testutil.Equals(t, []string{}, s.QueriedBlocks())
+	})
+}
+
+// countingSeriesClient returns n series with increasing labels, counting the calls to Recv. With n < 0, it returns
+// series until its context is canceled.
+type countingSeriesClient struct {
+	grpc.ClientStream
+
+	ctx   context.Context
+	n     int
+	recvs int64
+}
+
+func (c *countingSeriesClient) Context() context.Context { return c.ctx }
+
+func (c *countingSeriesClient) Recv() (*SeriesResponse, error) {
+	i := int(atomic.AddInt64(&c.recvs, 1)) - 1
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.n >= 0 && i >= c.n {
+		return nil, io.EOF
+	}
+	return NewSeriesResponse(&Series{Labels: []Label{{Name: "a", Value: fmt.Sprintf("%05d", i)}}}), nil
+}
+
+// blockingSeriesClient blocks in Recv until its context is cancelled.
+type blockingSeriesClient struct {
+	grpc.ClientStream
+
+	ctx context.Context
+}
+
+func (c *blockingSeriesClient) Context() context.Context { return c.ctx }
+
+func (c *blockingSeriesClient) Recv() (*SeriesResponse, error) {
+	<-c.ctx.Done()
+	return nil, c.ctx.Err()
+}
+
+func TestBoundedMergeSeriesSet(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	t.Run("backpressure", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		a := &countingSeriesClient{ctx: ctx, n: 100}
+		b := &countingSeriesClient{ctx: ctx, n: 10}
+		s, err := NewBoundedMergeSeriesSet(cancel, 2, a, b)
+		testutil.Ok(t, err)
+
+		// Nothing is received beyond the buffers while the consumer is slow: the merge holds the current and the
+		// look-ahead series of both streams, two more are buffered and the receiving goroutine blocks on sending the
+		// next one.
+		testutil.Assert(t, s.Next(), "expected series")
+
+		retryCtx, retryCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer retryCancel()
+		for _, c := range []*countingSeriesClient{a, b} {
+			testutil.Ok(t, runutil.Retry(10*time.Millisecond, retryCtx.Done(), func() error {
+				if n := atomic.LoadInt64(&c.recvs); n != 5 {
+					return errors.Errorf("%d receives", n)
+				}
+				return nil
+			}))
+		}
+		time.Sleep(50 * time.Millisecond)
+		testutil.Equals(t, int64(5), atomic.LoadInt64(&a.recvs))
+		testutil.Equals(t, int64(5), atomic.LoadInt64(&b.recvs))
+
+		n := 1
+		for s.Next() {
+			n++
+		}
+		testutil.Ok(t, s.Err())
+		// Series of both streams have equal labels up to 10.
+		testutil.Equals(t, 100, n)
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := NewBoundedMergeSeriesSet(cancel, 1, &countingSeriesClient{ctx: ctx, n: -1}, &countingSeriesClient{ctx: ctx, n: -1})
+		testutil.Ok(t, err)
+
+		testutil.Assert(t, s.Next(), "expected series")
+		cancel()
+		for s.Next() {
+		}
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, context.Canceled, errors.Cause(s.Err()))
+	})
+	t.Run("stream error stops other streams", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := NewBoundedMergeSeriesSet(cancel, 1,
+			&countingSeriesClient{ctx: ctx, n: -1},
+			&testSeriesClient{err: errors.New("connection reset")},
+		)
+		testutil.Ok(t, err)
+		for s.Next() {
+		}
+		testutil.Equals(t, "receive series from stream 1: connection reset", s.Err().Error())
+	})
+	t.Run("close", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := NewBoundedMergeSeriesSet(cancel, 1, &countingSeriesClient{ctx: ctx, n: -1}, &countingSeriesClient{ctx: ctx, n: -1})
+		testutil.Ok(t, err)
+
+		testutil.Assert(t, s.Next(), "expected series")
+		s.Close()
+		testutil.Assert(t, !s.Next(), "expected no series after close")
+	})
+	t.Run("close stops blocked receive", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := NewBoundedMergeSeriesSet(cancel, 1, &blockingSeriesClient{ctx: ctx}, &blockingSeriesClient{ctx: ctx})
+		testutil.Ok(t, err)
+
+		// Both goroutines are blocked in Recv; only cancelling the streams' context lets them exit, which leaktest
+		// checks at the end of the test.
+		s.Close()
+		testutil.Assert(t, !s.Next(), "expected no series after close")
+	})
+	t.Run("stream error", func(t *testing.T) {
+		_, cancel := context.WithCancel(context.Background())
+		s, err := NewBoundedMergeSeriesSet(cancel, 0, &testSeriesClient{err: errors.New("connection reset")})
+		testutil.Ok(t, err)
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.Equals(t, "receive series from stream 0: connection reset", s.Err().Error())
+	})
+
+	_, err := NewBoundedMergeSeriesSet(func() {}, -1)
+	testutil.NotOk(t, err)
+}
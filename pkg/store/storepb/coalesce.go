@@ -0,0 +1,117 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// NewContiguousCoalescingSeriesSet returns a series set which merges adjacent raw chunks of a series into a single
+// re-encoded chunk if the MinTime of a chunk immediately follows the MaxTime of the previous one, i.e. there is
+// neither a gap nor an overlap between them. This defragments series e.g. for compaction without changing data.
+// Aggregated chunks and chunks with gaps or overlaps are kept as is. As XOR chunks hold at most 65535 samples,
+// merging stops once a chunk is full. An error is returned if s has failed already.
+func NewContiguousCoalescingSeriesSet(s SeriesSet) (SeriesSet, error) {
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "wrapped series set")
+	}
+	return &contiguousCoalescingSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}, nil
+}
+
+type contiguousCoalescingSeriesSet struct {
+	erroringSeriesSet
+
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *contiguousCoalescingSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	s.lset = lset
+	s.chunks, s.err = coalesceContiguous(chks)
+	if s.err != nil {
+		s.err = errors.Wrapf(s.err, "series %s", LabelsToString(lset))
+		return false
+	}
+	return true
+}
+
+func (s *contiguousCoalescingSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
+
+func isContiguous(prev, next AggrChunk) bool {
+	return prev.Raw != nil && next.Raw != nil && prev.MaxTime != math.MaxInt64 && next.MinTime == prev.MaxTime+1
+}
+
+func coalesceContiguous(chks []AggrChunk) ([]AggrChunk, error) {
+	ret := make([]AggrChunk, 0, len(chks))
+	for i := 0; i < len(chks); {
+		j := i + 1
+		for j < len(chks) && isContiguous(chks[j-1], chks[j]) {
+			j++
+		}
+		if j-i == 1 {
+			ret = append(ret, chks[i])
+			i = j
+			continue
+		}
+
+		merged, err := mergeRawChunks(chks[i:j])
+		if err != nil {
+			return nil, errors.Wrapf(err, "chunks %d to %d", i, j-1)
+		}
+		ret = append(ret, merged...)
+		i = j
+	}
+	return ret, nil
+}
+
+// mergeRawChunks re-encodes the samples of the given raw chunks into as few chunks as possible.
+func mergeRawChunks(chks []AggrChunk) ([]AggrChunk, error) {
+	var (
+		ret        []AggrChunk
+		curr       *chunkenc.XORChunk
+		app        chunkenc.Appender
+		mint, maxt int64
+	)
+	flush := func() {
+		ret = append(ret, AggrChunk{MinTime: mint, MaxTime: maxt, Raw: &Chunk{Type: Chunk_XOR, Data: curr.Bytes()}})
+	}
+	for _, c := range chks {
+		src, err := c.Raw.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if curr != nil && curr.NumSamples()+src.NumSamples() > math.MaxUint16 {
+			flush()
+			curr = nil
+		}
+		if curr == nil {
+			curr = chunkenc.NewXORChunk()
+			if app, err = curr.Appender(); err != nil {
+				return nil, err
+			}
+			mint = c.MinTime
+		}
+
+		it := src.Iterator(nil)
+		for it.Next() {
+			app.Append(it.At())
+		}
+		if err := it.Err(); err != nil {
+			return nil, errors.Wrap(err, "decode samples")
+		}
+		maxt = c.MaxTime
+	}
+	flush()
+	return ret, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestContiguousCoalescingSeriesSet(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{
+		{
+			lset: labels.FromStrings("a", "1"),
+			// Contiguous, contiguous, gap, contiguous, overlap.
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{4, 4}, {5, 5}}, {{10, 10}}, {{11, 11}, {12, 12}}, {{12, 13}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}}, {{5, 5}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3"),
+			chunks: [][]sample{{{1, 1}}, {{2, 2}}, {{3, 3}}},
+		},
+	})
+	// Aggregated chunks are never merged.
+	l.series[2].Chunks[1].Count, l.series[2].Chunks[1].Raw = l.series[2].Chunks[1].Raw, nil
+
+	s, err := NewContiguousCoalescingSeriesSet(l)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, s.Next(), "expected first series")
+	_, chks := s.At()
+	testutil.Equals(t, []AggrChunk{{MinTime: 1, MaxTime: 5}, {MinTime: 10, MaxTime: 12}, {MinTime: 12, MaxTime: 12}}, chunkTimes(chks))
+	testutil.Equals(t, 5, chunkSamples(t, chks[0]))
+	testutil.Equals(t, 3, chunkSamples(t, chks[1]))
+
+	testutil.Assert(t, s.Next(), "expected second series")
+	_, chks = s.At()
+	testutil.Equals(t, l.series[1].Chunks, chks)
+
+	testutil.Assert(t, s.Next(), "expected third series")
+	_, chks = s.At()
+	testutil.Equals(t, l.series[2].Chunks, chks)
+
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+}
+
+func TestContiguousCoalescingSeriesSet_FullChunks(t *testing.T) {
+	var (
+		smpls []sample
+		ts    int64
+	)
+	for i := 0; i < math.MaxUint16/2+1; i++ {
+		smpls = append(smpls, sample{t: ts, v: float64(i)})
+		ts++
+	}
+	var more []sample
+	for i := 0; i < 100; i++ {
+		more = append(more, sample{t: ts, v: float64(i)})
+		ts++
+	}
+	var full []sample
+	for _, smpl := range smpls {
+		full = append(full, sample{t: smpl.t + ts, v: smpl.v})
+	}
+
+	s, err := NewContiguousCoalescingSeriesSet(newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "1"),
+		chunks: [][]sample{smpls, more, full},
+	}}))
+	testutil.Ok(t, err)
+	testutil.Assert(t, s.Next(), "expected series")
+	_, chks := s.At()
+	testutil.Equals(t, 2, len(chks))
+	testutil.Equals(t, len(smpls)+len(more), chunkSamples(t, chks[0]))
+	testutil.Equals(t, len(full), chunkSamples(t, chks[1]))
+	testutil.Equals(t, AggrChunk{MinTime: 0, MaxTime: more[len(more)-1].t}, chunkTimes(chks)[0])
+}
+
+func TestContiguousCoalescingSeriesSet_FailedSet(t *testing.T) {
+	_, err := NewContiguousCoalescingSeriesSet(errSeriesSet{err: errors.New("test")})
+	testutil.NotOk(t, err)
+}
+
+func chunkTimes(chks []AggrChunk) []AggrChunk {
+	ret := make([]AggrChunk, 0, len(chks))
+	for _, c := range chks {
+		ret = append(ret, AggrChunk{MinTime: c.MinTime, MaxTime: c.MaxTime})
+	}
+	return ret
+}
+
+func chunkSamples(t *testing.T, c AggrChunk) int {
+	chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+	testutil.Ok(t, err)
+	return chk.NumSamples()
+}
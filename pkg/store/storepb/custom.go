@@ -4,12 +4,18 @@
 package storepb
 
 import (
+	"bytes"
+	"hash"
+	"sort"
 	"strings"
 	"unsafe"
 
+	"github.com/cespare/xxhash"
 	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"google.golang.org/grpc/codes"
 )
 
 var PartialResponseStrategyValues = func() []string {
@@ -28,6 +34,54 @@ func NewWarnSeriesResponse(err error) *SeriesResponse {
 	}
 }
 
+// NewWarnSeriesResponseWithCode works like NewWarnSeriesResponse, but keeps the given gRPC status code with the
+// warning, e.g. so that the querier can map store warnings to HTTP statuses, see WarningCode. The warning is
+// formatted like the error of a gRPC status, so it stays readable by consumers treating warnings as plain strings.
+func NewWarnSeriesResponseWithCode(err error, code codes.Code) *SeriesResponse {
+	return &SeriesResponse{
+		Result: &SeriesResponse_Warning{
+			Warning: warningWithCode(err, code),
+		},
+	}
+}
+
+// warningWithCode returns the warning of err with the given gRPC status code, see NewWarnSeriesResponseWithCode.
+func warningWithCode(err error, code codes.Code) string {
+	return warningCodePrefix + code.String() + warningCodeSep + err.Error()
+}
+
+const (
+	warningCodePrefix = "rpc error: code = "
+	warningCodeSep    = " desc = "
+)
+
+// warningCodes maps the names of all gRPC status codes to the code.
+var warningCodes = func() map[string]codes.Code {
+	m := map[string]codes.Code{}
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		m[c.String()] = c
+	}
+	return m
+}()
+
+// WarningCode returns the gRPC status code and message of a warning created with NewWarnSeriesResponseWithCode.
+// Plain warnings are returned as is with codes.Unknown.
+func WarningCode(warning string) (codes.Code, string) {
+	if !strings.HasPrefix(warning, warningCodePrefix) {
+		return codes.Unknown, warning
+	}
+	rest := warning[len(warningCodePrefix):]
+	i := strings.Index(rest, warningCodeSep)
+	if i < 0 {
+		return codes.Unknown, warning
+	}
+	code, ok := warningCodes[rest[:i]]
+	if !ok {
+		return codes.Unknown, warning
+	}
+	return code, rest[i+len(warningCodeSep):]
+}
+
 func NewSeriesResponse(series *Series) *SeriesResponse {
 	return &SeriesResponse{
 		Result: &SeriesResponse_Series{
@@ -44,17 +98,51 @@ func NewHintsSeriesResponse(hints *types.Any) *SeriesResponse {
 	}
 }
 
+// SeriesResponseVisitor handles the different results of series responses, see SeriesResponse.Accept. Returning
+// an error stops processing.
+type SeriesResponseVisitor interface {
+	OnSeries(series *Series) error
+	OnWarning(warning string) error
+	OnHints(hints *types.Any) error
+}
+
+// Accept calls the method of v matching the result of the response and returns its error. It fails for responses
+// without a result.
+func (m *SeriesResponse) Accept(v SeriesResponseVisitor) error {
+	switch r := m.Result.(type) {
+	case *SeriesResponse_Series:
+		return v.OnSeries(r.Series)
+	case *SeriesResponse_Warning:
+		return v.OnWarning(r.Warning)
+	case *SeriesResponse_Hints:
+		return v.OnHints(r.Hints)
+	}
+	return errors.Errorf("unknown series response result %T", m.Result)
+}
+
+// EstimateSeriesResponseSize returns the size of the series response for the given series once marshaled, e.g. to
+// decide how many chunks fit into a message under the gRPC message size limit. It relies on the generated Size
+// methods, so it does not marshal and is exact.
+func EstimateSeriesResponseSize(lset []Label, chunks []AggrChunk) int {
+	return NewSeriesResponse(&Series{Labels: lset, Chunks: chunks}).Size()
+}
+
 // CompareLabels compares two sets of labels.
+// Empty and nil sets are equal to each other and sort before any other set.
 func CompareLabels(a, b []Label) int {
 	l := len(a)
 	if len(b) < l {
 		l = len(b)
 	}
-	for i := 0; i < l; i++ {
-		if d := strings.Compare(a[i].Name, b[i].Name); d != 0 {
+	// Most comparisons are decided by the first label (usually __name__) or find it to be equal, so check it
+	// before entering the loop.
+	if l > 0 {
+		if d := compareLabel(a[0], b[0]); d != 0 {
 			return d
 		}
-		if d := strings.Compare(a[i].Value, b[i].Value); d != 0 {
+	}
+	for i := 1; i < l; i++ {
+		if d := compareLabel(a[i], b[i]); d != 0 {
 			return d
 		}
 	}
@@ -62,6 +150,105 @@ func CompareLabels(a, b []Label) int {
 	return len(a) - len(b)
 }
 
+// compareLabel compares names and then values. Equality is checked first, as it compiles to a length check and
+// memequal, which is cheaper than an ordering comparison for the common case of equal strings.
+func compareLabel(a, b Label) int {
+	if a.Name != b.Name {
+		if a.Name < b.Name {
+			return -1
+		}
+		return 1
+	}
+	if a.Value != b.Value {
+		if a.Value < b.Value {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// DifferOnlyInReplica returns true if the two sets of labels are equal after removing the given replica labels,
+// i.e. if they are the same series scraped by different replicas of a HA pair. It does not allocate.
+func DifferOnlyInReplica(a, b []Label, replicaLabels []string) bool {
+	i, j := 0, 0
+	for {
+		for i < len(a) && isReplicaLabel(a[i].Name, replicaLabels) {
+			i++
+		}
+		for j < len(b) && isReplicaLabel(b[j].Name, replicaLabels) {
+			j++
+		}
+		if i == len(a) || j == len(b) {
+			return i == len(a) && j == len(b)
+		}
+		if a[i] != b[j] {
+			return false
+		}
+		i++
+		j++
+	}
+}
+
+// LabelsEqualFold returns true if the two sets of labels are equal, comparing the values of the labels named in
+// foldLabels case-insensitively, e.g. to treat hostnames differing only in case as the same series. It does not
+// allocate.
+func LabelsEqualFold(a, b []Label, foldLabels map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+		if a[i].Value == b[i].Value {
+			continue
+		}
+		if _, ok := foldLabels[a[i].Name]; !ok || !strings.EqualFold(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelNameSetDiff returns the sorted distinct label names which only occur in series of a and of b respectively,
+// e.g. to detect stores exposing different labels for the same data.
+func LabelNameSetDiff(a, b [][]Label) (onlyA, onlyB []string) {
+	namesA, namesB := labelNames(a), labelNames(b)
+	for n := range namesA {
+		if _, ok := namesB[n]; !ok {
+			onlyA = append(onlyA, n)
+		}
+	}
+	for n := range namesB {
+		if _, ok := namesA[n]; !ok {
+			onlyB = append(onlyB, n)
+		}
+	}
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+	return onlyA, onlyB
+}
+
+func labelNames(lsets [][]Label) map[string]struct{} {
+	ret := map[string]struct{}{}
+	for _, lset := range lsets {
+		for _, l := range lset {
+			ret[l.Name] = struct{}{}
+		}
+	}
+	return ret
+}
+
+func isReplicaLabel(name string, replicaLabels []string) bool {
+	for _, r := range replicaLabels {
+		if name == r {
+			return true
+		}
+	}
+	return false
+}
+
 type emptySeriesSet struct{}
 
 func (emptySeriesSet) Next() bool                 { return false }
@@ -75,6 +262,32 @@ func EmptySeriesSet() SeriesSet {
 
 // MergeSeriesSets returns a new series set that is the union of the input sets.
 func MergeSeriesSets(all ...SeriesSet) SeriesSet {
+	return mergeSeriesSets(mergeConfig{}, all...)
+}
+
+// mergeConfig configures all nodes of a merge tree.
+type mergeConfig struct {
+	// stats is updated during the merge if not nil.
+	stats *MergeStats
+	// eq decides whether two series are merged if not nil. Otherwise series with equal labels are merged.
+	eq func(a, b []Label) bool
+	// conflictWarns gets a warning for every merge of series with different labels if not nil.
+	conflictWarns *[]string
+	// abortErr is set by the first failing input set if not nil, which stops all nodes right away.
+	abortErr *error
+	// chunksHint is the expected number of chunks of merged series if not 0. If set, nodes append to chunks
+	// allocated by the node merging their first set instead of copying them, as long as the capacity suffices.
+	chunksHint int
+}
+
+func mergeSeriesSets(cfg mergeConfig, all ...SeriesSet) SeriesSet {
+	return mergeTree(cfg, len(all), len(all), all...)
+}
+
+// mergeTree returns a merge tree of the given sets, which are part of total sets merged overall. Chunks of the
+// series of the tree are appended to by all nodes above it up to the one merging spine sets, as the tree is their
+// first set.
+func mergeTree(cfg mergeConfig, spine, total int, all ...SeriesSet) SeriesSet {
 	switch len(all) {
 	case 0:
 		return emptySeriesSet{}
@@ -83,10 +296,423 @@ func MergeSeriesSets(all ...SeriesSet) SeriesSet {
 	}
 	h := len(all) / 2
 
-	return newMergedSeriesSet(
-		MergeSeriesSets(all[:h]...),
-		MergeSeriesSets(all[h:]...),
+	s := newMergedSeriesSet(
+		mergeTree(cfg, spine, total, all[:h]...),
+		mergeTree(cfg, len(all)-h, total, all[h:]...),
 	)
+	s.cfg = cfg
+	// Only allocate the share of the hint needed by the nodes appending to the chunks of this node.
+	s.chunksCap = (cfg.chunksHint*spine + total - 1) / total
+	return s
+}
+
+// MergeSeriesSetsWithCapacityHint works like MergeSeriesSets, but sizes the chunks of merged series for chunksHint
+// chunks, e.g. the expected number of chunks per series across all sets. Merge nodes then append to the chunks
+// allocated by the nodes below as long as the capacity suffices, instead of allocating anew on every level of the
+// merge tree. The hint is advisory, series with more chunks are merged as usual. 0 disables the hint.
+func MergeSeriesSetsWithCapacityHint(chunksHint int, all ...SeriesSet) SeriesSet {
+	return mergeSeriesSets(mergeConfig{chunksHint: chunksHint}, all...)
+}
+
+// NewCustomMergeSeriesSet works like MergeSeriesSets, but merges series for which eq returns true instead of
+// series with equal labels, e.g. to merge series that are equal ignoring replica labels. Merged series get the
+// labels of the series from the set provided first.
+//
+// Every set has to be sorted by labels, and series considered equal by eq have to be adjacent in that order
+// across all sets. For equality ignoring some labels this is the case if these labels sort last.
+func NewCustomMergeSeriesSet(eq func(a, b []Label) bool, all ...SeriesSet) SeriesSet {
+	return mergeSeriesSets(mergeConfig{eq: eq}, all...)
+}
+
+// NewPrimaryPreferredMergeSeriesSet works like MergeSeriesSets, but trusts primary over all secondaries, e.g. to
+// prefer one store during a migration. For series present in primary and any secondary, chunks of secondaries
+// overlapping in time with any chunk of the primary series are dropped. Series and chunks not overlapping are
+// returned from all sets. Chunks of a merged series are the primary chunks followed by the remaining secondary ones.
+func NewPrimaryPreferredMergeSeriesSet(primary SeriesSet, secondaries ...SeriesSet) SeriesSet {
+	return &primaryPreferredSeriesSet{primary: primary, secondary: MergeSeriesSets(secondaries...), drop: overlapsAny}
+}
+
+// primaryPreferredSeriesSet merges primary and secondary, dropping the chunks of secondary series for which drop
+// returns true given the chunks of the primary series with equal labels.
+type primaryPreferredSeriesSet struct {
+	primary, secondary SeriesSet
+	drop               func(c AggrChunk, primary []AggrChunk) bool
+
+	lset         []Label
+	chunks       []AggrChunk
+	init         bool
+	pdone, sdone bool
+}
+
+func (s *primaryPreferredSeriesSet) Next() bool {
+	if !s.init {
+		s.init = true
+		s.pdone = !s.primary.Next()
+		s.sdone = !s.secondary.Next()
+	}
+	if s.pdone && s.sdone || s.Err() != nil {
+		return false
+	}
+
+	var d int
+	switch {
+	case s.pdone:
+		d = 1
+	case s.sdone:
+		d = -1
+	default:
+		lsetP, _ := s.primary.At()
+		lsetS, _ := s.secondary.At()
+		d = CompareLabels(lsetP, lsetS)
+	}
+
+	switch {
+	case d < 0:
+		s.lset, s.chunks = s.primary.At()
+		s.pdone = !s.primary.Next()
+	case d > 0:
+		s.lset, s.chunks = s.secondary.At()
+		s.sdone = !s.secondary.Next()
+	default:
+		lset, chksP := s.primary.At()
+		_, chksS := s.secondary.At()
+
+		s.lset = lset
+		s.chunks = make([]AggrChunk, 0, len(chksP)+len(chksS))
+		s.chunks = append(s.chunks, chksP...)
+		for _, c := range chksS {
+			if !s.drop(c, chksP) {
+				s.chunks = append(s.chunks, c)
+			}
+		}
+		s.pdone = !s.primary.Next()
+		s.sdone = !s.secondary.Next()
+	}
+	return true
+}
+
+func (s *primaryPreferredSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
+
+func (s *primaryPreferredSeriesSet) Err() error {
+	if s.primary.Err() != nil {
+		return s.primary.Err()
+	}
+	return s.secondary.Err()
+}
+
+// NewPrioritizedMergeSeriesSet works like MergeSeriesSets, but resolves ties between chunks of a series from
+// different sets by priority, e.g. to prefer more reliable stores for data which differs subtly between them. Of
+// chunks from different sets with equal MinTime and MaxTime only the one from the set with the highest priority is
+// kept, the one from the set provided first on equal priorities. Chunks of a single set are never dropped.
+// priorities[i] is the priority of sets[i], missing priorities are 0. Chunks of a merged series are ordered by the
+// priority of their sets.
+func NewPrioritizedMergeSeriesSet(sets []SeriesSet, priorities []int) SeriesSet {
+	type prioritized struct {
+		set      SeriesSet
+		priority int
+	}
+	all := make([]prioritized, 0, len(sets))
+	for i, s := range sets {
+		p := prioritized{set: s}
+		if i < len(priorities) {
+			p.priority = priorities[i]
+		}
+		all = append(all, p)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].priority > all[j].priority
+	})
+
+	if len(all) == 0 {
+		return emptySeriesSet{}
+	}
+	// Each set is preferred over all sets of lower priority, which are merged into it one by one.
+	ret := all[0].set
+	for _, p := range all[1:] {
+		ret = &primaryPreferredSeriesSet{primary: ret, secondary: p.set, drop: sameTimeRangeAsAny}
+	}
+	return ret
+}
+
+// sameTimeRangeAsAny returns true if any of chks has the same time range as c.
+func sameTimeRangeAsAny(c AggrChunk, chks []AggrChunk) bool {
+	for _, o := range chks {
+		if c.MinTime == o.MinTime && c.MaxTime == o.MaxTime {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapsAny returns true if the time range of c overlaps with the time range of any of chks. Ranges are inclusive.
+func overlapsAny(c AggrChunk, chks []AggrChunk) bool {
+	for _, o := range chks {
+		if c.MinTime <= o.MaxTime && o.MinTime <= c.MaxTime {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeStats holds statistics of a merge returned by MergeSeriesSetsWithStats.
+type MergeStats struct {
+	// SeriesEmitted is the number of series returned by the merged set.
+	SeriesEmitted int
+	// DuplicatesRemoved is the number of times series with equal labels from different sets were merged into one.
+	DuplicatesRemoved int
+	// ChunksMerged is the number of chunks of emitted series that were merged from more than one set.
+	ChunksMerged int
+	// Comparisons is the number of label set comparisons performed.
+	Comparisons int
+}
+
+// MergeSeriesSetsWithStats works like MergeSeriesSets, but additionally returns statistics of the merge.
+// The statistics are updated with every Next() call, so they are only final after the set is fully drained.
+// They must not be read concurrently with iteration.
+func MergeSeriesSetsWithStats(all ...SeriesSet) (SeriesSet, *MergeStats) {
+	stats := &MergeStats{}
+	return &statsSeriesSet{SeriesSet: mergeSeriesSets(mergeConfig{stats: stats}, all...), stats: stats}, stats
+}
+
+type statsSeriesSet struct {
+	SeriesSet
+
+	stats *MergeStats
+}
+
+func (s *statsSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.stats.SeriesEmitted++
+	if m, ok := s.SeriesSet.(*mergedSeriesSet); ok && m.merged {
+		_, chks := m.At()
+		s.stats.ChunksMerged += len(chks)
+	}
+	return true
+}
+
+// MergeOptions configures the behaviour of MergeSeriesSetsWithOptions.
+type MergeOptions struct {
+	// SkipInvalidChunks makes the merge drop chunks that fail AggrChunk.Validate and record a warning instead of
+	// passing them on to callers, which would fail the whole query on decode.
+	SkipInvalidChunks bool
+	// StrictDedup makes the merge drop all but the first of bit-identical chunks of a series, regardless of their
+	// position, e.g. for out of order input the merge would otherwise keep duplicates of. Chunks of a series are
+	// hashed to find duplicates, at the cost of memory proportional to the number of chunks of a series.
+	StrictDedup bool
+	// Equal decides whether two series are merged if not nil, see NewCustomMergeSeriesSet.
+	Equal func(a, b []Label) bool
+	// DetectLabelConflicts makes the merge record a warning with codes.DataLoss whenever it merges series with
+	// different labels, e.g. to catch stores disagreeing on label values of series considered equal by Equal.
+	// Merged series keep the labels of the series from the set provided first.
+	DetectLabelConflicts bool
+}
+
+// WarningsSeriesSet is a SeriesSet that collects non-fatal issues found during iteration.
+type WarningsSeriesSet interface {
+	SeriesSet
+	// Warnings returns the warnings collected so far. The result is only complete after the set is fully drained.
+	Warnings() []string
+}
+
+// MergeSeriesSetsWithOptions returns a new series set that is the union of the input sets, merged according to opts.
+func MergeSeriesSetsWithOptions(opts MergeOptions, all ...SeriesSet) WarningsSeriesSet {
+	s := &warningsSeriesSet{}
+	cfg := mergeConfig{eq: opts.Equal}
+	if opts.DetectLabelConflicts {
+		cfg.conflictWarns = &s.warns
+	}
+	s.SeriesSet = mergeSeriesSets(cfg, all...)
+	if opts.StrictDedup {
+		s.SeriesSet = &strictDedupSeriesSet{SeriesSet: s.SeriesSet, h: xxhash.New()}
+	}
+	if opts.SkipInvalidChunks {
+		s.SeriesSet = &invalidChunksSkippingSeriesSet{SeriesSet: s.SeriesSet, warns: &s.warns}
+	}
+	return s
+}
+
+type warningsSeriesSet struct {
+	SeriesSet
+
+	warns []string
+}
+
+func (s *warningsSeriesSet) Warnings() []string {
+	return s.warns
+}
+
+// MergeSeriesSetsWithStrategy returns a new series set that is the union of the input sets, handling errors of the
+// input sets according to the partial response strategy. With ABORT, the whole merge stops as soon as any input
+// set fails and returns its error. With WARN, a failing set is treated as exhausted and its error is recorded as a
+// warning, while the remaining sets are merged as usual.
+func MergeSeriesSetsWithStrategy(strategy PartialResponseStrategy, all ...SeriesSet) WarningsSeriesSet {
+	s := &warningsSeriesSet{}
+	s.SeriesSet = mergeSeriesSetsWithStrategy(strategy, &s.warns, all...)
+	return s
+}
+
+// mergeSeriesSetsWithStrategy works like MergeSeriesSetsWithStrategy, but adds the warnings to warns.
+func mergeSeriesSetsWithStrategy(strategy PartialResponseStrategy, warns *[]string, all ...SeriesSet) SeriesSet {
+	cfg := mergeConfig{abortErr: new(error)}
+	sets := make([]SeriesSet, 0, len(all))
+	for _, set := range all {
+		sets = append(sets, &partialSeriesSet{
+			SeriesSet: set,
+			strategy:  strategy,
+			warns:     warns,
+			abortErr:  cfg.abortErr,
+		})
+	}
+	return mergeSeriesSets(cfg, sets...)
+}
+
+// partialSeriesSet handles the error of an input set of the merge according to the partial response strategy.
+type partialSeriesSet struct {
+	SeriesSet
+
+	strategy PartialResponseStrategy
+	warns    *[]string
+	abortErr *error
+	done     bool
+}
+
+func (s *partialSeriesSet) Next() bool {
+	if s.done || *s.abortErr != nil {
+		return false
+	}
+	if s.SeriesSet.Next() {
+		return true
+	}
+	s.done = true
+
+	if err := s.SeriesSet.Err(); err != nil {
+		if s.strategy == PartialResponseStrategy_WARN {
+			*s.warns = append(*s.warns, err.Error())
+		} else {
+			*s.abortErr = err
+		}
+	}
+	return false
+}
+
+func (s *partialSeriesSet) Err() error {
+	if s.strategy == PartialResponseStrategy_WARN {
+		return nil
+	}
+	return s.SeriesSet.Err()
+}
+
+// strictDedupSeriesSet drops chunks which are bit-identical to an earlier chunk of the same series.
+type strictDedupSeriesSet struct {
+	SeriesSet
+
+	h      hash.Hash64
+	buf    []byte
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *strictDedupSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.lset, s.chunks = s.SeriesSet.At()
+	if len(s.chunks) < 2 {
+		return true
+	}
+
+	var (
+		seen = make(map[uint64][]int, len(s.chunks))
+		ret  = make([]AggrChunk, 0, len(s.chunks))
+	)
+Chunks:
+	for _, c := range s.chunks {
+		s.h.Reset()
+		s.buf = hashAggrChunk(s.h, s.buf, c)
+		k := s.h.Sum64()
+
+		// Compare the chunks on hash collisions, so that different chunks are never dropped.
+		for _, i := range seen[k] {
+			if aggrChunksEqual(ret[i], c) {
+				continue Chunks
+			}
+		}
+		seen[k] = append(seen[k], len(ret))
+		ret = append(ret, c)
+	}
+	s.chunks = ret
+	return true
+}
+
+func (s *strictDedupSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
+
+// aggrChunksEqual returns true if both chunks have the same time range and the same chunks with equal data.
+func aggrChunksEqual(a, b AggrChunk) bool {
+	if a.MinTime != b.MinTime || a.MaxTime != b.MaxTime {
+		return false
+	}
+	for _, aggr := range allAggrs {
+		ca, oka := a.Get(aggr)
+		cb, okb := b.Get(aggr)
+		if oka != okb {
+			return false
+		}
+		if oka && (ca.Type != cb.Type || !bytes.Equal(ca.Data, cb.Data)) {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidChunksSkippingSeriesSet drops chunks failing validation and records a warning for each affected series.
+// Series left without any chunk are skipped.
+type invalidChunksSkippingSeriesSet struct {
+	SeriesSet
+
+	warns  *[]string
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *invalidChunksSkippingSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		lset, chks := s.SeriesSet.At()
+
+		var (
+			valid    = make([]AggrChunk, 0, len(chks))
+			dropped  int
+			firstErr error
+		)
+		for _, c := range chks {
+			if err := c.Validate(); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				dropped++
+				continue
+			}
+			valid = append(valid, c)
+		}
+		if dropped > 0 {
+			*s.warns = append(*s.warns, errors.Wrapf(firstErr, "series %s: dropped %d invalid chunks", LabelsToString(lset), dropped).Error())
+		}
+		if len(valid) == 0 && len(chks) > 0 {
+			continue
+		}
+		s.lset, s.chunks = lset, valid
+		return true
+	}
+	return false
+}
+
+func (s *invalidChunksSkippingSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
 }
 
 // SeriesSet is a set of series and their corresponding chunks.
@@ -103,7 +729,14 @@ type mergedSeriesSet struct {
 
 	lset         []Label
 	chunks       []AggrChunk
+	init         bool
 	adone, bdone bool
+	// merged is true if the current series was merged from series of more than one set.
+	merged bool
+
+	cfg mergeConfig
+	// chunksCap is the minimum capacity of chunks allocated for merged series.
+	chunksCap int
 }
 
 // newMergedSeriesSet takes two series sets as a single series set.
@@ -111,14 +744,14 @@ type mergedSeriesSet struct {
 // If the ranges overlap b samples are appended to a samples.
 // If the single SeriesSet returns same series within many iterations,
 // merge series set will not try to merge those.
+// Series without labels are handled like any other series: they come first, and
+// such series from both sets are merged into one, regardless of nil or empty labels.
+// Unless a custom equality is configured, series are only merged if all of their label
+// names and values are equal, so the labels of a merged series are the same no matter
+// which set they are taken from. Series differing in any label value are returned separately.
+// No work is done until the first Next() call, so building a large merge tree is cheap.
 func newMergedSeriesSet(a, b SeriesSet) *mergedSeriesSet {
-	s := &mergedSeriesSet{a: a, b: b}
-	// Initialize first elements of both sets as Next() needs
-	// one element look-ahead.
-	s.adone = !s.a.Next()
-	s.bdone = !s.b.Next()
-
-	return s
+	return &mergedSeriesSet{a: a, b: b}
 }
 
 func (s *mergedSeriesSet) At() ([]Label, []AggrChunk) {
@@ -141,10 +774,26 @@ func (s *mergedSeriesSet) compare() int {
 	}
 	lsetA, _ := s.a.At()
 	lsetB, _ := s.b.At()
+	if s.cfg.stats != nil {
+		s.cfg.stats.Comparisons++
+	}
+	if s.cfg.eq != nil && s.cfg.eq(lsetA, lsetB) {
+		return 0
+	}
 	return CompareLabels(lsetA, lsetB)
 }
 
 func (s *mergedSeriesSet) Next() bool {
+	if s.cfg.abortErr != nil && *s.cfg.abortErr != nil {
+		return false
+	}
+	if !s.init {
+		// Initialize first elements of both sets as Next() needs
+		// one element look-ahead.
+		s.init = true
+		s.adone = !s.a.Next()
+		s.bdone = !s.b.Next()
+	}
 	if s.adone && s.bdone || s.Err() != nil {
 		return false
 	}
@@ -154,22 +803,45 @@ func (s *mergedSeriesSet) Next() bool {
 	// Both sets contain the current series. Chain them into a single one.
 	if d > 0 {
 		s.lset, s.chunks = s.b.At()
+		s.merged = isMerged(s.b)
 		s.bdone = !s.b.Next()
 	} else if d < 0 {
 		s.lset, s.chunks = s.a.At()
+		s.merged = isMerged(s.a)
 		s.adone = !s.a.Next()
 	} else {
 		// Concatenate chunks from both series sets. They may be expected of order
 		// w.r.t to their time range. This must be accounted for later.
 		lset, chksA := s.a.At()
-		_, chksB := s.b.At()
+		lsetB, chksB := s.b.At()
+		if s.cfg.conflictWarns != nil && CompareLabels(lset, lsetB) != 0 {
+			*s.cfg.conflictWarns = append(*s.cfg.conflictWarns, warningWithCode(
+				errors.Errorf("series %s merged with series %s with different labels", LabelsToString(lset), LabelsToString(lsetB)),
+				codes.DataLoss,
+			))
+		}
 
 		s.lset = lset
-		// Slice reuse is not generally safe with nested merge iterators.
-		// We err on the safe side an create a new slice.
-		s.chunks = make([]AggrChunk, 0, len(chksA)+len(chksB))
-		s.chunks = append(s.chunks, chksA...)
-		s.chunks = append(s.chunks, chksB...)
+		n := len(chksA) + len(chksB)
+		if s.cfg.chunksHint > 0 && isMerged(s.a) && cap(chksA) >= n {
+			// Chunks of merged series are allocated by the node below and not referenced by it anymore once
+			// it moves on, so they can be appended to.
+			s.chunks = append(chksA, chksB...)
+		} else {
+			// Slice reuse is not generally safe with nested merge iterators.
+			// We err on the safe side an create a new slice.
+			if s.chunksCap > n {
+				n = s.chunksCap
+			}
+			s.chunks = make([]AggrChunk, 0, n)
+			s.chunks = append(s.chunks, chksA...)
+			s.chunks = append(s.chunks, chksB...)
+		}
+
+		s.merged = true
+		if s.cfg.stats != nil {
+			s.cfg.stats.DuplicatesRemoved++
+		}
 
 		s.adone = !s.a.Next()
 		s.bdone = !s.b.Next()
@@ -177,8 +849,18 @@ func (s *mergedSeriesSet) Next() bool {
 	return true
 }
 
+// isMerged returns true if the current series of s is the result of merging series from more than one set.
+func isMerged(s SeriesSet) bool {
+	m, ok := s.(*mergedSeriesSet)
+	return ok && m.merged
+}
+
 // LabelsToPromLabels converts Thanos proto labels to Prometheus labels in type safe manner.
+// Nil input results in nil output, so that nil and empty label sets stay distinguishable.
 func LabelsToPromLabels(lset []Label) labels.Labels {
+	if lset == nil {
+		return nil
+	}
 	ret := make(labels.Labels, len(lset))
 	for i, l := range lset {
 		ret[i] = labels.Label{Name: l.Name, Value: l.Value}
@@ -195,7 +877,11 @@ func LabelsToPromLabelsUnsafe(lset []Label) labels.Labels {
 }
 
 // PromLabelsToLabels converts Prometheus labels to Thanos proto labels in type safe manner.
+// Nil input results in nil output, so that nil and empty label sets stay distinguishable.
 func PromLabelsToLabels(lset labels.Labels) []Label {
+	if lset == nil {
+		return nil
+	}
 	ret := make([]Label, len(lset))
 	for i, l := range lset {
 		ret[i] = Label{Name: l.Name, Value: l.Value}
@@ -211,8 +897,20 @@ func PromLabelsToLabelsUnsafe(lset labels.Labels) []Label {
 	return *(*[]Label)(unsafe.Pointer(&lset))
 }
 
+// PromLabelsToLabelsSorted converts Prometheus labels to Thanos proto labels in type safe manner and sorts them by name.
+// Unlike PromLabelsToLabels it does not trust the input to be sorted, e.g. labels received through remote write.
+func PromLabelsToLabelsSorted(lset labels.Labels) []Label {
+	ret := PromLabelsToLabels(lset)
+	SortLabels(ret)
+	return ret
+}
+
 // PrompbLabelsToLabels converts Prometheus labels to Thanos proto labels in type safe manner.
+// Nil input results in nil output, so that nil and empty label sets stay distinguishable.
 func PrompbLabelsToLabels(lset []prompb.Label) []Label {
+	if lset == nil {
+		return nil
+	}
 	ret := make([]Label, len(lset))
 	for i, l := range lset {
 		ret[i] = Label{Name: l.Name, Value: l.Value}
@@ -228,6 +926,47 @@ func PrompbLabelsToLabelsUnsafe(lset []prompb.Label) []Label {
 	return *(*[]Label)(unsafe.Pointer(&lset))
 }
 
+// LabelsFromBytes converts name and value byte pairs, e.g. from a decoded remote write request, to Thanos proto
+// labels without copying the underlying data.
+//
+// NOTE: The returned labels point directly to the memory of the passed byte slices. The caller owns the buffers
+// and must guarantee they are neither modified nor reused for as long as the returned labels (or any string
+// taken from them) are in use, otherwise label names and values will silently change under the hood.
+func LabelsFromBytes(pairs [][2][]byte) []Label {
+	if pairs == nil {
+		return nil
+	}
+	ret := make([]Label, len(pairs))
+	for i, p := range pairs {
+		ret[i] = Label{Name: yoloString(p[0]), Value: yoloString(p[1])}
+	}
+	return ret
+}
+
+func yoloString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// LabelSetFromMap returns a label set with the labels of the given map, sorted by name, e.g. to build external
+// labels from configuration.
+func LabelSetFromMap(m map[string]string) LabelSet {
+	ls := LabelSet{Labels: make([]Label, 0, len(m))}
+	for n, v := range m {
+		ls.Labels = append(ls.Labels, Label{Name: n, Value: v})
+	}
+	SortLabels(ls.Labels)
+	return ls
+}
+
+// LabelSetToMap returns a map of the label names to values of the given label set.
+func LabelSetToMap(ls LabelSet) map[string]string {
+	m := make(map[string]string, len(ls.Labels))
+	for _, l := range ls.Labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
 func LabelsToString(lset []Label) string {
 	var s []string
 	for _, l := range lset {
@@ -5,15 +5,21 @@ package storepb
 
 import (
 	"fmt"
+	"math/rand"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/gogo/protobuf/types"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type sample struct {
@@ -330,6 +336,81 @@ func BenchmarkMergedSeriesSet(b *testing.B) {
 	}
 }
 
+func TestMergeSeriesSetsWithCapacityHint(t *testing.T) {
+	var in [][]rawSeries
+	for i := 0; i < 9; i++ {
+		in = append(in, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{int64(i), float64(i)}}},
+			},
+			{
+				lset:   labels.FromStrings("a", fmt.Sprintf("b%d", i%3)),
+				chunks: [][]sample{{{int64(i), float64(i)}}, {{int64(i + 10), float64(i)}}},
+			},
+		})
+	}
+	sets := func() []SeriesSet {
+		var ret []SeriesSet
+		for _, s := range in {
+			ret = append(ret, newListSeriesSet(t, s))
+		}
+		return ret
+	}
+
+	var expected []Series
+	s := MergeSeriesSets(sets()...)
+	for s.Next() {
+		lset, chks := s.At()
+		expected = append(expected, Series{Labels: lset, Chunks: chks})
+	}
+	testutil.Ok(t, s.Err())
+
+	for _, hint := range []int{1, 4, 9, 100} {
+		t.Run(fmt.Sprintf("hint %d", hint), func(t *testing.T) {
+			var got []Series
+			s := MergeSeriesSetsWithCapacityHint(hint, sets()...)
+			for s.Next() {
+				lset, chks := s.At()
+				got = append(got, Series{Labels: lset, Chunks: chks})
+			}
+			testutil.Ok(t, s.Err())
+			// Chunks of series returned earlier are not modified by later ones.
+			testutil.Equals(t, expected, got)
+		})
+	}
+}
+
+// Test the allocations of deep merges with and without a chunks capacity hint.
+func BenchmarkMergeSeriesSetsWithCapacityHint(b *testing.B) {
+	lbls, err := labels.ReadLabels(filepath.Join("../../testutil/testdata", "20kseries.json"), 1000)
+	testutil.Ok(b, err)
+	sort.Sort(labels.Slice(lbls))
+
+	var series []Series
+	for _, l := range lbls {
+		series = append(series, newSeries(b, l, [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}}))
+	}
+
+	for _, sets := range []int{8, 32, 128} {
+		for _, hint := range []int{0, 2 * sets} {
+			b.Run(fmt.Sprintf("sets=%d,hint=%d", sets, hint), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					all := make([]SeriesSet, 0, sets)
+					for j := 0; j < sets; j++ {
+						all = append(all, &listSeriesSet{series: series, idx: -1})
+					}
+					ms := MergeSeriesSetsWithCapacityHint(hint, all...)
+					for ms.Next() {
+					}
+					testutil.Ok(b, ms.Err())
+				}
+			})
+		}
+	}
+}
+
 var testLsetMap = map[string]string{
 	"a":                           "1",
 	"c":                           "2",
@@ -384,3 +465,947 @@ func BenchmarkUnsafeVSSafeLabelsConversion(b *testing.B) {
 	testutil.Equals(b, num, len(converted))
 
 }
+
+func TestMergeSeriesSetsWithOptions_SkipInvalidChunks(t *testing.T) {
+	corrupted := AggrChunk{MinTime: 5, MaxTime: 6, Raw: &Chunk{Type: Chunk_XOR, Data: []byte{0, 5, 0xff}}}
+
+	a := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+	})
+	a.series[0].Chunks = append(a.series[0].Chunks, corrupted)
+	// Series with only invalid chunks are skipped entirely.
+	a.series[1].Chunks = []AggrChunk{corrupted}
+
+	b := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "c"),
+			chunks: [][]sample{{{7, 1}, {8, 2}}},
+		},
+	})
+	b.series[0].Chunks = append([]AggrChunk{corrupted}, b.series[0].Chunks...)
+
+	ss := MergeSeriesSetsWithOptions(MergeOptions{SkipInvalidChunks: true}, a, b)
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "c"),
+			chunks: [][]sample{{{7, 1}, {8, 2}}},
+		},
+	}, ss)
+	testutil.Ok(t, ss.Err())
+
+	warns := ss.Warnings()
+	testutil.Equals(t, 3, len(warns))
+	for _, w := range warns {
+		testutil.Assert(t, strings.Contains(w, "dropped 1 invalid chunks"), "unexpected warning %q", w)
+	}
+}
+
+func TestMergeSeriesSetsWithOptions_Default(t *testing.T) {
+	a := newListSeriesSet(t, []rawSeries{{
+		lset:   labels.FromStrings("a", "a"),
+		chunks: [][]sample{{{1, 1}, {2, 2}}},
+	}})
+	a.series[0].Chunks = append(a.series[0].Chunks, AggrChunk{MinTime: 5, MaxTime: 6, Raw: &Chunk{Type: Chunk_XOR, Data: []byte{0, 5, 0xff}}})
+
+	// Without SkipInvalidChunks chunks are passed through untouched.
+	ss := MergeSeriesSetsWithOptions(MergeOptions{}, a)
+	testutil.Assert(t, ss.Next(), "expected series")
+	_, chks := ss.At()
+	testutil.Equals(t, 2, len(chks))
+	testutil.Assert(t, !ss.Next(), "expected end of stream")
+	testutil.Equals(t, 0, len(ss.Warnings()))
+}
+
+func TestMergeSeriesSetsWithOptions_DetectLabelConflicts(t *testing.T) {
+	ignoreReplica := func(a, b []Label) bool { return DifferOnlyInReplica(a, b, []string{"replica"}) }
+	input := func() []SeriesSet {
+		return []SeriesSet{
+			newListSeriesSet(t, []rawSeries{{
+				lset:   labels.FromStrings("a", "a", "replica", "1"),
+				chunks: [][]sample{{{1, 1}}},
+			}}),
+			newListSeriesSet(t, []rawSeries{{
+				lset:   labels.FromStrings("a", "a", "replica", "2"),
+				chunks: [][]sample{{{2, 2}}},
+			}}),
+		}
+	}
+	expected := []rawSeries{{
+		lset:   labels.FromStrings("a", "a", "replica", "1"),
+		chunks: [][]sample{{{1, 1}}, {{2, 2}}},
+	}}
+
+	ss := MergeSeriesSetsWithOptions(MergeOptions{Equal: ignoreReplica}, input()...)
+	seriesEquals(t, expected, ss)
+	testutil.Equals(t, 0, len(ss.Warnings()))
+
+	ss = MergeSeriesSetsWithOptions(MergeOptions{Equal: ignoreReplica, DetectLabelConflicts: true}, input()...)
+	seriesEquals(t, expected, ss)
+	testutil.Ok(t, ss.Err())
+	testutil.Equals(t, 1, len(ss.Warnings()))
+	code, msg := WarningCode(ss.Warnings()[0])
+	testutil.Equals(t, codes.DataLoss, code)
+	testutil.Assert(t, strings.Contains(msg, "with different labels"), "unexpected warning %s", msg)
+}
+
+func TestMergeSeriesSetsWithOptions_StrictDedup(t *testing.T) {
+	input := func() []SeriesSet {
+		a := newListSeriesSet(t, []rawSeries{{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}, {{5, 5}, {6, 6}}},
+		}})
+		// Duplicate of the first chunk at a non-adjacent position.
+		a.series[0].Chunks = append(a.series[0].Chunks, a.series[0].Chunks[0])
+
+		b := newListSeriesSet(t, []rawSeries{{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{5, 5}, {6, 6}}, {{3, 3}, {4, 4}}, {{3, 3}, {4, 5}}},
+		}})
+		return []SeriesSet{a, b}
+	}
+
+	ss := MergeSeriesSetsWithOptions(MergeOptions{StrictDedup: true}, input()...)
+	seriesEquals(t, []rawSeries{{
+		lset: labels.FromStrings("a", "a"),
+		// Chunks with the same time range but different data are kept.
+		chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}, {{5, 5}, {6, 6}}, {{3, 3}, {4, 5}}},
+	}}, ss)
+	testutil.Ok(t, ss.Err())
+
+	// Without StrictDedup duplicates which are not adjacent after merging are kept.
+	ss = MergeSeriesSetsWithOptions(MergeOptions{}, input()...)
+	testutil.Assert(t, ss.Next(), "expected series")
+	_, chks := ss.At()
+	testutil.Assert(t, len(chks) > 4, "expected duplicated chunks, got %d", len(chks))
+	testutil.Assert(t, !ss.Next(), "expected end of stream")
+	testutil.Ok(t, ss.Err())
+}
+
+func TestWarningCode(t *testing.T) {
+	resp := NewWarnSeriesResponseWithCode(errors.New("store unavailable"), codes.Unavailable)
+	// The warning reads like the error of a gRPC status.
+	testutil.Equals(t, status.Error(codes.Unavailable, "store unavailable").Error(), resp.GetWarning())
+
+	code, msg := WarningCode(resp.GetWarning())
+	testutil.Equals(t, codes.Unavailable, code)
+	testutil.Equals(t, "store unavailable", msg)
+
+	// Plain warnings are passed through.
+	for _, w := range []string{
+		NewWarnSeriesResponse(errors.New("fetch series: no such host")).GetWarning(),
+		"rpc error: code = Nonsense desc = x",
+		"rpc error: code = Unavailable",
+	} {
+		code, msg = WarningCode(w)
+		testutil.Equals(t, codes.Unknown, code)
+		testutil.Equals(t, w, msg)
+	}
+}
+
+func TestPromLabelsToLabelsSorted(t *testing.T) {
+	sorted := labels.FromMap(testLsetMap)
+
+	scrambled := make(labels.Labels, len(sorted))
+	copy(scrambled, sorted)
+	rand.New(rand.NewSource(1)).Shuffle(len(scrambled), func(i, j int) {
+		scrambled[i], scrambled[j] = scrambled[j], scrambled[i]
+	})
+
+	lset := PromLabelsToLabelsSorted(scrambled)
+	testutil.Equals(t, len(sorted), len(lset))
+	testutil.Assert(t, sort.SliceIsSorted(lset, func(i, j int) bool { return lset[i].Name < lset[j].Name }), "expected sorted labels %v", lset)
+	testutil.Equals(t, PromLabelsToLabels(sorted), lset)
+
+	testutil.Equals(t, []Label{}, PromLabelsToLabelsSorted(labels.Labels{}))
+}
+
+func TestLabelsConversionNilSafety(t *testing.T) {
+	for _, tcase := range []struct {
+		desc   string
+		in     []Label
+		isNil  bool
+		length int
+	}{
+		{desc: "nil", in: nil, isNil: true},
+		{desc: "empty", in: []Label{}},
+		{desc: "non-empty", in: []Label{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, length: 2},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			var (
+				prom labels.Labels
+				pb   []prompb.Label
+			)
+			if tcase.in != nil {
+				prom = labels.Labels{}
+				pb = []prompb.Label{}
+				for _, l := range tcase.in {
+					prom = append(prom, labels.Label{Name: l.Name, Value: l.Value})
+					pb = append(pb, prompb.Label{Name: l.Name, Value: l.Value})
+				}
+			}
+
+			for name, res := range map[string]interface{}{
+				"LabelsToPromLabels":         LabelsToPromLabels(tcase.in),
+				"LabelsToPromLabelsUnsafe":   LabelsToPromLabelsUnsafe(tcase.in),
+				"PromLabelsToLabels":         PromLabelsToLabels(prom),
+				"PromLabelsToLabelsUnsafe":   PromLabelsToLabelsUnsafe(prom),
+				"PromLabelsToLabelsSorted":   PromLabelsToLabelsSorted(prom),
+				"PrompbLabelsToLabels":       PrompbLabelsToLabels(pb),
+				"PrompbLabelsToLabelsUnsafe": PrompbLabelsToLabelsUnsafe(pb),
+			} {
+				v := reflect.ValueOf(res)
+				testutil.Equals(t, tcase.isNil, v.IsNil(), "%s: unexpected nil-ness", name)
+				testutil.Equals(t, tcase.length, v.Len(), "%s: unexpected length", name)
+			}
+		})
+	}
+}
+
+func TestLabelsFromBytes(t *testing.T) {
+	buf := []byte("namevalueother")
+	lset := LabelsFromBytes([][2][]byte{
+		{buf[0:4], buf[4:9]},
+		{buf[9:14], nil},
+	})
+	testutil.Equals(t, []Label{{Name: "name", Value: "value"}, {Name: "other", Value: ""}}, lset)
+
+	// Labels share the memory of the passed buffer.
+	buf[4] = 'V'
+	testutil.Equals(t, "Value", lset[0].Value)
+
+	testutil.Assert(t, LabelsFromBytes(nil) == nil, "expected nil labels for nil input")
+}
+
+func TestMergeSeriesSetsWithStats(t *testing.T) {
+	var input []SeriesSet
+	for _, iss := range [][]rawSeries{
+		{
+			{
+				lset:   labels.FromStrings("a", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "c"),
+				chunks: [][]sample{{{11, 1}, {12, 2}}},
+			},
+		},
+		{
+			{
+				lset:   labels.FromStrings("a", "c"),
+				chunks: [][]sample{{{7, 1}, {8, 2}}, {{9, 3}, {10, 4}}},
+			},
+		},
+		{
+			{
+				lset:   labels.FromStrings("a", "c"),
+				chunks: [][]sample{{{1, 1}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "d"),
+				chunks: [][]sample{{{1, 1}}},
+			},
+		},
+	} {
+		input = append(input, newListSeriesSet(t, iss))
+	}
+
+	ss, stats := MergeSeriesSetsWithStats(input...)
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "c"),
+			chunks: [][]sample{{{11, 1}, {12, 2}}, {{7, 1}, {8, 2}}, {{9, 3}, {10, 4}}, {{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "d"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}, ss)
+	testutil.Ok(t, ss.Err())
+
+	testutil.Equals(t, 3, stats.SeriesEmitted)
+	testutil.Equals(t, 2, stats.DuplicatesRemoved)
+	testutil.Equals(t, 4, stats.ChunksMerged)
+	testutil.Assert(t, stats.Comparisons > 0, "expected comparisons to be counted")
+}
+
+func TestDifferOnlyInReplica(t *testing.T) {
+	replicaLabels := []string{"replica", "rule_replica"}
+	for _, tcase := range []struct {
+		desc     string
+		a, b     labels.Labels
+		expected bool
+	}{
+		{
+			desc:     "equal",
+			a:        labels.FromStrings("a", "1", "b", "2"),
+			b:        labels.FromStrings("a", "1", "b", "2"),
+			expected: true,
+		},
+		{
+			desc:     "different replica values",
+			a:        labels.FromStrings("a", "1", "replica", "0", "z", "2"),
+			b:        labels.FromStrings("a", "1", "replica", "1", "z", "2"),
+			expected: true,
+		},
+		{
+			desc:     "replica label only on one side",
+			a:        labels.FromStrings("a", "1", "replica", "0", "rule_replica", "x"),
+			b:        labels.FromStrings("a", "1"),
+			expected: true,
+		},
+		{
+			desc:     "only replica labels",
+			a:        labels.FromStrings("replica", "0"),
+			b:        labels.FromStrings("rule_replica", "1"),
+			expected: true,
+		},
+		{
+			desc:     "non-replica label value differs",
+			a:        labels.FromStrings("a", "1", "replica", "0"),
+			b:        labels.FromStrings("a", "2", "replica", "1"),
+			expected: false,
+		},
+		{
+			desc:     "non-replica label only on one side",
+			a:        labels.FromStrings("a", "1", "replica", "0", "z", "1"),
+			b:        labels.FromStrings("a", "1", "replica", "1"),
+			expected: false,
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			a, b := PromLabelsToLabels(tcase.a), PromLabelsToLabels(tcase.b)
+			testutil.Equals(t, tcase.expected, DifferOnlyInReplica(a, b, replicaLabels))
+			testutil.Equals(t, tcase.expected, DifferOnlyInReplica(b, a, replicaLabels))
+			testutil.Equals(t, 0.0, testing.AllocsPerRun(10, func() { DifferOnlyInReplica(a, b, replicaLabels) }))
+		})
+	}
+}
+
+func TestLabelsEqualFold(t *testing.T) {
+	fold := map[string]struct{}{"host": {}}
+	for _, tcase := range []struct {
+		desc     string
+		a, b     labels.Labels
+		expected bool
+	}{
+		{
+			desc:     "equal",
+			a:        labels.FromStrings("host", "host1", "job", "a"),
+			b:        labels.FromStrings("host", "host1", "job", "a"),
+			expected: true,
+		},
+		{
+			desc:     "folded label differs in case",
+			a:        labels.FromStrings("host", "Host1", "job", "a"),
+			b:        labels.FromStrings("host", "host1", "job", "a"),
+			expected: true,
+		},
+		{
+			desc:     "folded label differs",
+			a:        labels.FromStrings("host", "host1", "job", "a"),
+			b:        labels.FromStrings("host", "host2", "job", "a"),
+			expected: false,
+		},
+		{
+			desc:     "other label differs in case",
+			a:        labels.FromStrings("host", "Host1", "job", "A"),
+			b:        labels.FromStrings("host", "host1", "job", "a"),
+			expected: false,
+		},
+		{
+			desc:     "names differ in case",
+			a:        labels.FromStrings("Host", "host1"),
+			b:        labels.FromStrings("host", "host1"),
+			expected: false,
+		},
+		{
+			desc:     "different length",
+			a:        labels.FromStrings("host", "host1", "job", "a"),
+			b:        labels.FromStrings("host", "host1"),
+			expected: false,
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			a, b := PromLabelsToLabels(tcase.a), PromLabelsToLabels(tcase.b)
+			testutil.Equals(t, tcase.expected, LabelsEqualFold(a, b, fold))
+			testutil.Equals(t, tcase.expected, LabelsEqualFold(b, a, fold))
+			testutil.Equals(t, 0.0, testing.AllocsPerRun(10, func() { LabelsEqualFold(a, b, fold) }))
+		})
+	}
+}
+
+// compareLabelsReference is the straightforward implementation of CompareLabels.
+func compareLabelsReference(a, b []Label) int {
+	l := len(a)
+	if len(b) < l {
+		l = len(b)
+	}
+	for i := 0; i < l; i++ {
+		if d := strings.Compare(a[i].Name, b[i].Name); d != 0 {
+			return d
+		}
+		if d := strings.Compare(a[i].Value, b[i].Value); d != 0 {
+			return d
+		}
+	}
+	return len(a) - len(b)
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestCompareLabels(t *testing.T) {
+	for _, tcase := range []struct {
+		a, b     []Label
+		expected int
+	}{
+		{a: nil, b: nil, expected: 0},
+		{a: nil, b: []Label{{Name: "a", Value: "1"}}, expected: -1},
+		{a: []Label{{Name: "a", Value: "1"}}, b: []Label{{Name: "a", Value: "1"}}, expected: 0},
+		{a: []Label{{Name: "a", Value: "1"}}, b: []Label{{Name: "a", Value: "2"}}, expected: -1},
+		{a: []Label{{Name: "b", Value: "1"}}, b: []Label{{Name: "a", Value: "2"}}, expected: 1},
+		{a: []Label{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}}, b: []Label{{Name: "a", Value: "1"}}, expected: 1},
+		{a: []Label{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}}, b: []Label{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, expected: -1},
+		{a: []Label{{Name: "a", Value: ""}}, b: []Label{{Name: "a", Value: "1"}}, expected: -1},
+	} {
+		t.Run("", func(t *testing.T) {
+			testutil.Equals(t, tcase.expected, sign(CompareLabels(tcase.a, tcase.b)))
+			testutil.Equals(t, -tcase.expected, sign(CompareLabels(tcase.b, tcase.a)))
+		})
+	}
+
+	// Behaviour has to be identical to the reference implementation, including the fewer labels tiebreak.
+	values := []string{"", "a", "aa", "ab", "b"}
+	r := rand.New(rand.NewSource(1))
+	randLabels := func() []Label {
+		lset := make([]Label, r.Intn(4))
+		for i := range lset {
+			lset[i] = Label{Name: values[r.Intn(len(values))], Value: values[r.Intn(len(values))]}
+		}
+		return lset
+	}
+	for i := 0; i < 10000; i++ {
+		a, b := randLabels(), randLabels()
+		testutil.Equals(t, sign(compareLabelsReference(a, b)), sign(CompareLabels(a, b)), "%v %v", a, b)
+	}
+}
+
+func BenchmarkCompareLabels(b *testing.B) {
+	base := []Label{
+		{Name: "__name__", Value: "http_requests_total"},
+		{Name: "cluster", Value: "eu-west-1"},
+		{Name: "handler", Value: "/api/v1/query_range"},
+		{Name: "instance", Value: "10.0.12.34:9090"},
+		{Name: "job", Value: "prometheus"},
+		{Name: "method", Value: "GET"},
+	}
+	otherName := append([]Label{{Name: "__name__", Value: "http_request_duration_seconds"}}, base[1:]...)
+	otherLast := append(append([]Label{}, base[:5]...), Label{Name: "method", Value: "POST"})
+	equal := append([]Label{}, base...)
+
+	for _, bcase := range []struct {
+		name string
+		b    []Label
+	}{
+		{name: "different name", b: otherName},
+		{name: "different last label", b: otherLast},
+		{name: "equal", b: equal},
+	} {
+		b.Run(bcase.name, func(b *testing.B) {
+			b.Run("reference", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					compareLabelsReference(base, bcase.b)
+				}
+			})
+			b.Run("CompareLabels", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					CompareLabels(base, bcase.b)
+				}
+			})
+		})
+	}
+}
+
+func TestMergeSeriesSets_EmptyLabels(t *testing.T) {
+	a := newListSeriesSet(t, []rawSeries{
+		{
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	})
+	b := newListSeriesSet(t, []rawSeries{
+		{
+			chunks: [][]sample{{{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "0"),
+			chunks: [][]sample{{{1, 0}}},
+		},
+	})
+	// Sending an empty label set over the wire makes it nil on the receiving end, so both have to be merged.
+	b.series[0].Labels = []Label{}
+	c := newListSeriesSet(t, []rawSeries{
+		{
+			chunks: [][]sample{{{4, 4}}},
+		},
+	})
+
+	seriesEquals(t, []rawSeries{
+		{
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "0"),
+			chunks: [][]sample{{{1, 0}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}, MergeSeriesSets(a, b, c))
+}
+
+func TestMergeSeriesSets_DifferingLabelValue(t *testing.T) {
+	// The same series as seen by two stores, one of them carrying a bad label value. Both have to be returned,
+	// so no label value is silently dropped.
+	a := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "up", "instance", "a", "job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	})
+	b := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "up", "instance", "a", "job", "api-bad"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	})
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "up", "instance", "a", "job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "up", "instance", "a", "job", "api-bad"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}, MergeSeriesSets(b, a))
+}
+
+func TestNewCustomMergeSeriesSet(t *testing.T) {
+	ignoreReplica := func(a, b []Label) bool { return DifferOnlyInReplica(a, b, []string{"replica"}) }
+
+	s := NewCustomMergeSeriesSet(ignoreReplica,
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1", "replica", "x"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "3", "replica", "x"),
+				chunks: [][]sample{{{1, 3}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1", "replica", "y"),
+				chunks: [][]sample{{{3, 3}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2", "replica", "y"),
+				chunks: [][]sample{{{1, 2}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1", "replica", "z"),
+				chunks: [][]sample{{{4, 4}}},
+			},
+		}),
+	)
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "replica", "x"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "replica", "y"),
+			chunks: [][]sample{{{1, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3", "replica", "x"),
+			chunks: [][]sample{{{1, 3}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestNewPrimaryPreferredMergeSeriesSet(t *testing.T) {
+	s := NewPrimaryPreferredMergeSeriesSet(
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{10, 1}, {20, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "3"),
+				chunks: [][]sample{{{1, 3}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				// The first chunk overlaps with the primary chunk, the second does not.
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{15, 100}, {25, 200}}, {{30, 3}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2"),
+				chunks: [][]sample{{{1, 2}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 0}, {10, 100}}},
+			},
+		}),
+	)
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{10, 1}, {20, 2}}, {{30, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3"),
+			chunks: [][]sample{{{1, 3}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestNewPrioritizedMergeSeriesSet(t *testing.T) {
+	s := NewPrioritizedMergeSeriesSet([]SeriesSet{
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 1}, {2, 1}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2"),
+				chunks: [][]sample{{{1, 1}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 10}, {2, 10}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				// Ties with the chunk of the set before with equal priority, the second chunk is unique.
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 100}, {2, 100}}, {{3, 100}}},
+			},
+		}),
+	}, []int{1, 5, 5})
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 10}, {2, 10}}, {{3, 100}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestNewPrioritizedMergeSeriesSet_SameSetTies(t *testing.T) {
+	s := NewPrioritizedMergeSeriesSet([]SeriesSet{
+		newListSeriesSet(t, []rawSeries{
+			{
+				// Different chunks of a single set with equal time ranges are both kept.
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 1}, {2, 1}}, {{1, 2}, {2, 2}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 3}, {2, 3}}, {{3, 3}}},
+			},
+		}),
+	}, []int{1, 0})
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}, {2, 1}}, {{1, 2}, {2, 2}}, {{3, 3}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+// recordingVisitor records the name of the visitor method called for every response.
+type recordingVisitor struct {
+	calls []string
+	err   error
+}
+
+func (v *recordingVisitor) OnSeries(s *Series) error {
+	v.calls = append(v.calls, "series "+LabelsToString(s.Labels))
+	return v.err
+}
+
+func (v *recordingVisitor) OnWarning(w string) error {
+	v.calls = append(v.calls, "warning "+w)
+	return v.err
+}
+
+func (v *recordingVisitor) OnHints(h *types.Any) error {
+	v.calls = append(v.calls, "hints "+h.TypeUrl)
+	return v.err
+}
+
+func TestSeriesResponse_Accept(t *testing.T) {
+	s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}}})
+
+	v := &recordingVisitor{}
+	for _, r := range []*SeriesResponse{
+		NewSeriesResponse(&s),
+		NewWarnSeriesResponse(errors.New("warning")),
+		NewHintsSeriesResponse(&types.Any{TypeUrl: "hints"}),
+	} {
+		testutil.Ok(t, r.Accept(v))
+	}
+	testutil.Equals(t, []string{"series " + LabelsToString(s.Labels), "warning warning", "hints hints"}, v.calls)
+
+	v = &recordingVisitor{err: errors.New("stop")}
+	err := NewWarnSeriesResponse(errors.New("warning")).Accept(v)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "stop", err.Error())
+
+	testutil.NotOk(t, (&SeriesResponse{}).Accept(&recordingVisitor{}))
+}
+
+func TestEstimateSeriesResponseSize(t *testing.T) {
+	for _, tcase := range []struct {
+		desc   string
+		lset   labels.Labels
+		chunks [][]sample
+	}{
+		{desc: "empty"},
+		{desc: "labels only", lset: labels.FromStrings("__name__", "up", "instance", "localhost:9090")},
+		{
+			desc:   "labels and chunks",
+			lset:   labels.FromStrings("__name__", "up", "instance", "localhost:9090"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}, {1000, 5.5}}},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			s := newSeries(t, tcase.lset, tcase.chunks)
+			b, err := NewSeriesResponse(&s).Marshal()
+			testutil.Ok(t, err)
+			testutil.Equals(t, len(b), EstimateSeriesResponseSize(s.Labels, s.Chunks))
+		})
+	}
+}
+
+// failingSeriesSet returns the given number of series of the wrapped set and fails afterwards.
+type failingSeriesSet struct {
+	SeriesSet
+
+	n      int
+	err    error
+	failed bool
+}
+
+func (s *failingSeriesSet) Next() bool {
+	if s.n == 0 {
+		s.failed = true
+		return false
+	}
+	s.n--
+	return s.SeriesSet.Next()
+}
+
+func (s *failingSeriesSet) Err() error {
+	if s.failed {
+		return s.err
+	}
+	return s.SeriesSet.Err()
+}
+
+func TestMergeSeriesSetsWithStrategy(t *testing.T) {
+	input := func() []SeriesSet {
+		return []SeriesSet{
+			newListSeriesSet(t, []rawSeries{
+				{lset: labels.FromStrings("a", "1"), chunks: [][]sample{{{1, 1}}}},
+				{lset: labels.FromStrings("a", "3"), chunks: [][]sample{{{1, 3}}}},
+				{lset: labels.FromStrings("a", "5"), chunks: [][]sample{{{1, 5}}}},
+			}),
+			&failingSeriesSet{
+				SeriesSet: newListSeriesSet(t, []rawSeries{
+					{lset: labels.FromStrings("a", "2"), chunks: [][]sample{{{1, 2}}}},
+					{lset: labels.FromStrings("a", "4"), chunks: [][]sample{{{1, 4}}}},
+				}),
+				n:   1,
+				err: errors.New("store unavailable"),
+			},
+		}
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		s := MergeSeriesSetsWithStrategy(PartialResponseStrategy_ABORT, input()...)
+		seriesEquals(t, []rawSeries{
+			{lset: labels.FromStrings("a", "1"), chunks: [][]sample{{{1, 1}}}},
+			{lset: labels.FromStrings("a", "2"), chunks: [][]sample{{{1, 2}}}},
+		}, s)
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "store unavailable", s.Err().Error())
+		testutil.Equals(t, 0, len(s.Warnings()))
+	})
+	t.Run("warn", func(t *testing.T) {
+		s := MergeSeriesSetsWithStrategy(PartialResponseStrategy_WARN, input()...)
+		seriesEquals(t, []rawSeries{
+			{lset: labels.FromStrings("a", "1"), chunks: [][]sample{{{1, 1}}}},
+			{lset: labels.FromStrings("a", "2"), chunks: [][]sample{{{1, 2}}}},
+			{lset: labels.FromStrings("a", "3"), chunks: [][]sample{{{1, 3}}}},
+			{lset: labels.FromStrings("a", "5"), chunks: [][]sample{{{1, 5}}}},
+		}, s)
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, []string{"store unavailable"}, s.Warnings())
+	})
+}
+
+func TestLabelNameSetDiff(t *testing.T) {
+	for _, tcase := range []struct {
+		desc         string
+		a, b         [][]Label
+		onlyA, onlyB []string
+	}{
+		{desc: "empty"},
+		{
+			desc: "same names",
+			a:    [][]Label{{{Name: "a", Value: "1"}}, {{Name: "b", Value: "1"}}},
+			b:    [][]Label{{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}}},
+		},
+		{
+			desc:  "overlapping",
+			a:     [][]Label{{{Name: "cluster", Value: "eu"}, {Name: "job", Value: "a"}}, {{Name: "zone", Value: "1"}, {Name: "job", Value: "b"}}},
+			b:     [][]Label{{{Name: "job", Value: "a"}, {Name: "region", Value: "eu"}}, {{Name: "instance", Value: "x"}}},
+			onlyA: []string{"cluster", "zone"},
+			onlyB: []string{"instance", "region"},
+		},
+		{
+			desc:  "disjoint",
+			a:     [][]Label{{{Name: "c", Value: "1"}, {Name: "a", Value: "1"}}},
+			b:     [][]Label{{{Name: "d", Value: "1"}}, {{Name: "b", Value: "1"}}},
+			onlyA: []string{"a", "c"},
+			onlyB: []string{"b", "d"},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			onlyA, onlyB := LabelNameSetDiff(tcase.a, tcase.b)
+			testutil.Equals(t, tcase.onlyA, onlyA)
+			testutil.Equals(t, tcase.onlyB, onlyB)
+		})
+	}
+}
+
+func TestLabelSetFromToMap(t *testing.T) {
+	m := map[string]string{"region": "eu", "cluster": "a", "replica": "0", "__name__": "", "Z": "z"}
+
+	ls := LabelSetFromMap(m)
+	testutil.Equals(t, LabelSet{Labels: []Label{
+		{Name: "Z", Value: "z"},
+		{Name: "__name__", Value: ""},
+		{Name: "cluster", Value: "a"},
+		{Name: "region", Value: "eu"},
+		{Name: "replica", Value: "0"},
+	}}, ls)
+	testutil.Equals(t, m, LabelSetToMap(ls))
+
+	testutil.Equals(t, 0, len(LabelSetFromMap(nil).Labels))
+	testutil.Equals(t, map[string]string{}, LabelSetToMap(LabelSet{}))
+}
+
+type countingSeriesSet struct {
+	SeriesSet
+
+	nexts int
+}
+
+func (s *countingSeriesSet) Next() bool {
+	s.nexts++
+	return s.SeriesSet.Next()
+}
+
+func TestMergeSeriesSets_Lazy(t *testing.T) {
+	var (
+		sets     []SeriesSet
+		counters []*countingSeriesSet
+	)
+	for i := 0; i < 5; i++ {
+		c := &countingSeriesSet{SeriesSet: newListSeriesSet(t, []rawSeries{{
+			lset:   labels.FromStrings("a", fmt.Sprintf("%d", i)),
+			chunks: [][]sample{{{1, 1}}},
+		}})}
+		sets = append(sets, c)
+		counters = append(counters, c)
+	}
+
+	s := MergeSeriesSets(sets...)
+	for i, c := range counters {
+		testutil.Equals(t, 0, c.nexts, "set %d", i)
+	}
+
+	testutil.Assert(t, s.Next(), "expected series")
+	lset, _ := s.At()
+	testutil.Equals(t, labels.FromStrings("a", "0"), LabelsToPromLabels(lset))
+	for i, c := range counters {
+		// Every set was advanced to its first series for the look-ahead.
+		testutil.Assert(t, c.nexts > 0, "set %d not initialized", i)
+	}
+
+	for s.Next() {
+	}
+	testutil.Ok(t, s.Err())
+	for i, c := range counters {
+		// Sets are not advanced anymore once done.
+		testutil.Equals(t, 2, c.nexts, "set %d", i)
+	}
+}
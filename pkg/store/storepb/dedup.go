@@ -0,0 +1,197 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// NewPenaltyDedupSeriesSet returns a series set which deduplicates HA replicas. Series which only differ in the
+// given replica labels are replicas of the same series, they are returned as a single series without the replica
+// labels. Samples of the raw chunks of all replicas are decoded and merged: samples of one replica are used until it
+// has a gap of more than twice its sampling interval, at which point the replica with the next sample is used, so
+// gaps of one replica are filled by the others. After switching, samples within half of the sampling interval after
+// the last one are skipped, so that the merged samples are not denser than the ones of a single replica. The merged
+// samples are re-encoded as XOR chunks. Aggregated chunks are not deduplicated, the ones of the first replica are
+// passed through as is.
+//
+// Since stripping replica labels changes the order of series, all series are buffered and sorted on the first
+// Next() call, so memory usage is proportional to the whole set.
+func NewPenaltyDedupSeriesSet(s SeriesSet, replicaLabels []string) SeriesSet {
+	return &relabelSeriesSet{load: func() ([]Series, error) { return penaltyDedupAndSort(s, replicaLabels) }, idx: -1}
+}
+
+func penaltyDedupAndSort(set SeriesSet, replicaLabels []string) ([]Series, error) {
+	var (
+		groups = map[string]int{}
+		// All replicas of every series, in order of their first occurrence.
+		replicas [][]Series
+	)
+	for set.Next() {
+		x := deepCopySeries(set.At())
+		lset, chks := withoutLabels(x.Labels, replicaLabels), x.Chunks
+
+		key := string(AppendLabels(nil, lset))
+		i, ok := groups[key]
+		if !ok {
+			i = len(replicas)
+			groups[key] = i
+			replicas = append(replicas, nil)
+		}
+		replicas[i] = append(replicas[i], Series{Labels: lset, Chunks: chks})
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]Series, 0, len(replicas))
+	for _, r := range replicas {
+		if len(r) == 1 {
+			ret = append(ret, r[0])
+			continue
+		}
+		chks, err := dedupReplicaChunks(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "deduplicate series %s", LabelsToString(r[0].Labels))
+		}
+		ret = append(ret, Series{Labels: r[0].Labels, Chunks: chks})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return CompareLabels(ret[i].Labels, ret[j].Labels) < 0
+	})
+	return ret, nil
+}
+
+// withoutLabels returns lset without the labels of the given names. The result keeps the order of lset and is only
+// copied if any label is removed.
+func withoutLabels(lset []Label, names []string) []Label {
+	var ret []Label
+	for i, l := range lset {
+		drop := false
+		for _, n := range names {
+			if l.Name == n {
+				drop = true
+				break
+			}
+		}
+		if drop && ret == nil {
+			ret = make([]Label, i, len(lset)-1)
+			copy(ret, lset[:i])
+		}
+		if !drop && ret != nil {
+			ret = append(ret, l)
+		}
+	}
+	if ret == nil {
+		return lset
+	}
+	return ret
+}
+
+// dedupReplicaChunks returns the deduplicated raw chunks of all replicas followed by the aggregated chunks of the
+// first replica.
+func dedupReplicaChunks(replicas []Series) ([]AggrChunk, error) {
+	var merged []Sample
+	for i, r := range replicas {
+		samples, err := rawSamples(r.Chunks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replica %d", i)
+		}
+		if i == 0 {
+			merged = samples
+			continue
+		}
+		merged = penaltyDedup(merged, samples)
+	}
+
+	ret, err := encodeSamples(merged, samplesPerChunk)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range replicas[0].Chunks {
+		if c.Raw == nil {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+// rawSamples returns the samples of all raw chunks sorted by timestamp. Only the first sample of duplicated
+// timestamps is kept.
+func rawSamples(chks []AggrChunk) ([]Sample, error) {
+	var ret []Sample
+	for i, c := range chks {
+		if c.Raw == nil {
+			continue
+		}
+		samples, err := c.Raw.Samples()
+		if err != nil {
+			return nil, errors.Wrapf(err, "chunk %d", i)
+		}
+		ret = append(ret, samples...)
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].T < ret[j].T })
+
+	deduped := ret[:0]
+	for i, smpl := range ret {
+		if i > 0 && smpl.T == ret[i-1].T {
+			continue
+		}
+		deduped = append(deduped, smpl)
+	}
+	return deduped, nil
+}
+
+// penaltyDedup merges the sorted samples of two replicas. Samples of the current replica are used as long as the
+// next one is at most twice the sampling interval after the last sample. Otherwise the current replica has a gap and
+// the earlier of its next sample and the next sample of the other replica is used, switching replicas if needed.
+// Samples of the other replica within half of the sampling interval after the last sample are skipped as a penalty,
+// so that switching does not increase the sampling frequency. The interval is the one between consecutive samples
+// of the same replica.
+func penaltyDedup(a, b []Sample) []Sample {
+	// If no interval is known yet, use 5s, based on typical scrape intervals of multiple seconds.
+	const initialInterval = 5000
+
+	var (
+		ret      = make([]Sample, 0, len(a))
+		cur, oth = a, b
+		interval = int64(initialInterval)
+	)
+	// Start with the replica having the earliest sample.
+	if len(a) == 0 || len(b) > 0 && b[0].T < a[0].T {
+		cur, oth = b, a
+	}
+	if len(cur) == 0 {
+		return ret
+	}
+	ret = append(ret, cur[0])
+	cur = cur[1:]
+
+	for {
+		lastT := ret[len(ret)-1].T
+		for len(cur) > 0 && cur[0].T <= lastT {
+			cur = cur[1:]
+		}
+		for len(oth) > 0 && oth[0].T <= lastT+interval/2 {
+			oth = oth[1:]
+		}
+
+		switch {
+		case len(cur) > 0 && (cur[0].T <= lastT+2*interval || len(oth) == 0 || cur[0].T <= oth[0].T):
+			if cur[0].T <= lastT+2*interval {
+				interval = cur[0].T - lastT
+			}
+			ret = append(ret, cur[0])
+			cur = cur[1:]
+		case len(oth) > 0:
+			// The current replica has a gap, continue with the other one.
+			ret = append(ret, oth[0])
+			cur, oth = oth[1:], cur
+		default:
+			return ret
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// scrapes returns one chunk per given time range with samples every 10s, shifted by offset. Values are the
+// number of the scrape interval, so they are equal across replicas.
+func scrapes(offset int64, ranges ...[2]int64) [][]sample {
+	var ret [][]sample
+	for _, r := range ranges {
+		var chk []sample
+		for t := r[0]; t <= r[1]; t += 10000 {
+			chk = append(chk, sample{t + offset, float64(t / 10000)})
+		}
+		ret = append(ret, chk)
+	}
+	return ret
+}
+
+func TestPenaltyDedupSeriesSet(t *testing.T) {
+	in := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "replica", "a"),
+			chunks: scrapes(0, [2]int64{0, 40000}, [2]int64{110000, 190000}),
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "replica", "b"),
+			chunks: scrapes(0, [2]int64{0, 110000}, [2]int64{180000, 190000}),
+		},
+		// Replicas scraping 3s apart, sorting differently without the replica label.
+		{
+			lset:   labels.FromStrings("a", "2", "replica", "a", "z", "1"),
+			chunks: scrapes(0, [2]int64{0, 20000}, [2]int64{60000, 90000}),
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "replica", "a", "z", "2"),
+			chunks: scrapes(0, [2]int64{0, 10000}),
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "replica", "b", "z", "1"),
+			chunks: scrapes(3000, [2]int64{0, 60000}),
+		},
+	})
+
+	s := NewPenaltyDedupSeriesSet(in, []string{"replica"})
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: scrapes(0, [2]int64{0, 190000}),
+		},
+		{
+			// The second replica is used from the gap of the first one until it ends.
+			lset: labels.FromStrings("a", "2", "z", "1"),
+			chunks: [][]sample{{
+				{0, 0}, {10000, 1}, {20000, 2}, {33000, 3}, {43000, 4}, {53000, 5}, {63000, 6},
+				{70000, 7}, {80000, 8}, {90000, 9},
+			}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "z", "2"),
+			chunks: scrapes(0, [2]int64{0, 10000}),
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestPenaltyDedupSeriesSet_Offset(t *testing.T) {
+	// Replicas scraping 7s apart, more than half of the interval.
+	in := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "replica", "a"),
+			chunks: scrapes(0, [2]int64{0, 40000}, [2]int64{110000, 190000}),
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "replica", "b"),
+			chunks: scrapes(7000, [2]int64{0, 190000}),
+		},
+	})
+
+	// After the gap of the first replica the second one is used until its end, even though the first one resumes.
+	s := NewPenaltyDedupSeriesSet(in, []string{"replica"})
+	expected := scrapes(0, [2]int64{0, 40000})
+	expected[0] = append(expected[0], scrapes(7000, [2]int64{40000, 190000})[0]...)
+	seriesEquals(t, []rawSeries{{lset: labels.FromStrings("a", "1"), chunks: expected}}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestPenaltyDedupSeriesSet_Chunks(t *testing.T) {
+	in := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "replica", "a"),
+			chunks: scrapes(0, [2]int64{0, 1990000}),
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "replica", "b"),
+			chunks: scrapes(0, [2]int64{0, 1990000}),
+		},
+	})
+	raw := in.series[0].Chunks[0].Raw
+	agg := AggrChunk{MinTime: 0, MaxTime: 1990000, Count: raw, Sum: raw}
+	in.series[0].Chunks = append(in.series[0].Chunks, agg)
+
+	s := NewPenaltyDedupSeriesSet(in, []string{"replica"})
+	testutil.Assert(t, s.Next(), "expected series")
+	lset, chks := s.At()
+	testutil.Equals(t, []Label{{Name: "a", Value: "1"}}, lset)
+
+	// 200 samples are re-encoded into chunks of 120 samples, followed by the aggregated chunks of the first replica.
+	testutil.Equals(t, 3, len(chks))
+	testutil.Equals(t, []AggrChunk{{MinTime: 0, MaxTime: 1190000}, {MinTime: 1200000, MaxTime: 1990000}}, chunkTimes(chks[:2]))
+	testutil.Equals(t, agg, chks[2])
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+
+	t.Run("invalid chunk", func(t *testing.T) {
+		in.idx = -1
+		in.series[1].Chunks[0].Raw = &Chunk{Type: Chunk_XOR}
+		s := NewPenaltyDedupSeriesSet(in, []string{"replica"})
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+	})
+	t.Run("set error", func(t *testing.T) {
+		err := errors.New("test")
+		s := NewPenaltyDedupSeriesSet(errSeriesSet{err: err}, []string{"replica"})
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.Equals(t, err, s.Err())
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// NewDeltaSeriesSet returns a series set which converts raw counter series into the increase between consecutive
+// samples, e.g. for exporting to clients that do not compute rates themselves. A counter reset is detected when a
+// value is lower than the previous one, in which case the delta is the new value itself, as with PromQL increase().
+// The first sample of a series has no delta and is dropped, as are chunks left without samples. Chunks have to be
+// sorted by time. It fails on series with non-raw chunks. An error is returned if s has failed already.
+func NewDeltaSeriesSet(s SeriesSet) (SeriesSet, error) {
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "wrapped series set")
+	}
+	return &deltaSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}, nil
+}
+
+type deltaSeriesSet struct {
+	erroringSeriesSet
+
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *deltaSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	s.lset = lset
+	s.chunks, s.err = deltaChunks(chks)
+	if s.err != nil {
+		s.err = errors.Wrapf(s.err, "series %s", LabelsToString(lset))
+		return false
+	}
+	return true
+}
+
+func deltaChunks(chks []AggrChunk) ([]AggrChunk, error) {
+	var (
+		ret   = make([]AggrChunk, 0, len(chks))
+		prev  float64
+		first = true
+	)
+	for i, c := range chks {
+		if c.Raw == nil {
+			return nil, errors.Errorf("chunk %d is not a raw chunk", i)
+		}
+		src, err := c.Raw.Decode()
+		if err != nil {
+			return nil, errors.Wrapf(err, "chunk %d", i)
+		}
+
+		dst := chunkenc.NewXORChunk()
+		app, err := dst.Appender()
+		if err != nil {
+			return nil, err
+		}
+
+		var mint, maxt int64
+		it := src.Iterator(nil)
+		for it.Next() {
+			t, v := it.At()
+			if first {
+				prev, first = v, false
+				continue
+			}
+
+			d := v - prev
+			if v < prev {
+				d = v
+			}
+			prev = v
+
+			if dst.NumSamples() == 0 {
+				mint = t
+			}
+			maxt = t
+			app.Append(t, d)
+		}
+		if err := it.Err(); err != nil {
+			return nil, errors.Wrapf(err, "decode samples of chunk %d", i)
+		}
+
+		if dst.NumSamples() == 0 {
+			continue
+		}
+		ret = append(ret, AggrChunk{
+			MinTime: mint,
+			MaxTime: maxt,
+			Raw:     &Chunk{Type: Chunk_XOR, Data: dst.Bytes()},
+		})
+	}
+	return ret, nil
+}
+
+func (s *deltaSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
@@ -0,0 +1,59 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestDeltaSeriesSet(t *testing.T) {
+	s, err := NewDeltaSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 10}, {2, 15}, {3, 15}}, {{4, 20}, {5, 3}, {6, 8}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 5}}, {{2, 6}, {3, 0}}},
+		},
+	}))
+	testutil.Ok(t, err)
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset: labels.FromStrings("a", "1"),
+			// The counter resets between 20 and 3.
+			chunks: [][]sample{{{2, 5}, {3, 0}}, {{4, 5}, {5, 3}, {6, 5}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{2, 1}, {3, 0}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestDeltaSeriesSet_AggrChunks(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 10}, {2, 15}}},
+		},
+	})
+	l.series[0].Chunks[0].Count, l.series[0].Chunks[0].Raw = l.series[0].Chunks[0].Raw, nil
+
+	s, err := NewDeltaSeriesSet(l)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !s.Next(), "expected error")
+	testutil.NotOk(t, s.Err())
+}
+
+func TestDeltaSeriesSet_FailedSet(t *testing.T) {
+	_, err := NewDeltaSeriesSet(errSeriesSet{err: errors.New("test")})
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,178 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ClosableSeriesSet is a series set which can be closed if it is not going to be drained.
+type ClosableSeriesSet interface {
+	SeriesSet
+	// Close stops the series set from receiving further series.
+	Close()
+}
+
+// NewSeriesSetDemux returns n series sets which receive the series of s in a round-robin fashion, so that they can
+// be processed concurrently by different goroutines. A single goroutine, started on the first Next() call of any
+// child, pulls series from s. The order of series is preserved within a child, but not across children.
+//
+// An error of s is returned by Err() of all children. Each child implements Close(), which must be called if the
+// child is not going to be drained: further series are then handed to the remaining children, and once all of them
+// are closed the source is not read anymore.
+func NewSeriesSetDemux(s SeriesSet, n int) []SeriesSet {
+	children := newSeriesSetDemux(s, n, nil)
+	ret := make([]SeriesSet, 0, len(children))
+	for _, c := range children {
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// ShardSeriesSet returns the given number of series sets, each receiving the series of s with a label hash
+// modulo the number of shards equal to its index, e.g. for parallel aggregation which is merged again later.
+// As series are routed by labels, each shard is sorted. Otherwise it works like NewSeriesSetDemux, except that
+// series of closed shards are dropped. As a single goroutine feeds all shards, they have to be drained concurrently.
+// An error is returned if shards is not positive.
+func ShardSeriesSet(s SeriesSet, shards int) ([]ClosableSeriesSet, error) {
+	if shards < 1 {
+		return nil, errors.Errorf("invalid number of shards %d", shards)
+	}
+	children := newSeriesSetDemux(s, shards, func(lset []Label) int {
+		return int(HashLabels(lset) % uint64(shards))
+	})
+	ret := make([]ClosableSeriesSet, 0, len(children))
+	for _, c := range children {
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// newSeriesSetDemux returns n children receiving the series of s. If route is not nil, it selects the child for
+// each series, otherwise series are distributed round-robin.
+func newSeriesSetDemux(s SeriesSet, n int, route func([]Label) int) []*demuxedSeriesSet {
+	d := &seriesSetDemux{set: s, route: route}
+	for i := 0; i < n; i++ {
+		d.children = append(d.children, &demuxedSeriesSet{
+			d:    d,
+			ch:   make(chan demuxedSeries),
+			done: make(chan struct{}),
+		})
+	}
+	return d.children
+}
+
+type seriesSetDemux struct {
+	set      SeriesSet
+	route    func([]Label) int
+	children []*demuxedSeriesSet
+	start    sync.Once
+
+	mtx sync.Mutex
+	err error
+}
+
+type demuxedSeries struct {
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (d *seriesSetDemux) run() {
+	defer func() {
+		for _, c := range d.children {
+			close(c.ch)
+		}
+	}()
+
+	next := 0
+	for d.set.Next() {
+		lset, chks := d.set.At()
+		x := demuxedSeries{lset: lset, chunks: chks}
+
+		if d.route != nil {
+			c := d.children[d.route(lset)]
+			select {
+			case c.ch <- x:
+			case <-c.done:
+				if d.allClosed() {
+					return
+				}
+			}
+			continue
+		}
+
+		sent := false
+		for i := 0; i < len(d.children) && !sent; i++ {
+			c := d.children[next]
+			next = (next + 1) % len(d.children)
+
+			select {
+			case c.ch <- x:
+				sent = true
+			case <-c.done:
+			}
+		}
+		if !sent {
+			// All children were closed, there is no one left to consume the series.
+			return
+		}
+	}
+
+	d.mtx.Lock()
+	d.err = d.set.Err()
+	d.mtx.Unlock()
+}
+
+func (d *seriesSetDemux) allClosed() bool {
+	for _, c := range d.children {
+		select {
+		case <-c.done:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// demuxedSeriesSet is a single child series set returned by NewSeriesSetDemux.
+type demuxedSeriesSet struct {
+	d    *seriesSetDemux
+	ch   chan demuxedSeries
+	done chan struct{}
+	once sync.Once
+
+	cur demuxedSeries
+}
+
+func (s *demuxedSeriesSet) Next() bool {
+	s.d.start.Do(func() { go s.d.run() })
+
+	select {
+	case x, ok := <-s.ch:
+		if !ok {
+			return false
+		}
+		s.cur = x
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *demuxedSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.cur.lset, s.cur.chunks
+}
+
+func (s *demuxedSeriesSet) Err() error {
+	s.d.mtx.Lock()
+	defer s.d.mtx.Unlock()
+	return s.d.err
+}
+
+// Close stops the series set from receiving further series.
+func (s *demuxedSeriesSet) Close() {
+	s.once.Do(func() { close(s.done) })
+}
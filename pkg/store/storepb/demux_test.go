@@ -0,0 +1,188 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func demuxTestSeries(num int) []rawSeries {
+	var in []rawSeries
+	for i := 0; i < num; i++ {
+		in = append(in, rawSeries{
+			lset:   labels.FromStrings("a", fmt.Sprintf("%03d", i)),
+			chunks: [][]sample{{{int64(i), 1}}},
+		})
+	}
+	return in
+}
+
+func TestSeriesSetDemux(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	in := demuxTestSeries(100)
+	children := NewSeriesSetDemux(newListSeriesSet(t, in), 4)
+	testutil.Equals(t, 4, len(children))
+
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		seen = map[string]int{}
+	)
+	for i, c := range children {
+		wg.Add(1)
+		go func(i int, c SeriesSet) {
+			defer wg.Done()
+
+			var prev []Label
+			for c.Next() {
+				lset, _ := c.At()
+				if prev != nil {
+					testutil.Assert(t, CompareLabels(prev, lset) < 0, "expected sorted series within child %d", i)
+				}
+				prev = lset
+
+				mtx.Lock()
+				seen[LabelsToString(lset)]++
+				mtx.Unlock()
+			}
+			testutil.Ok(t, c.Err())
+		}(i, c)
+	}
+	wg.Wait()
+
+	testutil.Equals(t, len(in), len(seen))
+	for k, v := range seen {
+		testutil.Equals(t, 1, v, "series %s", k)
+	}
+}
+
+func TestSeriesSetDemux_Error(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	expectedErr := errors.New("test error")
+	children := NewSeriesSetDemux(MergeSeriesSets(newListSeriesSet(t, demuxTestSeries(10)), errSeriesSet{err: expectedErr}), 2)
+
+	var wg sync.WaitGroup
+	for _, c := range children {
+		wg.Add(1)
+		go func(c SeriesSet) {
+			defer wg.Done()
+			for c.Next() {
+			}
+			testutil.Equals(t, expectedErr, c.Err())
+		}(c)
+	}
+	wg.Wait()
+}
+
+func TestSeriesSetDemux_ChildClosedEarly(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	in := demuxTestSeries(50)
+	children := NewSeriesSetDemux(newListSeriesSet(t, in), 2)
+
+	// The first child reads a single series and stops.
+	testutil.Assert(t, children[0].Next(), "expected series")
+	children[0].(interface{ Close() }).Close()
+	testutil.Assert(t, !children[0].Next(), "expected closed child to stop")
+
+	// All remaining series are handed to the second child.
+	count := 1
+	for children[1].Next() {
+		count++
+	}
+	testutil.Ok(t, children[1].Err())
+	testutil.Equals(t, len(in), count)
+}
+
+func TestSeriesSetDemux_AllChildrenClosed(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	children := NewSeriesSetDemux(newListSeriesSet(t, demuxTestSeries(50)), 2)
+	testutil.Assert(t, children[0].Next(), "expected series")
+	for _, c := range children {
+		c.(interface{ Close() }).Close()
+	}
+}
+
+func TestShardSeriesSet(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	in := demuxTestSeries(100)
+	shards, err := ShardSeriesSet(newListSeriesSet(t, in), 3)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(shards))
+
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		seen = map[string]int{}
+	)
+	for i, s := range shards {
+		wg.Add(1)
+		go func(i int, s SeriesSet) {
+			defer wg.Done()
+
+			var prev []Label
+			for s.Next() {
+				lset, _ := s.At()
+				testutil.Equals(t, uint64(i), HashLabels(lset)%3)
+				if prev != nil {
+					testutil.Assert(t, CompareLabels(prev, lset) < 0, "expected sorted series within shard %d", i)
+				}
+				prev = lset
+
+				mtx.Lock()
+				seen[LabelsToString(lset)]++
+				mtx.Unlock()
+			}
+			testutil.Ok(t, s.Err())
+		}(i, s)
+	}
+	wg.Wait()
+
+	testutil.Equals(t, len(in), len(seen))
+	for k, v := range seen {
+		testutil.Equals(t, 1, v, "series %s", k)
+	}
+}
+
+func TestShardSeriesSet_ShardClosedEarly(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	in := demuxTestSeries(100)
+	shards, err := ShardSeriesSet(newListSeriesSet(t, in), 2)
+	testutil.Ok(t, err)
+	shards[0].Close()
+
+	expected := 0
+	for _, s := range in {
+		if HashLabels(PromLabelsToLabels(s.lset))%2 == 1 {
+			expected++
+		}
+	}
+	got := 0
+	for shards[1].Next() {
+		got++
+	}
+	testutil.Ok(t, shards[1].Err())
+	testutil.Equals(t, expected, got)
+	testutil.Assert(t, !shards[0].Next(), "expected closed shard to be empty")
+}
+
+func TestShardSeriesSet_InvalidShards(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		_, err := ShardSeriesSet(newListSeriesSet(t, demuxTestSeries(1)), n)
+		testutil.NotOk(t, err)
+	}
+}
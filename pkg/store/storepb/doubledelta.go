@@ -0,0 +1,172 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// Layout of the header of a Prometheus 1.x double-delta chunk. Chunks with a single sample only carry the first
+// doubleDeltaHeaderMinBytes bytes of it.
+const (
+	doubleDeltaHeaderBytes    = 37
+	doubleDeltaHeaderMinBytes = 21
+
+	doubleDeltaHeaderBufLenOffset         = 0
+	doubleDeltaHeaderTimeBytesOffset      = 2
+	doubleDeltaHeaderValueBytesOffset     = 3
+	doubleDeltaHeaderIsIntOffset          = 4
+	doubleDeltaHeaderBaseTimeOffset       = 5
+	doubleDeltaHeaderBaseValueOffset      = 13
+	doubleDeltaHeaderBaseTimeDeltaOffset  = 21
+	doubleDeltaHeaderBaseValueDeltaOffset = 29
+)
+
+// encDoubleDelta is the chunkenc.Encoding reported by double-delta chunks. It is not known to Prometheus 2.x, so
+// the chunks cannot be written to TSDB blocks and have to be re-encoded first.
+const encDoubleDelta chunkenc.Encoding = 255
+
+// doubleDeltaChunk is a read-only chunkenc.Chunk for the legacy Prometheus 1.x double-delta encoding.
+// Samples 0 and 1 are stored in the header as base time and value and their deltas. Every further sample i is
+// stored as the difference to base + i*delta, using the number of bytes given in the header. Differences of 8 bytes
+// are absolute values instead.
+type doubleDeltaChunk struct {
+	b          []byte
+	timeBytes  int
+	valueBytes int
+	isInt      bool
+	n          int
+}
+
+func newDoubleDeltaChunk(b []byte) (*doubleDeltaChunk, error) {
+	if len(b) < doubleDeltaHeaderIsIntOffset+1 {
+		return nil, errors.Errorf("double-delta chunk data too short: %d bytes", len(b))
+	}
+	c := &doubleDeltaChunk{
+		timeBytes:  int(b[doubleDeltaHeaderTimeBytesOffset]),
+		valueBytes: int(b[doubleDeltaHeaderValueBytesOffset]),
+		isInt:      b[doubleDeltaHeaderIsIntOffset] == 1,
+	}
+
+	switch c.timeBytes {
+	case 1, 2, 4, 8:
+	default:
+		return nil, errors.Errorf("invalid double-delta time bytes %d", c.timeBytes)
+	}
+	switch {
+	case c.isInt && (c.valueBytes == 0 || c.valueBytes == 1 || c.valueBytes == 2 || c.valueBytes == 4):
+	case !c.isInt && (c.valueBytes == 4 || c.valueBytes == 8):
+	default:
+		return nil, errors.Errorf("invalid double-delta value bytes %d for integer values %v", c.valueBytes, c.isInt)
+	}
+
+	// The remainder of the buffer is unused, it is only present for chunks read from Prometheus 1.x storage as-is.
+	bufLen := int(binary.LittleEndian.Uint16(b[doubleDeltaHeaderBufLenOffset:]))
+	if bufLen > len(b) {
+		return nil, errors.Errorf("double-delta chunk length %d exceeds data of %d bytes", bufLen, len(b))
+	}
+	c.b = b[:bufLen]
+
+	switch {
+	case bufLen <= doubleDeltaHeaderIsIntOffset+1:
+		c.n = 0
+	case bufLen == doubleDeltaHeaderMinBytes:
+		c.n = 1
+	case bufLen >= doubleDeltaHeaderBytes:
+		c.n = (bufLen-doubleDeltaHeaderBytes)/(c.timeBytes+c.valueBytes) + 2
+	default:
+		return nil, errors.Errorf("invalid double-delta chunk length %d", bufLen)
+	}
+	return c, nil
+}
+
+func (c *doubleDeltaChunk) Bytes() []byte               { return c.b }
+func (c *doubleDeltaChunk) Encoding() chunkenc.Encoding { return encDoubleDelta }
+func (c *doubleDeltaChunk) NumSamples() int             { return c.n }
+func (c *doubleDeltaChunk) Iterator(chunkenc.Iterator) chunkenc.Iterator {
+	return &doubleDeltaIterator{c: c, i: -1}
+}
+
+func (c *doubleDeltaChunk) Appender() (chunkenc.Appender, error) {
+	return nil, errors.New("double-delta chunks are read-only")
+}
+
+func (c *doubleDeltaChunk) at(i int) (int64, float64) {
+	var (
+		baseT = int64(binary.LittleEndian.Uint64(c.b[doubleDeltaHeaderBaseTimeOffset:]))
+		baseV = math.Float64frombits(binary.LittleEndian.Uint64(c.b[doubleDeltaHeaderBaseValueOffset:]))
+	)
+	if i == 0 {
+		return baseT, baseV
+	}
+
+	var (
+		deltaT = int64(binary.LittleEndian.Uint64(c.b[doubleDeltaHeaderBaseTimeDeltaOffset:]))
+		deltaV = math.Float64frombits(binary.LittleEndian.Uint64(c.b[doubleDeltaHeaderBaseValueDeltaOffset:]))
+	)
+	if i == 1 {
+		t, v := baseT+deltaT, baseV+deltaV
+		// With 8 bytes the second sample is stored as is rather than as delta.
+		if c.timeBytes == 8 {
+			t = deltaT
+		}
+		if c.valueBytes == 8 {
+			v = deltaV
+		}
+		return t, v
+	}
+
+	off := doubleDeltaHeaderBytes + (i-2)*(c.timeBytes+c.valueBytes)
+	t := baseT + int64(i)*deltaT
+	switch c.timeBytes {
+	case 1:
+		t += int64(int8(c.b[off]))
+	case 2:
+		t += int64(int16(binary.LittleEndian.Uint16(c.b[off:])))
+	case 4:
+		t += int64(int32(binary.LittleEndian.Uint32(c.b[off:])))
+	case 8:
+		t = int64(binary.LittleEndian.Uint64(c.b[off:]))
+	}
+
+	off += c.timeBytes
+	v := baseV + float64(i)*deltaV
+	switch {
+	case c.valueBytes == 0:
+	case c.isInt && c.valueBytes == 1:
+		v += float64(int8(c.b[off]))
+	case c.isInt && c.valueBytes == 2:
+		v += float64(int16(binary.LittleEndian.Uint16(c.b[off:])))
+	case c.isInt && c.valueBytes == 4:
+		v += float64(int32(binary.LittleEndian.Uint32(c.b[off:])))
+	case c.valueBytes == 4:
+		v += float64(math.Float32frombits(binary.LittleEndian.Uint32(c.b[off:])))
+	case c.valueBytes == 8:
+		v = math.Float64frombits(binary.LittleEndian.Uint64(c.b[off:]))
+	}
+	return t, v
+}
+
+type doubleDeltaIterator struct {
+	c *doubleDeltaChunk
+	i int
+	t int64
+	v float64
+}
+
+func (it *doubleDeltaIterator) Next() bool {
+	if it.i+1 >= it.c.n {
+		return false
+	}
+	it.i++
+	it.t, it.v = it.c.at(it.i)
+	return true
+}
+
+func (it *doubleDeltaIterator) At() (int64, float64) { return it.t, it.v }
+func (it *doubleDeltaIterator) Err() error           { return nil }
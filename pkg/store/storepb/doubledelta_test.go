@@ -0,0 +1,138 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// doubleDeltaData returns a double-delta chunk with the given header followed by the given samples data.
+func doubleDeltaData(timeBytes, valueBytes byte, isInt bool, baseT int64, baseV float64, deltaT int64, deltaV float64, samples ...byte) []byte {
+	b := make([]byte, doubleDeltaHeaderBytes, doubleDeltaHeaderBytes+len(samples))
+	b[doubleDeltaHeaderTimeBytesOffset] = timeBytes
+	b[doubleDeltaHeaderValueBytesOffset] = valueBytes
+	if isInt {
+		b[doubleDeltaHeaderIsIntOffset] = 1
+	}
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseTimeOffset:], uint64(baseT))
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseValueOffset:], math.Float64bits(baseV))
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseTimeDeltaOffset:], uint64(deltaT))
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseValueDeltaOffset:], math.Float64bits(deltaV))
+	b = append(b, samples...)
+	binary.LittleEndian.PutUint16(b[doubleDeltaHeaderBufLenOffset:], uint16(len(b)))
+	return b
+}
+
+func TestChunk_DoubleDelta(t *testing.T) {
+	for _, tcase := range []struct {
+		desc     string
+		data     []byte
+		expected []Sample
+	}{
+		{
+			desc: "1 byte time and 4 bytes float deltas",
+			data: []byte{
+				0x2f, 0x00, // Used bytes.
+				0x01, 0x04, 0x00, // Time bytes, value bytes, is integer.
+				0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Base time 1000.
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f, // Base value 1.0.
+				0x0f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Base time delta 15.
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xe0, 0x3f, // Base value delta 0.5.
+				0x01, 0x00, 0x00, 0x80, 0x3e, // +1, +0.25.
+				0xfe, 0x00, 0x00, 0x00, 0xbf, // -2, -0.5.
+				0x00, 0x00, 0x00, 0x00, // Unused.
+			},
+			expected: []Sample{{T: 1000, V: 1}, {T: 1015, V: 1.5}, {T: 1031, V: 2.25}, {T: 1043, V: 2}},
+		},
+		{
+			desc: "single sample",
+			data: func() []byte {
+				b := doubleDeltaData(1, 4, false, 1000, 1, 0, 0)
+				binary.LittleEndian.PutUint16(b, doubleDeltaHeaderMinBytes)
+				return b[:doubleDeltaHeaderMinBytes]
+			}(),
+			expected: []Sample{{T: 1000, V: 1}},
+		},
+		{
+			desc: "2 bytes time and 1 byte integer deltas",
+			data: doubleDeltaData(2, 1, true, 0, 10, 1000, 1,
+				0xfb, 0xff, 0xfd, // -5, -3.
+			),
+			expected: []Sample{{T: 0, V: 10}, {T: 1000, V: 11}, {T: 1995, V: 9}},
+		},
+		{
+			desc:     "4 bytes time and no integer deltas",
+			data:     doubleDeltaData(4, 0, true, 0, 10, 1000, 2, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00),
+			expected: []Sample{{T: 0, V: 10}, {T: 1000, V: 12}, {T: 2000, V: 14}, {T: 3001, V: 16}},
+		},
+		{
+			desc: "8 bytes absolute time and float values",
+			data: doubleDeltaData(8, 8, false, 1000, 1, 5000, 7,
+				0x28, 0x23, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // 9000.
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x40, // 3.5.
+			),
+			expected: []Sample{{T: 1000, V: 1}, {T: 5000, V: 7}, {T: 9000, V: 3.5}},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			c := &Chunk{Type: Chunk_DOUBLE_DELTA, Data: tcase.data}
+			testutil.Ok(t, c.Validate())
+
+			samples, err := c.Samples()
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, samples)
+
+			xor, err := c.ReEncode(Chunk_XOR)
+			testutil.Ok(t, err)
+			testutil.Equals(t, Chunk_XOR, xor.Type)
+
+			samples, err = xor.Samples()
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, samples)
+		})
+	}
+}
+
+func TestChunk_DoubleDelta_Invalid(t *testing.T) {
+	for _, tcase := range []struct {
+		desc string
+		data []byte
+	}{
+		{desc: "too short", data: []byte{0x05, 0x00, 0x01}},
+		{desc: "invalid time bytes", data: doubleDeltaData(3, 4, false, 0, 0, 0, 0)},
+		{desc: "invalid float value bytes", data: doubleDeltaData(1, 1, false, 0, 0, 0, 0)},
+		{desc: "invalid integer value bytes", data: doubleDeltaData(1, 8, true, 0, 0, 0, 0)},
+		{
+			desc: "length exceeds data",
+			data: func() []byte {
+				b := doubleDeltaData(1, 4, false, 0, 0, 0, 0)
+				binary.LittleEndian.PutUint16(b, 100)
+				return b
+			}(),
+		},
+		{
+			desc: "truncated header",
+			data: func() []byte {
+				b := doubleDeltaData(1, 4, false, 0, 0, 0, 0)
+				binary.LittleEndian.PutUint16(b, 30)
+				return b
+			}(),
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			_, err := (&Chunk{Type: Chunk_DOUBLE_DELTA, Data: tcase.data}).Samples()
+			testutil.NotOk(t, err)
+		})
+	}
+
+	// Double-delta chunks can only be read.
+	c, err := (&Chunk{Type: Chunk_DOUBLE_DELTA, Data: doubleDeltaData(1, 4, false, 0, 0, 0, 0)}).Decode()
+	testutil.Ok(t, err)
+	_, err = c.Appender()
+	testutil.NotOk(t, err)
+}
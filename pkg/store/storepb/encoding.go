@@ -0,0 +1,79 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"encoding/binary"
+
+	"github.com/cespare/xxhash"
+	"github.com/pkg/errors"
+)
+
+// AppendLabels appends the compact binary representation of the given labels to dst and returns the extended buffer.
+// The encoding is the number of labels followed by the name and value of each label, all prefixed with their
+// uvarint encoded length. It is stable, so the result can be used as a cache key.
+func AppendLabels(dst []byte, lset []Label) []byte {
+	dst = appendUvarint(dst, uint64(len(lset)))
+	for _, l := range lset {
+		dst = appendUvarint(dst, uint64(len(l.Name)))
+		dst = append(dst, l.Name...)
+		dst = appendUvarint(dst, uint64(len(l.Value)))
+		dst = append(dst, l.Value...)
+	}
+	return dst
+}
+
+// HashLabels returns a stable hash of the given labels, e.g. for bucketing series. The hash is computed over the
+// AppendLabels encoding, so it is the same across processes and label order matters.
+func HashLabels(lset []Label) uint64 {
+	return xxhash.Sum64(AppendLabels(nil, lset))
+}
+
+func appendUvarint(dst []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(dst, buf[:n]...)
+}
+
+// DecodeLabels decodes labels encoded with AppendLabels from the beginning of src.
+// It returns the labels and the number of bytes read.
+func DecodeLabels(src []byte) ([]Label, int, error) {
+	n, read := binary.Uvarint(src)
+	if read <= 0 {
+		return nil, 0, errors.New("decode number of labels")
+	}
+	// Every label takes at least two bytes for its name and value lengths.
+	if n > uint64(len(src)-read)/2 {
+		return nil, 0, errors.Errorf("invalid number of labels %d", n)
+	}
+
+	lset := make([]Label, 0, n)
+	for i := uint64(0); i < n; i++ {
+		name, k, err := decodeString(src[read:])
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "decode name of label %d", i)
+		}
+		read += k
+
+		value, k, err := decodeString(src[read:])
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "decode value of label %d", i)
+		}
+		read += k
+
+		lset = append(lset, Label{Name: name, Value: value})
+	}
+	return lset, read, nil
+}
+
+func decodeString(src []byte) (string, int, error) {
+	l, n := binary.Uvarint(src)
+	if n <= 0 {
+		return "", 0, errors.New("decode length")
+	}
+	if l > uint64(len(src)-n) {
+		return "", 0, errors.Errorf("length %d exceeds remaining %d bytes", l, len(src)-n)
+	}
+	return string(src[n : n+int(l)]), n + int(l), nil
+}
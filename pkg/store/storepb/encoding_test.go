@@ -0,0 +1,113 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestAppendDecodeLabels(t *testing.T) {
+	for _, tcase := range []struct {
+		desc string
+		lset []Label
+	}{
+		{
+			desc: "nil",
+		},
+		{
+			desc: "single label",
+			lset: []Label{{Name: "a", Value: "1"}},
+		},
+		{
+			desc: "empty values and names",
+			lset: PromLabelsToLabels(labels.FromMap(testLsetMap)),
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			b := AppendLabels(nil, tcase.lset)
+			testutil.Equals(t, b, AppendLabels(nil, tcase.lset))
+
+			lset, n, err := DecodeLabels(b)
+			testutil.Ok(t, err)
+			testutil.Equals(t, len(b), n)
+			testutil.Equals(t, len(tcase.lset), len(lset))
+			for i := range lset {
+				testutil.Equals(t, tcase.lset[i], lset[i])
+			}
+		})
+	}
+}
+
+func TestDecodeLabels_Concatenated(t *testing.T) {
+	a := []Label{{Name: "a", Value: "1"}, {Name: "b", Value: ""}}
+	b := []Label{{Name: "c", Value: "3"}}
+
+	buf := AppendLabels(AppendLabels(nil, a), b)
+
+	lset, n, err := DecodeLabels(buf)
+	testutil.Ok(t, err)
+	testutil.Equals(t, a, lset)
+
+	lset, m, err := DecodeLabels(buf[n:])
+	testutil.Ok(t, err)
+	testutil.Equals(t, b, lset)
+	testutil.Equals(t, len(buf), n+m)
+}
+
+func TestDecodeLabels_Corrupted(t *testing.T) {
+	b := AppendLabels(nil, []Label{{Name: "name", Value: "value"}})
+
+	_, _, err := DecodeLabels(nil)
+	testutil.NotOk(t, err)
+
+	for i := 1; i < len(b); i++ {
+		_, _, err := DecodeLabels(b[:i])
+		testutil.NotOk(t, err, "truncated to %d bytes", i)
+	}
+}
+
+func BenchmarkAppendLabelsVSProtoMarshal(b *testing.B) {
+	lset := make([]Label, 0, 20)
+	for i := 0; i < 20; i++ {
+		lset = append(lset, Label{Name: fmt.Sprintf("label_name_%02d", i), Value: fmt.Sprintf("some_label_value_%d", i)})
+	}
+
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf = AppendLabels(buf[:0], lset)
+		}
+	})
+	b.Run("proto", func(b *testing.B) {
+		b.ReportAllocs()
+		ls := LabelSet{Labels: lset}
+		for i := 0; i < b.N; i++ {
+			_, err := ls.Marshal()
+			testutil.Ok(b, err)
+		}
+	})
+
+	enc := AppendLabels(nil, lset)
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _, err := DecodeLabels(enc)
+			testutil.Ok(b, err)
+		}
+	})
+	pb, err := (&LabelSet{Labels: lset}).Marshal()
+	testutil.Ok(b, err)
+	b.Run("proto-unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var ls LabelSet
+			testutil.Ok(b, ls.Unmarshal(pb))
+		}
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// NewExcludingSeriesSet returns a series set without the series matching all of the given matchers, e.g. to hide
+// known bad series from query results. An empty list of matchers excludes nothing.
+func NewExcludingSeriesSet(s SeriesSet, exclude []*labels.Matcher) SeriesSet {
+	if len(exclude) == 0 {
+		return s
+	}
+	return &excludingSeriesSet{SeriesSet: s, exclude: newFastMatchers(exclude)}
+}
+
+type excludingSeriesSet struct {
+	SeriesSet
+
+	exclude []fastMatcher
+}
+
+func (s *excludingSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		lset, _ := s.SeriesSet.At()
+		if !labelsMatch(lset, s.exclude) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMatchingSeriesSet returns a series set with only the series matching all of the given matchers, e.g. to
+// filter series of a store which does not support matchers itself. Common regular expressions, like alternations
+// of literals or .*literal.*, are evaluated without the regexp engine. An empty list of matchers keeps all series.
+func NewMatchingSeriesSet(s SeriesSet, matchers []*labels.Matcher) SeriesSet {
+	if len(matchers) == 0 {
+		return s
+	}
+	return &matchingSeriesSet{SeriesSet: s, matchers: newFastMatchers(matchers)}
+}
+
+type matchingSeriesSet struct {
+	SeriesSet
+
+	matchers []fastMatcher
+}
+
+func (s *matchingSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		lset, _ := s.SeriesSet.At()
+		if labelsMatch(lset, s.matchers) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractLabelValues returns the sorted unique values of the label with the given name of all series matching all
+// of the given matchers, e.g. to answer label values requests from series. Series without the label or with an
+// already collected value are skipped before matching. No chunk data is decoded.
+func ExtractLabelValues(s SeriesSet, name string, matchers []*labels.Matcher) ([]string, error) {
+	var (
+		ms     = newFastMatchers(matchers)
+		values = map[string]struct{}{}
+	)
+	for s.Next() {
+		lset, _ := s.At()
+		v := labelValue(lset, name)
+		if v == "" {
+			continue
+		}
+		if _, ok := values[v]; ok {
+			continue
+		}
+		if labelsMatch(lset, ms) {
+			values[v] = struct{}{}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(values))
+	for v := range values {
+		ret = append(ret, v)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// labelsMatch returns true if the label set matches all matchers. As in Prometheus, missing labels are matched
+// as empty values.
+func labelsMatch(lset []Label, ms []fastMatcher) bool {
+	for _, m := range ms {
+		if !m.matches(labelValue(lset, m.name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// labelValue returns the value of the label with the given name or an empty string if it does not exist.
+func labelValue(lset []Label, name string) string {
+	for _, l := range lset {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// NewLabelsOnlySeriesSet returns a series set without chunks, e.g. to serve series requests with SkipChunks.
+// As the chunks are dropped, consecutive series with equal labels are returned only once.
+func NewLabelsOnlySeriesSet(s SeriesSet) SeriesSet {
+	return &labelsOnlySeriesSet{SeriesSet: s}
+}
+
+type labelsOnlySeriesSet struct {
+	SeriesSet
+
+	lset []Label
+	init bool
+}
+
+func (s *labelsOnlySeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		lset, _ := s.SeriesSet.At()
+		if s.init && CompareLabels(s.lset, lset) == 0 {
+			continue
+		}
+		s.lset, s.init = lset, true
+		return true
+	}
+	return false
+}
+
+func (s *labelsOnlySeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, nil
+}
+
+// NewChunkTransformSeriesSet returns a series set with every chunk replaced by the result of fn, e.g. to filter
+// chunks by encoding or drop downsampled chunks. fn returns the new chunk and whether to keep it. Series left
+// without any chunk are skipped, series without chunks in the wrapped set are kept. The first error returned by fn
+// fails the set.
+func NewChunkTransformSeriesSet(s SeriesSet, fn func(lset []Label, c AggrChunk) (AggrChunk, bool, error)) SeriesSet {
+	return &chunkTransformSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, fn: fn}
+}
+
+type chunkTransformSeriesSet struct {
+	erroringSeriesSet
+
+	fn     func([]Label, AggrChunk) (AggrChunk, bool, error)
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *chunkTransformSeriesSet) Next() bool {
+	for s.err == nil && s.SeriesSet.Next() {
+		lset, chks := s.SeriesSet.At()
+
+		ret := make([]AggrChunk, 0, len(chks))
+		for i, c := range chks {
+			c, keep, err := s.fn(lset, c)
+			if err != nil {
+				s.err = errors.Wrapf(err, "series %s: transform chunk %d", LabelsToString(lset), i)
+				return false
+			}
+			if keep {
+				ret = append(ret, c)
+			}
+		}
+		if len(ret) == 0 && len(chks) > 0 {
+			continue
+		}
+		s.lset, s.chunks = lset, ret
+		return true
+	}
+	return false
+}
+
+func (s *chunkTransformSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
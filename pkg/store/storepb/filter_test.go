@@ -0,0 +1,290 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestExcludingSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "job", "noisy"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "job", "noisy"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3", "job", "ok"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+	}
+
+	for _, tcase := range []struct {
+		desc     string
+		exclude  []*labels.Matcher
+		expected []rawSeries
+	}{
+		{
+			desc:     "no matchers",
+			expected: in,
+		},
+		{
+			desc:     "matchers match nothing",
+			exclude:  []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "other")},
+			expected: in,
+		},
+		{
+			desc:     "matchers match everything",
+			exclude:  []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "a", ".+")},
+			expected: nil,
+		},
+		{
+			desc: "all matchers have to match",
+			exclude: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "job", "noisy"),
+				labels.MustNewMatcher(labels.MatchNotEqual, "a", "1"),
+			},
+			expected: []rawSeries{in[0], in[2]},
+		},
+		{
+			desc:     "missing label matches empty value",
+			exclude:  []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "missing", "")},
+			expected: nil,
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			s := NewExcludingSeriesSet(newListSeriesSet(t, in), tcase.exclude)
+			seriesEquals(t, tcase.expected, s)
+			testutil.Ok(t, s.Err())
+		})
+	}
+}
+
+func TestLabelsOnlySeriesSet(t *testing.T) {
+	s := NewLabelsOnlySeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}))
+
+	var got []labels.Labels
+	for s.Next() {
+		lset, chks := s.At()
+		testutil.Equals(t, 0, len(chks))
+		got = append(got, LabelsToPromLabels(lset))
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2"),
+		labels.FromStrings("a", "3"),
+	}, got)
+}
+
+func TestChunkTransformSeriesSet(t *testing.T) {
+	newSet := func() *listSeriesSet {
+		l := newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 1}}, {{5, 5}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2"),
+				chunks: [][]sample{{{1, 1}}},
+			},
+			{
+				lset: labels.FromStrings("a", "3"),
+			},
+		})
+		// The second chunk of the first series and the only chunk of the second one are downsampled.
+		raw := l.series[0].Chunks[1].Raw
+		l.series[0].Chunks[1] = AggrChunk{MinTime: 5, MaxTime: 5, Count: raw, Sum: raw}
+		raw = l.series[1].Chunks[0].Raw
+		l.series[1].Chunks[0] = AggrChunk{MinTime: 1, MaxTime: 1, Count: raw, Sum: raw}
+		return l
+	}
+
+	s := NewChunkTransformSeriesSet(newSet(), func(_ []Label, c AggrChunk) (AggrChunk, bool, error) {
+		return c, c.Raw != nil, nil
+	})
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset: labels.FromStrings("a", "3"),
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+
+	// Chunks can be modified.
+	s = NewChunkTransformSeriesSet(newSet(), func(_ []Label, c AggrChunk) (AggrChunk, bool, error) {
+		c.MaxTime = 100
+		return c, true, nil
+	})
+	testutil.Assert(t, s.Next(), "expected series")
+	_, chks := s.At()
+	testutil.Equals(t, []AggrChunk{{MinTime: 1, MaxTime: 100}, {MinTime: 5, MaxTime: 100}}, chunkTimes(chks))
+
+	s = NewChunkTransformSeriesSet(newSet(), func(lset []Label, c AggrChunk) (AggrChunk, bool, error) {
+		if lset[0].Value == "2" {
+			return c, false, errors.New("test")
+		}
+		return c, true, nil
+	})
+	testutil.Assert(t, s.Next(), "expected series")
+	testutil.Assert(t, !s.Next(), "expected failure")
+	testutil.NotOk(t, s.Err())
+}
+
+func TestMatchingSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "job", "db-primary"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3", "job", "web"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}
+
+	for _, tcase := range []struct {
+		desc     string
+		matchers []*labels.Matcher
+		expected []rawSeries
+	}{
+		{
+			desc:     "no matchers",
+			expected: in,
+		},
+		{
+			desc:     "alternation",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "job", "api|web")},
+			expected: []rawSeries{in[0], in[2]},
+		},
+		{
+			desc: "contains and not equal",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchRegexp, "job", ".*b.*"),
+				labels.MustNewMatcher(labels.MatchNotEqual, "a", "3"),
+			},
+			expected: []rawSeries{in[1]},
+		},
+		{
+			desc:     "negative regexp",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotRegexp, "job", "[a-c].*")},
+			expected: []rawSeries{in[1], in[2]},
+		},
+		{
+			desc:     "missing label",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "zone", ".+")},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			s := NewMatchingSeriesSet(newListSeriesSet(t, in), tcase.matchers)
+			seriesEquals(t, tcase.expected, s)
+			testutil.Ok(t, s.Err())
+		})
+	}
+}
+
+func TestExtractLabelValues(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("instance", "b", "job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "a", "job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "c", "job", "db"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "a", "job", "db"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}
+
+	for _, tcase := range []struct {
+		desc     string
+		name     string
+		matchers []*labels.Matcher
+		expected []string
+	}{
+		{
+			desc:     "no matchers",
+			name:     "instance",
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			desc:     "matchers excluding series",
+			name:     "instance",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "db")},
+			expected: []string{"a", "c"},
+		},
+		{
+			desc:     "regex matcher",
+			name:     "job",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "instance", "b|c")},
+			expected: []string{"api", "db"},
+		},
+		{
+			desc:     "no matching series",
+			name:     "instance",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "web")},
+			expected: []string{},
+		},
+		{
+			desc:     "unknown label",
+			name:     "zone",
+			expected: []string{},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			values, err := ExtractLabelValues(newListSeriesSet(t, in), tcase.name, tcase.matchers)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, values)
+		})
+	}
+
+	_, err := ExtractLabelValues(errSeriesSet{err: errors.New("test")}, "instance", nil)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"github.com/pkg/errors"
+)
+
+// NewGapMarkingSeriesSet returns a series set which inserts a gap marker chunk between two consecutive raw chunks
+// of a series if the time between the end of the first and the start of the second exceeds maxGap, e.g. so that a
+// graphing frontend can render discontinuities explicitly. Chunks are expected to be sorted by MinTime, chunks
+// which overlap or are not raw never get a marker in between. The chunks are copied only if markers are inserted,
+// so the wrapped set is not modified.
+//
+// A gap marker is an AggrChunk without any chunk data, spanning from the MaxTime of the chunk before the gap to the
+// MinTime of the chunk after it, see IsGapMarker. Markers are a rendering aid only: they carry no samples and fail
+// AggrChunk.Validate, so the result must not be passed on to anything decoding chunks.
+func NewGapMarkingSeriesSet(s SeriesSet, maxGap int64) (SeriesSet, error) {
+	if maxGap < 0 {
+		return nil, errors.Errorf("invalid max gap %d", maxGap)
+	}
+	return &gapMarkingSeriesSet{SeriesSet: s, maxGap: maxGap}, nil
+}
+
+// IsGapMarker returns whether the chunk is a gap marker inserted by a series set returned by
+// NewGapMarkingSeriesSet.
+func IsGapMarker(c AggrChunk) bool {
+	for _, aggr := range allAggrs {
+		if _, ok := c.Get(aggr); ok {
+			return false
+		}
+	}
+	return true
+}
+
+type gapMarkingSeriesSet struct {
+	SeriesSet
+
+	maxGap int64
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *gapMarkingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.lset, s.chunks = s.SeriesSet.At()
+
+	var ret []AggrChunk
+	for i := 1; i < len(s.chunks); i++ {
+		prev, next := s.chunks[i-1], s.chunks[i]
+		if prev.Raw == nil || next.Raw == nil || next.MinTime-prev.MaxTime <= s.maxGap {
+			if ret != nil {
+				ret = append(ret, next)
+			}
+			continue
+		}
+		if ret == nil {
+			ret = append(make([]AggrChunk, 0, len(s.chunks)+1), s.chunks[:i]...)
+		}
+		ret = append(ret, AggrChunk{MinTime: prev.MaxTime, MaxTime: next.MinTime}, next)
+	}
+	if ret != nil {
+		s.chunks = ret
+	}
+	return true
+}
+
+func (s *gapMarkingSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
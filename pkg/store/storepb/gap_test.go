@@ -0,0 +1,103 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestGapMarkingSeriesSet(t *testing.T) {
+	// Gaps of 10 between the first two and 20 between the last two chunks.
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}, {5, 2}}, {{15, 1}, {20, 2}}, {{40, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}, {100, 2}}},
+		},
+	}
+
+	for _, tcase := range []struct {
+		maxGap   int64
+		expected []AggrChunk
+		markers  []int
+	}{
+		{
+			maxGap:   0,
+			expected: []AggrChunk{{MinTime: 1, MaxTime: 5}, {MinTime: 5, MaxTime: 15}, {MinTime: 15, MaxTime: 20}, {MinTime: 20, MaxTime: 40}, {MinTime: 40, MaxTime: 40}},
+			markers:  []int{1, 3},
+		},
+		{
+			maxGap:   9,
+			expected: []AggrChunk{{MinTime: 1, MaxTime: 5}, {MinTime: 5, MaxTime: 15}, {MinTime: 15, MaxTime: 20}, {MinTime: 20, MaxTime: 40}, {MinTime: 40, MaxTime: 40}},
+			markers:  []int{1, 3},
+		},
+		{
+			maxGap:   10,
+			expected: []AggrChunk{{MinTime: 1, MaxTime: 5}, {MinTime: 15, MaxTime: 20}, {MinTime: 20, MaxTime: 40}, {MinTime: 40, MaxTime: 40}},
+			markers:  []int{2},
+		},
+		{
+			maxGap:   19,
+			expected: []AggrChunk{{MinTime: 1, MaxTime: 5}, {MinTime: 15, MaxTime: 20}, {MinTime: 20, MaxTime: 40}, {MinTime: 40, MaxTime: 40}},
+			markers:  []int{2},
+		},
+		{
+			maxGap:   20,
+			expected: []AggrChunk{{MinTime: 1, MaxTime: 5}, {MinTime: 15, MaxTime: 20}, {MinTime: 40, MaxTime: 40}},
+		},
+	} {
+		t.Run(fmt.Sprintf("max gap %d", tcase.maxGap), func(t *testing.T) {
+			l := newListSeriesSet(t, in)
+			s, err := NewGapMarkingSeriesSet(l, tcase.maxGap)
+			testutil.Ok(t, err)
+
+			testutil.Assert(t, s.Next(), "expected first series")
+			_, chks := s.At()
+			testutil.Equals(t, tcase.expected, chunkTimes(chks))
+
+			var markers []int
+			for i, c := range chks {
+				if IsGapMarker(c) {
+					markers = append(markers, i)
+				}
+			}
+			testutil.Equals(t, tcase.markers, markers)
+			// The wrapped set is not modified.
+			testutil.Equals(t, 3, len(l.series[0].Chunks))
+
+			testutil.Assert(t, s.Next(), "expected second series")
+			_, chks = s.At()
+			testutil.Equals(t, []AggrChunk{{MinTime: 1, MaxTime: 100}}, chunkTimes(chks))
+			testutil.Assert(t, !IsGapMarker(chks[0]), "expected no gap marker")
+
+			testutil.Assert(t, !s.Next(), "expected end of series set")
+			testutil.Ok(t, s.Err())
+		})
+	}
+
+	_, err := NewGapMarkingSeriesSet(EmptySeriesSet(), -1)
+	testutil.NotOk(t, err)
+}
+
+func TestGapMarkingSeriesSet_NonRaw(t *testing.T) {
+	s, err := NewGapMarkingSeriesSet(&listSeriesSet{idx: -1, series: []Series{{
+		Labels: []Label{{Name: "a", Value: "1"}},
+		Chunks: []AggrChunk{
+			{MinTime: 0, MaxTime: 10, Count: &Chunk{}},
+			{MinTime: 100, MaxTime: 200, Raw: &Chunk{}},
+		},
+	}}}, 1)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, s.Next(), "expected series")
+	_, chks := s.At()
+	testutil.Equals(t, []AggrChunk{{MinTime: 0, MaxTime: 10}, {MinTime: 100, MaxTime: 200}}, chunkTimes(chks))
+}
@@ -0,0 +1,61 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GoldenString returns a deterministic, human readable representation of the response, e.g. for golden file tests.
+// Unlike String, its output does not depend on the proto text format, so it is stable across releases.
+//
+// Series are shown as their labels sorted by name, followed by one line per chunk in the form
+// "<mint>-<maxt>:<encoding>:<hex data>", prefixed with the aggregate type for aggregated chunks. Label values and
+// warnings are quoted with non-ASCII characters escaped.
+func (m *SeriesResponse) GoldenString() string {
+	var b strings.Builder
+
+	switch r := m.Result.(type) {
+	case *SeriesResponse_Series:
+		writeGoldenLabels(&b, r.Series.Labels)
+		for _, c := range r.Series.Chunks {
+			for _, aggr := range allAggrs {
+				chk, ok := c.Get(aggr)
+				if !ok {
+					continue
+				}
+				b.WriteString("\n\t")
+				if aggr != Aggr_RAW {
+					b.WriteString(aggr.String())
+					b.WriteByte(' ')
+				}
+				fmt.Fprintf(&b, "%d-%d:%s:%s", c.MinTime, c.MaxTime, chk.Type, hex.EncodeToString(chk.Data))
+			}
+		}
+	case *SeriesResponse_Warning:
+		b.WriteString("warning: ")
+		b.WriteString(strconv.QuoteToASCII(r.Warning))
+	case *SeriesResponse_Hints:
+		fmt.Fprintf(&b, "hints: %s:%s", strconv.QuoteToASCII(r.Hints.GetTypeUrl()), hex.EncodeToString(r.Hints.GetValue()))
+	default:
+		fmt.Fprintf(&b, "unknown: %T", r)
+	}
+	return b.String()
+}
+
+func writeGoldenLabels(b *strings.Builder, lset []Label) {
+	b.WriteByte('{')
+	for i, l := range sortedLabels(lset) {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(strconv.QuoteToASCII(l.Value))
+	}
+	b.WriteByte('}')
+}
@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "update the golden files of the tests")
+
+func TestSeriesResponse_GoldenString(t *testing.T) {
+	for _, tcase := range []struct {
+		r        *SeriesResponse
+		expected string
+	}{
+		{
+			r: NewSeriesResponse(&Series{
+				Labels: []Label{{Name: "b", Value: "ä"}, {Name: "a", Value: `"1"`}},
+				Chunks: []AggrChunk{
+					{MinTime: 1, MaxTime: 2, Raw: &Chunk{Type: Chunk_XOR, Data: []byte{0x01, 0xab}}},
+					{MinTime: 3, MaxTime: 4, Count: &Chunk{Type: Chunk_XOR, Data: []byte{0x02}}, Sum: &Chunk{Type: Chunk_XOR}},
+				},
+			}),
+			expected: `{a="\"1\"", b="\u00e4"}
+	1-2:XOR:01ab
+	COUNT 3-4:XOR:02
+	SUM 3-4:XOR:`,
+		},
+		{
+			r:        NewSeriesResponse(&Series{}),
+			expected: "{}",
+		},
+		{
+			r:        NewWarnSeriesResponse(errors.New("partial\nresponse")),
+			expected: `warning: "partial\nresponse"`,
+		},
+		{
+			r:        NewHintsSeriesResponse(&types.Any{TypeUrl: "hint", Value: []byte{0xff}}),
+			expected: `hints: "hint":ff`,
+		},
+	} {
+		testutil.Equals(t, tcase.expected, tcase.r.GoldenString())
+	}
+}
+
+func TestMergeSeriesSets_Golden(t *testing.T) {
+	s := MergeSeriesSets(
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{5, 5}, {6, 6}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2"),
+				chunks: [][]sample{{{1, 1}}},
+			},
+		}),
+		newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{3, 3}, {4, 4}}, {{5, 5}, {6, 6}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "1", "b", "1"),
+				chunks: [][]sample{{{1, 1}}},
+			},
+		}),
+	)
+
+	var lines []string
+	for s.Next() {
+		lset, chks := s.At()
+		lines = append(lines, NewSeriesResponse(&Series{Labels: lset, Chunks: chks}).GoldenString())
+	}
+	testutil.Ok(t, s.Err())
+	got := strings.Join(lines, "\n") + "\n"
+
+	golden := filepath.Join("testdata", "merge.golden")
+	if *updateGolden {
+		testutil.Ok(t, ioutil.WriteFile(golden, []byte(got), 0666))
+	}
+	expected, err := ioutil.ReadFile(golden)
+	testutil.Ok(t, err)
+	testutil.Equals(t, string(expected), got)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import "sort"
+
+// LabelSetIndex is a set of label sets with constant time membership checks, e.g. to filter series against a
+// fixed allowlist. It is safe for concurrent reads.
+type LabelSetIndex struct {
+	buckets map[uint64][][]Label
+}
+
+// NewLabelSetIndex returns an index of the given label sets. Labels do not have to be sorted by name.
+func NewLabelSetIndex(lsets []LabelSet) *LabelSetIndex {
+	idx := &LabelSetIndex{buckets: make(map[uint64][][]Label, len(lsets))}
+	for _, ls := range lsets {
+		lset := sortedLabels(ls.Labels)
+		if idx.Contains(lset) {
+			continue
+		}
+		h := HashLabels(lset)
+		idx.buckets[h] = append(idx.buckets[h], lset)
+	}
+	return idx
+}
+
+// Contains returns true if the index contains the given label set, regardless of its label order.
+func (idx *LabelSetIndex) Contains(lset []Label) bool {
+	lset = sortedLabels(lset)
+	for _, l := range idx.buckets[HashLabels(lset)] {
+		if CompareLabels(l, lset) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of distinct label sets in the index.
+func (idx *LabelSetIndex) Len() int {
+	n := 0
+	for _, b := range idx.buckets {
+		n += len(b)
+	}
+	return n
+}
+
+// LabelValueTrie is a prefix tree of the values of a label, e.g. to autocomplete label values. It is safe for
+// concurrent reads.
+type LabelValueTrie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	// children are sorted by their byte, so that values are found in sorted order.
+	children []trieChild
+	// value is true if the path to the node is a value.
+	value bool
+}
+
+type trieChild struct {
+	b    byte
+	node *trieNode
+}
+
+// NewLabelValueTrie drains s and returns a trie of the values of the label with the given name of all series,
+// skipping series without the label. No chunk data is decoded. The set is consumed, so it cannot be used afterwards.
+func NewLabelValueTrie(s SeriesSet, name string) (*LabelValueTrie, error) {
+	t := &LabelValueTrie{}
+	for s.Next() {
+		lset, _ := s.At()
+		if v := labelValue(lset, name); v != "" {
+			t.insert(v)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *LabelValueTrie) insert(v string) {
+	n := &t.root
+	for i := 0; i < len(v); i++ {
+		j := sort.Search(len(n.children), func(j int) bool { return n.children[j].b >= v[i] })
+		if j == len(n.children) || n.children[j].b != v[i] {
+			n.children = append(n.children, trieChild{})
+			copy(n.children[j+1:], n.children[j:])
+			n.children[j] = trieChild{b: v[i], node: &trieNode{}}
+		}
+		n = n.children[j].node
+	}
+	n.value = true
+}
+
+// PrefixSearch returns the sorted values starting with prefix, at most limit of them. 0 disables the limit.
+func (t *LabelValueTrie) PrefixSearch(prefix string, limit int) []string {
+	n := &t.root
+	for i := 0; i < len(prefix); i++ {
+		j := sort.Search(len(n.children), func(j int) bool { return n.children[j].b >= prefix[i] })
+		if j == len(n.children) || n.children[j].b != prefix[i] {
+			return nil
+		}
+		n = n.children[j].node
+	}
+
+	var ret []string
+	n.collect([]byte(prefix), limit, &ret)
+	return ret
+}
+
+// collect appends the values below the node with the given path to ret in sorted order, until ret has limit values.
+// It returns false once the limit is reached.
+func (n *trieNode) collect(path []byte, limit int, ret *[]string) bool {
+	if n.value {
+		*ret = append(*ret, string(path))
+		if limit > 0 && len(*ret) >= limit {
+			return false
+		}
+	}
+	for _, c := range n.children {
+		if !c.node.collect(append(path, c.b), limit, ret) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestLabelSetIndex(t *testing.T) {
+	idx := NewLabelSetIndex([]LabelSet{
+		{Labels: []Label{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}},
+		{Labels: []Label{{Name: "b", Value: "2"}, {Name: "a", Value: "1"}}},
+		{Labels: []Label{{Name: "a", Value: "1"}}},
+		{Labels: []Label{{Name: "z", Value: ""}, {Name: "c", Value: "3"}}},
+		{},
+	})
+	testutil.Equals(t, 4, idx.Len())
+
+	for _, tcase := range []struct {
+		lset     []Label
+		expected bool
+	}{
+		{lset: []Label{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, expected: true},
+		{lset: []Label{{Name: "b", Value: "2"}, {Name: "a", Value: "1"}}, expected: true},
+		{lset: []Label{{Name: "a", Value: "1"}}, expected: true},
+		{lset: []Label{{Name: "c", Value: "3"}, {Name: "z", Value: ""}}, expected: true},
+		{lset: nil, expected: true},
+		{lset: []Label{{Name: "a", Value: "2"}}, expected: false},
+		{lset: []Label{{Name: "b", Value: "2"}}, expected: false},
+		{lset: []Label{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "c", Value: "3"}}, expected: false},
+		{lset: []Label{{Name: "c", Value: "3"}}, expected: false},
+	} {
+		t.Run(LabelsToString(tcase.lset), func(t *testing.T) {
+			testutil.Equals(t, tcase.expected, idx.Contains(tcase.lset))
+		})
+	}
+}
+
+func BenchmarkLabelSetIndexVSLinearScan(b *testing.B) {
+	lsets := make([]LabelSet, 0, 10000)
+	for i := 0; i < cap(lsets); i++ {
+		lsets = append(lsets, LabelSet{Labels: []Label{
+			{Name: "__name__", Value: "http_requests_total"},
+			{Name: "instance", Value: fmt.Sprintf("instance-%d", i)},
+			{Name: "job", Value: "api"},
+		}})
+	}
+	lookup := lsets[len(lsets)/2].Labels
+
+	b.Run("index", func(b *testing.B) {
+		idx := NewLabelSetIndex(lsets)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			testutil.Assert(b, idx.Contains(lookup), "expected label set to be found")
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			found := false
+			for _, ls := range lsets {
+				if CompareLabels(ls.Labels, lookup) == 0 {
+					found = true
+					break
+				}
+			}
+			testutil.Assert(b, found, "expected label set to be found")
+		}
+	})
+}
+
+func TestLabelValueTrie(t *testing.T) {
+	var in []rawSeries
+	for _, v := range []string{"api", "api-v2", "apiserver", "db", "", "ap", "api"} {
+		in = append(in, rawSeries{lset: labels.FromStrings("a", "1", "job", v)})
+	}
+	in = append(in, rawSeries{lset: labels.FromStrings("a", "2")})
+
+	trie, err := NewLabelValueTrie(newListSeriesSet(t, in), "job")
+	testutil.Ok(t, err)
+
+	for _, tcase := range []struct {
+		prefix   string
+		limit    int
+		expected []string
+	}{
+		{prefix: "", expected: []string{"ap", "api", "api-v2", "apiserver", "db"}},
+		{prefix: "api", expected: []string{"api", "api-v2", "apiserver"}},
+		{prefix: "api", limit: 2, expected: []string{"api", "api-v2"}},
+		{prefix: "api-", expected: []string{"api-v2"}},
+		{prefix: "d", limit: 10, expected: []string{"db"}},
+		{prefix: "apis", limit: 1, expected: []string{"apiserver"}},
+		{prefix: "x"},
+		{prefix: "dbx"},
+		{prefix: "apix"},
+	} {
+		t.Run(fmt.Sprintf("prefix %q limit %d", tcase.prefix, tcase.limit), func(t *testing.T) {
+			testutil.Equals(t, tcase.expected, trie.PrefixSearch(tcase.prefix, tcase.limit))
+		})
+	}
+
+	_, err = NewLabelValueTrie(errSeriesSet{err: fmt.Errorf("failure")}, "job")
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+// SampleIterator returns an iterator over the samples of all raw chunks of the series, in chunk order.
+// Chunks are decoded lazily one at a time, so memory stays bounded to a single chunk regardless of the number
+// of chunks in the series. It errors if any of the chunks is not a raw chunk.
+func (m *Series) SampleIterator() (chunkenc.Iterator, error) {
+	for i, c := range m.Chunks {
+		if c.Raw == nil {
+			return nil, errors.Errorf("chunk %d is not a raw chunk", i)
+		}
+	}
+	return &chunksSampleIterator{chunks: m.Chunks}, nil
+}
+
+type chunksSampleIterator struct {
+	chunks []AggrChunk
+	i      int
+	curr   chunkenc.Iterator
+	err    error
+}
+
+func (it *chunksSampleIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		if it.curr != nil {
+			if it.curr.Next() {
+				return true
+			}
+			if err := it.curr.Err(); err != nil {
+				it.err = errors.Wrapf(err, "decode samples of chunk %d", it.i-1)
+				return false
+			}
+		}
+		if it.i >= len(it.chunks) {
+			return false
+		}
+
+		c, err := it.chunks[it.i].Raw.Decode()
+		if err != nil {
+			it.err = errors.Wrapf(err, "chunk %d", it.i)
+			return false
+		}
+		it.curr = c.Iterator(it.curr)
+		it.i++
+	}
+}
+
+func (it *chunksSampleIterator) At() (int64, float64) {
+	return it.curr.At()
+}
+
+func (it *chunksSampleIterator) Err() error {
+	return it.err
+}
+
+// ToChunkMetas converts the raw chunks of the series to Prometheus chunk metas, e.g. to write StoreAPI output into
+// a TSDB block. The metas hold the chunk data instead of a reference, as references are only assigned once the
+// chunks are written. It errors if any of the chunks is not a raw chunk.
+func (m *Series) ToChunkMetas() ([]chunks.Meta, error) {
+	ret := make([]chunks.Meta, 0, len(m.Chunks))
+	for i, c := range m.Chunks {
+		if c.Raw == nil {
+			return nil, errors.Errorf("chunk %d is not a raw chunk", i)
+		}
+		chk, err := c.Raw.Decode()
+		if err != nil {
+			return nil, errors.Wrapf(err, "chunk %d", i)
+		}
+		ret = append(ret, chunks.Meta{Chunk: chk, MinTime: c.MinTime, MaxTime: c.MaxTime})
+	}
+	return ret, nil
+}
+
+// ToWALRecords decodes the raw chunks of the series into Prometheus WAL sample records, e.g. to replay StoreAPI
+// output into a TSDB head. All records share HashLabels of the series labels as ref, so the ref of a label set is
+// stable across calls and processes. It errors if any of the chunks is not a raw chunk.
+func (m *Series) ToWALRecords() ([]record.RefSample, error) {
+	it, err := m.SampleIterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ref = HashLabels(m.Labels)
+		ret []record.RefSample
+	)
+	for it.Next() {
+		t, v := it.At()
+		ret = append(ret, record.RefSample{Ref: ref, T: t, V: v})
+	}
+	return ret, it.Err()
+}
+
+// StreamSamples decodes the raw chunks of the series in a new goroutine and sends their samples in order on the
+// returned sample channel, e.g. for pipeline-style processing without holding all samples in memory. Both channels
+// are closed once all samples are sent, decoding fails or ctx is canceled. The error channel receives at most one
+// error, which is ctx.Err() on cancellation, so callers should drain the samples and then receive from it, getting
+// nil if all samples were sent. It fails if any of the chunks is not a raw chunk.
+func (m *Series) StreamSamples(ctx context.Context) (<-chan Sample, <-chan error) {
+	var (
+		samples = make(chan Sample)
+		errs    = make(chan error, 1)
+	)
+	go func() {
+		defer close(errs)
+		defer close(samples)
+
+		it, err := m.SampleIterator()
+		if err != nil {
+			errs <- err
+			return
+		}
+		for it.Next() {
+			// Check first, as select picks randomly if the receiver is ready as well.
+			if err := ctx.Err(); err != nil {
+				errs <- err
+				return
+			}
+			t, v := it.At()
+			select {
+			case samples <- Sample{T: t, V: v}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+		if err := it.Err(); err != nil {
+			errs <- err
+		}
+	}()
+	return samples, errs
+}
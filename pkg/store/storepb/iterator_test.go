@@ -0,0 +1,180 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSeries_SampleIterator(t *testing.T) {
+	for _, tcase := range []struct {
+		desc   string
+		chunks [][]sample
+	}{
+		{
+			desc: "no chunks",
+		},
+		{
+			desc:   "single chunk",
+			chunks: [][]sample{{{1, 1}, {2, 2}, {3, 3}}},
+		},
+		{
+			desc:   "multiple chunks",
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{4, 4}, {5, 5}, {6, 6}}},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			s := newSeries(t, labels.FromStrings("a", "1"), tcase.chunks)
+
+			it, err := s.SampleIterator()
+			testutil.Ok(t, err)
+
+			var expected, got []sample
+			for _, c := range tcase.chunks {
+				expected = append(expected, c...)
+			}
+			for it.Next() {
+				ts, v := it.At()
+				got = append(got, sample{t: ts, v: v})
+			}
+			testutil.Ok(t, it.Err())
+			testutil.Equals(t, expected, got)
+			testutil.Assert(t, !it.Next(), "expected exhausted iterator")
+		})
+	}
+}
+
+func TestSeries_SampleIterator_EmptyChunks(t *testing.T) {
+	s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}}, {{2, 2}}})
+	empty := AggrChunk{Raw: &Chunk{Type: Chunk_XOR, Data: chunkenc.NewXORChunk().Bytes()}}
+	s.Chunks = []AggrChunk{empty, s.Chunks[0], empty, empty, s.Chunks[1], empty}
+
+	it, err := s.SampleIterator()
+	testutil.Ok(t, err)
+
+	var got []sample
+	for it.Next() {
+		ts, v := it.At()
+		got = append(got, sample{t: ts, v: v})
+	}
+	testutil.Ok(t, it.Err())
+	testutil.Equals(t, []sample{{1, 1}, {2, 2}}, got)
+}
+
+func TestSeries_SampleIterator_Errors(t *testing.T) {
+	s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}}})
+	s.Chunks = append(s.Chunks, AggrChunk{MinTime: 2, MaxTime: 2, Sum: s.Chunks[0].Raw})
+	_, err := s.SampleIterator()
+	testutil.NotOk(t, err)
+
+	s = newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}}})
+	s.Chunks = append(s.Chunks, AggrChunk{MinTime: 2, MaxTime: 2, Raw: &Chunk{Type: Chunk_XOR, Data: []byte{0}}})
+	it, err := s.SampleIterator()
+	testutil.Ok(t, err)
+
+	// Samples of the valid chunk are returned before the error of the corrupted one.
+	testutil.Assert(t, it.Next(), "expected first sample")
+	ts, v := it.At()
+	testutil.Equals(t, sample{t: 1, v: 1}, sample{t: ts, v: v})
+	testutil.Assert(t, !it.Next(), "expected error")
+	testutil.NotOk(t, it.Err())
+}
+
+func TestSeries_ToChunkMetas(t *testing.T) {
+	s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}, {2, 2}}, {{10, 3}, {15, 4}, {20, 5}}})
+
+	metas, err := s.ToChunkMetas()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(metas))
+	for i, m := range metas {
+		testutil.Equals(t, s.Chunks[i].MinTime, m.MinTime)
+		testutil.Equals(t, s.Chunks[i].MaxTime, m.MaxTime)
+		testutil.Equals(t, s.Chunks[i].Raw.Data, m.Chunk.Bytes())
+		testutil.Equals(t, uint64(0), m.Ref)
+	}
+	testutil.Equals(t, 3, metas[1].Chunk.NumSamples())
+
+	s.Chunks = append(s.Chunks, AggrChunk{MinTime: 30, MaxTime: 40, Count: s.Chunks[0].Raw})
+	_, err = s.ToChunkMetas()
+	testutil.NotOk(t, err)
+}
+
+func TestSeries_ToWALRecords(t *testing.T) {
+	s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}, {2, 2}}, {{10, 3}, {15, 4}, {20, 5}}})
+
+	recs, err := s.ToWALRecords()
+	testutil.Ok(t, err)
+
+	ref := HashLabels(s.Labels)
+	var expected []record.RefSample
+	for _, c := range s.Chunks {
+		samples, err := c.Raw.Samples()
+		testutil.Ok(t, err)
+		for _, smpl := range samples {
+			expected = append(expected, record.RefSample{Ref: ref, T: smpl.T, V: smpl.V})
+		}
+	}
+	testutil.Equals(t, expected, recs)
+
+	// The ref only depends on the labels.
+	other := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{100, 1}}})
+	recs, err = other.ToWALRecords()
+	testutil.Ok(t, err)
+	testutil.Equals(t, []record.RefSample{{Ref: ref, T: 100, V: 1}}, recs)
+
+	other = newSeries(t, labels.FromStrings("a", "2"), [][]sample{{{100, 1}}})
+	recs, err = other.ToWALRecords()
+	testutil.Ok(t, err)
+	testutil.Assert(t, recs[0].Ref != ref, "expected different ref for different labels")
+
+	s.Chunks = append(s.Chunks, AggrChunk{MinTime: 30, MaxTime: 40, Count: s.Chunks[0].Raw})
+	_, err = s.ToWALRecords()
+	testutil.NotOk(t, err)
+}
+
+func TestSeries_StreamSamples(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}, {2, 2}}, {{10, 3}, {15, 4}, {20, 5}}})
+
+	samples, errs := s.StreamSamples(context.Background())
+	var got []Sample
+	for smpl := range samples {
+		got = append(got, smpl)
+	}
+	testutil.Ok(t, <-errs)
+	testutil.Equals(t, []Sample{{T: 1, V: 1}, {T: 2, V: 2}, {T: 10, V: 3}, {T: 15, V: 4}, {T: 20, V: 5}}, got)
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		samples, errs := s.StreamSamples(ctx)
+		testutil.Equals(t, Sample{T: 1, V: 1}, <-samples)
+		cancel()
+
+		// At most the sample already being sent when canceled can still arrive.
+		n := 0
+		for range samples {
+			n++
+		}
+		testutil.Assert(t, n <= 1, "expected the stream to stop, got %d more samples", n)
+		testutil.Equals(t, context.Canceled, <-errs)
+	})
+	t.Run("aggregated chunk", func(t *testing.T) {
+		s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}}})
+		s.Chunks[0].Count, s.Chunks[0].Raw = s.Chunks[0].Raw, nil
+
+		samples, errs := s.StreamSamples(context.Background())
+		_, ok := <-samples
+		testutil.Assert(t, !ok, "expected no samples")
+		testutil.NotOk(t, <-errs)
+	})
+}
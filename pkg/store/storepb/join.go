@@ -0,0 +1,190 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JoinSeriesSet is a SeriesSet of joined series.
+type JoinSeriesSet interface {
+	SeriesSet
+	// AtSides returns the chunks of the current series split by the side of the join they originate from.
+	// At returns the same chunks concatenated, the left ones first.
+	AtSides() (left, right []AggrChunk)
+}
+
+// JoinSeriesSets performs an inner join of the two series sets on the values of the given labels, similar to PromQL
+// vector matching with on(). Missing labels are treated as empty values. Series without a match on the other side
+// are dropped.
+//
+// One-to-one matches result in a series with the labels of the left series. For many-to-one matches, every series
+// of the "many" side is joined with the single series of the other side and keeps its own labels, as with
+// group_left and group_right in PromQL. Many-to-many matches are an error.
+//
+// Both sets must be sorted by the values of the on labels, in the given order. Like NewPeekSeriesSet, the wrapped
+// sets must not reuse the returned label and chunk slices on Next().
+//
+// This is a terminal transform for analysis only: joined series are returned in the order of the on label values,
+// which is generally not the order of their label sets, which breaks the invariants of the merge and all other
+// series sets, so the result must not be passed on to them. Series are not buffered beyond a single join group.
+func JoinSeriesSets(left, right SeriesSet, on []string) JoinSeriesSet {
+	return &joinSeriesSet{
+		left:  newJoinSide(left, on, "left"),
+		right: newJoinSide(right, on, "right"),
+	}
+}
+
+type joinedSeries struct {
+	lset        []Label
+	left, right []AggrChunk
+}
+
+type joinSeriesSet struct {
+	left, right *joinSide
+
+	buf    []joinedSeries
+	curr   joinedSeries
+	chunks []AggrChunk
+	err    error
+}
+
+func (s *joinSeriesSet) Next() bool {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return false
+		}
+		lkey, ok := s.left.peekKey()
+		if !ok {
+			return false
+		}
+		rkey, ok := s.right.peekKey()
+		if !ok {
+			return false
+		}
+
+		switch c := compareKeys(lkey, rkey); {
+		case c < 0:
+			_, s.err = s.left.nextGroup()
+		case c > 0:
+			_, s.err = s.right.nextGroup()
+		default:
+			s.buf, s.err = s.join()
+		}
+	}
+
+	s.curr, s.buf = s.buf[0], s.buf[1:]
+	s.chunks = make([]AggrChunk, 0, len(s.curr.left)+len(s.curr.right))
+	s.chunks = append(append(s.chunks, s.curr.left...), s.curr.right...)
+	return true
+}
+
+func (s *joinSeriesSet) join() ([]joinedSeries, error) {
+	lgroup, err := s.left.nextGroup()
+	if err != nil {
+		return nil, err
+	}
+	rgroup, err := s.right.nextGroup()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lgroup) > 1 && len(rgroup) > 1 {
+		return nil, errors.Errorf("many-to-many matching not allowed: %d left and %d right series for %s",
+			len(lgroup), len(rgroup), LabelsToString(lgroup[0].Labels))
+	}
+
+	ret := make([]joinedSeries, 0, len(lgroup)+len(rgroup)-1)
+	if len(rgroup) > 1 {
+		for _, r := range rgroup {
+			ret = append(ret, joinedSeries{lset: r.Labels, left: lgroup[0].Chunks, right: r.Chunks})
+		}
+		return ret, nil
+	}
+	for _, l := range lgroup {
+		ret = append(ret, joinedSeries{lset: l.Labels, left: l.Chunks, right: rgroup[0].Chunks})
+	}
+	return ret, nil
+}
+
+func (s *joinSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.curr.lset, s.chunks
+}
+
+func (s *joinSeriesSet) AtSides() (left, right []AggrChunk) {
+	return s.curr.left, s.curr.right
+}
+
+func (s *joinSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.left.set.Err(); err != nil {
+		return err
+	}
+	return s.right.set.Err()
+}
+
+// joinSide reads groups of consecutive series with the same values of the join labels.
+type joinSide struct {
+	set  PeekSeriesSet
+	on   []string
+	name string
+
+	lastKey []string
+}
+
+func newJoinSide(s SeriesSet, on []string, name string) *joinSide {
+	return &joinSide{set: NewPeekSeriesSet(s), on: on, name: name}
+}
+
+func (s *joinSide) key(lset []Label) []string {
+	key := make([]string, 0, len(s.on))
+	for _, n := range s.on {
+		key = append(key, labelValue(lset, n))
+	}
+	return key
+}
+
+func (s *joinSide) peekKey() ([]string, bool) {
+	lset, _, ok := s.set.Peek()
+	if !ok {
+		return nil, false
+	}
+	return s.key(lset), true
+}
+
+func (s *joinSide) nextGroup() ([]Series, error) {
+	key, ok := s.peekKey()
+	if !ok {
+		return nil, nil
+	}
+	if s.lastKey != nil && compareKeys(s.lastKey, key) >= 0 {
+		return nil, errors.Errorf("%s series set not sorted on join labels: {%s} after {%s}",
+			s.name, strings.Join(key, ","), strings.Join(s.lastKey, ","))
+	}
+	s.lastKey = key
+
+	var group []Series
+	for {
+		k, ok := s.peekKey()
+		if !ok || compareKeys(k, key) != 0 {
+			return group, nil
+		}
+		s.set.Next()
+		lset, chks := s.set.At()
+		group = append(group, Series{Labels: lset, Chunks: chks})
+	}
+}
+
+func compareKeys(a, b []string) int {
+	for i := range a {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,167 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestJoinSeriesSets_OneToOne(t *testing.T) {
+	left := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "requests", "instance", "a"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "requests", "instance", "b"),
+			chunks: [][]sample{{{1, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "requests", "instance", "d"),
+			chunks: [][]sample{{{1, 4}}},
+		},
+	})
+	right := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "up", "instance", "b"),
+			chunks: [][]sample{{{1, 20}}, {{2, 21}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "up", "instance", "c"),
+			chunks: [][]sample{{{1, 30}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "up", "instance", "d"),
+			chunks: [][]sample{{{1, 40}}},
+		},
+	})
+
+	s := JoinSeriesSets(left, right, []string{"instance"})
+
+	testutil.Assert(t, s.Next(), "expected first joined series")
+	l, r := s.AtSides()
+	testutil.Equals(t, left.series[1].Chunks, l)
+	testutil.Equals(t, right.series[0].Chunks, r)
+	testutil.Assert(t, s.Next(), "expected second joined series")
+	l, r = s.AtSides()
+	testutil.Equals(t, left.series[2].Chunks, l)
+	testutil.Equals(t, right.series[2].Chunks, r)
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "requests", "instance", "b"),
+			chunks: [][]sample{{{1, 2}}, {{1, 20}}, {{2, 21}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "requests", "instance", "d"),
+			chunks: [][]sample{{{1, 4}}, {{1, 40}}},
+		},
+	}, JoinSeriesSets(
+		&listSeriesSet{series: left.series, idx: -1},
+		&listSeriesSet{series: right.series, idx: -1},
+		[]string{"instance"},
+	))
+}
+
+func TestJoinSeriesSets_ManyToOne(t *testing.T) {
+	left := []rawSeries{
+		{
+			lset:   labels.FromStrings("cpu", "0", "instance", "a"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("cpu", "1", "instance", "a"),
+			chunks: [][]sample{{{1, 2}}},
+		},
+	}
+	right := []rawSeries{
+		{
+			lset:   labels.FromStrings("instance", "a", "version", "1"),
+			chunks: [][]sample{{{1, 10}}},
+		},
+	}
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("cpu", "0", "instance", "a"),
+			chunks: [][]sample{{{1, 1}}, {{1, 10}}},
+		},
+		{
+			lset:   labels.FromStrings("cpu", "1", "instance", "a"),
+			chunks: [][]sample{{{1, 2}}, {{1, 10}}},
+		},
+	}, JoinSeriesSets(newListSeriesSet(t, left), newListSeriesSet(t, right), []string{"instance"}))
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("cpu", "0", "instance", "a"),
+			chunks: [][]sample{{{1, 10}}, {{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("cpu", "1", "instance", "a"),
+			chunks: [][]sample{{{1, 10}}, {{1, 2}}},
+		},
+	}, JoinSeriesSets(newListSeriesSet(t, right), newListSeriesSet(t, left), []string{"instance"}))
+
+	s := JoinSeriesSets(newListSeriesSet(t, left), newListSeriesSet(t, left), []string{"instance"})
+	testutil.Assert(t, !s.Next(), "expected many-to-many error")
+	testutil.NotOk(t, s.Err())
+}
+
+func TestJoinSeriesSets_Unsorted(t *testing.T) {
+	unsorted := []rawSeries{
+		{
+			lset:   labels.FromStrings("instance", "b"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "a"),
+			chunks: [][]sample{{{1, 2}}},
+		},
+	}
+	s := JoinSeriesSets(newListSeriesSet(t, unsorted), newListSeriesSet(t, unsorted), []string{"instance"})
+	testutil.Assert(t, s.Next(), "expected first joined series")
+	testutil.Assert(t, !s.Next(), "expected unsorted error")
+	testutil.NotOk(t, s.Err())
+}
+
+func TestJoinSeriesSets_OrderedByJoinLabels(t *testing.T) {
+	left := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "2", "instance", "a"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "instance", "b"),
+			chunks: [][]sample{{{1, 2}}},
+		},
+	})
+	right := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("instance", "a"),
+			chunks: [][]sample{{{1, 10}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "b"),
+			chunks: [][]sample{{{1, 20}}},
+		},
+	})
+
+	// Series are returned by join label values, not sorted by labels.
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "2", "instance", "a"),
+			chunks: [][]sample{{{1, 1}}, {{1, 10}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "instance", "b"),
+			chunks: [][]sample{{{1, 2}}, {{1, 20}}},
+		},
+	}, JoinSeriesSets(left, right, []string{"instance"}))
+}
@@ -0,0 +1,146 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// NewTotalChunkLimitSeriesSet returns a series set that fails with an error once the total number of chunks of all
+// series returned exceeds maxChunks, e.g. to protect against queries selecting many small series. The series
+// exceeding the limit is not returned. 0 disables the limit.
+func NewTotalChunkLimitSeriesSet(s SeriesSet, maxChunks int) SeriesSet {
+	if maxChunks == 0 {
+		return s
+	}
+	return &totalChunkLimitSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, limit: maxChunks}
+}
+
+type totalChunkLimitSeriesSet struct {
+	erroringSeriesSet
+
+	limit  int
+	chunks int
+}
+
+func (s *totalChunkLimitSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	_, chks := s.SeriesSet.At()
+
+	s.chunks += len(chks)
+	if s.chunks > s.limit {
+		s.err = errors.Errorf("total chunks limit %d violated (got %d)", s.limit, s.chunks)
+		return false
+	}
+	return true
+}
+
+// NewValueLengthLimitSeriesSet returns a series set that fails with an error on the first series with a label value
+// longer than maxLen bytes, e.g. to protect caches and UIs against pathologically long values. The offending series
+// is not returned. 0 disables the limit. See NewValueLengthTruncatingSeriesSet for truncating values instead.
+func NewValueLengthLimitSeriesSet(s SeriesSet, maxLen int) SeriesSet {
+	if maxLen == 0 {
+		return s
+	}
+	return &valueLengthLimitSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, limit: maxLen}
+}
+
+type valueLengthLimitSeriesSet struct {
+	erroringSeriesSet
+
+	limit int
+}
+
+func (s *valueLengthLimitSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, _ := s.SeriesSet.At()
+
+	for _, l := range lset {
+		if len(l.Value) > s.limit {
+			s.err = errors.Errorf("series %s: label %q value length limit %d violated (got %d)", LabelsToString(lset), l.Name, s.limit, len(l.Value))
+			return false
+		}
+	}
+	return true
+}
+
+// NewLabelNameCardinalityLimitSeriesSet returns a series set that fails with an error once the number of distinct
+// label names across all series returned exceeds maxDistinctNames, e.g. to protect clients building a column per
+// label name. The series exceeding the limit is not returned. 0 disables the limit.
+func NewLabelNameCardinalityLimitSeriesSet(s SeriesSet, maxDistinctNames int) SeriesSet {
+	if maxDistinctNames == 0 {
+		return s
+	}
+	return &labelNameCardinalityLimitSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, limit: maxDistinctNames, names: map[string]struct{}{}}
+}
+
+type labelNameCardinalityLimitSeriesSet struct {
+	erroringSeriesSet
+
+	limit int
+	names map[string]struct{}
+}
+
+func (s *labelNameCardinalityLimitSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, _ := s.SeriesSet.At()
+
+	for _, l := range lset {
+		s.names[l.Name] = struct{}{}
+	}
+	if len(s.names) > s.limit {
+		s.err = errors.Errorf("series %s: distinct label names limit %d violated (got %d)", LabelsToString(lset), s.limit, len(s.names))
+		return false
+	}
+	return true
+}
+
+// NewValueLengthTruncatingSeriesSet returns a series set which truncates label values longer than maxLen bytes
+// and adds a warning for every series truncated. Values are cut at a UTF-8 character boundary, so they may end up
+// slightly shorter than maxLen. Truncation can make series equal, so series are re-sorted and merged, see
+// newRelabelSeriesSet for the implied memory cost. 0 disables truncation.
+func NewValueLengthTruncatingSeriesSet(s SeriesSet, maxLen int) WarningsSeriesSet {
+	ret := &warningsSeriesSet{SeriesSet: s}
+	if maxLen == 0 {
+		return ret
+	}
+
+	ret.SeriesSet = newRelabelSeriesSet(s, func(lset []Label) []Label {
+		var truncated []Label
+		for i, l := range lset {
+			if len(l.Value) <= maxLen {
+				continue
+			}
+			if truncated == nil {
+				truncated = append(make([]Label, 0, len(lset)), lset...)
+			}
+			truncated[i].Value = truncateString(l.Value, maxLen)
+		}
+		if truncated == nil {
+			return lset
+		}
+		ret.warns = append(ret.warns, errors.Errorf("series %s: label values truncated to %d bytes", LabelsToString(lset), maxLen).Error())
+		return truncated
+	})
+	return ret
+}
+
+// truncateString returns at most the first n bytes of s without splitting a UTF-8 encoded character.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
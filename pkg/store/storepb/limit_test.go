@@ -0,0 +1,151 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestTotalChunkLimitSeriesSet(t *testing.T) {
+	// 5 chunks in total.
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}}, {{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3"),
+			chunks: [][]sample{{{1, 1}}, {{2, 2}}},
+		},
+	}
+
+	for _, limit := range []int{0, 5, 6} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			s := NewTotalChunkLimitSeriesSet(newListSeriesSet(t, in), limit)
+			seriesEquals(t, in, s)
+			testutil.Ok(t, s.Err())
+		})
+	}
+	t.Run("limit 4", func(t *testing.T) {
+		s := NewTotalChunkLimitSeriesSet(newListSeriesSet(t, in), 4)
+		seriesEquals(t, in[:2], s)
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "total chunks limit 4 violated (got 5)", s.Err().Error())
+	})
+}
+
+func TestValueLengthLimitSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "b", "12345"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "123456", "b", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}
+
+	for _, limit := range []int{0, 6} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			s := NewValueLengthLimitSeriesSet(newListSeriesSet(t, in), limit)
+			seriesEquals(t, in, s)
+			testutil.Ok(t, s.Err())
+		})
+	}
+	t.Run("limit 5", func(t *testing.T) {
+		s := NewValueLengthLimitSeriesSet(newListSeriesSet(t, in), 5)
+		seriesEquals(t, in[:1], s)
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, fmt.Sprintf(`series %s: label "a" value length limit 5 violated (got 6)`, LabelsToString(PromLabelsToLabels(in[1].lset))), s.Err().Error())
+	})
+}
+
+func TestLabelNameCardinalityLimitSeriesSet(t *testing.T) {
+	// 4 distinct label names in total.
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "b", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "c", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3", "b", "2", "c", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "4", "d", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}
+
+	for _, limit := range []int{0, 4, 5} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			s := NewLabelNameCardinalityLimitSeriesSet(newListSeriesSet(t, in), limit)
+			seriesEquals(t, in, s)
+			testutil.Ok(t, s.Err())
+		})
+	}
+	t.Run("limit 3", func(t *testing.T) {
+		s := NewLabelNameCardinalityLimitSeriesSet(newListSeriesSet(t, in), 3)
+		seriesEquals(t, in[:3], s)
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, fmt.Sprintf("series %s: distinct label names limit 3 violated (got 4)", LabelsToString(PromLabelsToLabels(in[3].lset))), s.Err().Error())
+	})
+}
+
+func TestValueLengthTruncatingSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "b", "12345"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "b", "123456"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			// The 2 bytes character is not split.
+			lset:   labels.FromStrings("a", "1234ä"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+	}
+
+	t.Run("limit 0", func(t *testing.T) {
+		s := NewValueLengthTruncatingSeriesSet(newListSeriesSet(t, in), 0)
+		seriesEquals(t, in, s)
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, 0, len(s.Warnings()))
+	})
+	t.Run("limit 5", func(t *testing.T) {
+		s := NewValueLengthTruncatingSeriesSet(newListSeriesSet(t, in), 5)
+		seriesEquals(t, []rawSeries{
+			{
+				// Series equal after truncation are merged.
+				lset:   labels.FromStrings("a", "1", "b", "12345"),
+				chunks: [][]sample{{{1, 1}}, {{2, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "1234"),
+				chunks: [][]sample{{{3, 3}}},
+			},
+		}, s)
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, []string{
+			fmt.Sprintf("series %s: label values truncated to 5 bytes", LabelsToString(PromLabelsToLabels(in[1].lset))),
+			fmt.Sprintf("series %s: label values truncated to 5 bytes", LabelsToString(PromLabelsToLabels(in[2].lset))),
+		}, s.Warnings())
+	})
+}
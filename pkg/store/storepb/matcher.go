@@ -0,0 +1,115 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// fastMatcher matches label values exactly like the labels.Matcher it was created from, but evaluates common
+// regular expressions without running the regexp engine.
+type fastMatcher struct {
+	name    string
+	matches func(string) bool
+}
+
+func newFastMatchers(ms []*labels.Matcher) []fastMatcher {
+	ret := make([]fastMatcher, 0, len(ms))
+	for _, m := range ms {
+		ret = append(ret, newFastMatcher(m))
+	}
+	return ret
+}
+
+func newFastMatcher(m *labels.Matcher) fastMatcher {
+	var f func(string) bool
+	switch m.Type {
+	case labels.MatchRegexp:
+		f = fastRegexMatch(m.Value)
+	case labels.MatchNotRegexp:
+		if g := fastRegexMatch(m.Value); g != nil {
+			f = func(v string) bool { return !g(v) }
+		}
+	}
+	if f == nil {
+		f = m.Matches
+	}
+	return fastMatcher{name: m.Name, matches: f}
+}
+
+// fastRegexMatch returns a function matching values against the fully anchored regular expression re, like
+// label matchers do, for the following forms of re, or nil for any other expression:
+//
+//	literal, literal|literal|..., literal.*, .*literal and .*literal.*
+//
+// Literals may contain escaped special characters. As in regexp, .* does not match newlines. Literals containing
+// the Unicode replacement character, and newlines next to .*, are not handled, as they would need special care to
+// match exactly like regexp.
+func fastRegexMatch(re string) func(string) bool {
+	if lit, ok := literal(re); ok {
+		return func(v string) bool { return v == lit }
+	}
+	if alts := strings.Split(re, "|"); len(alts) > 1 {
+		set := make(map[string]struct{}, len(alts))
+		for _, a := range alts {
+			lit, ok := literal(a)
+			if !ok {
+				return nil
+			}
+			set[lit] = struct{}{}
+		}
+		return func(v string) bool {
+			_, ok := set[v]
+			return ok
+		}
+	}
+
+	const anyStar = ".*"
+	anyPrefix := strings.HasPrefix(re, anyStar)
+	if anyPrefix {
+		re = re[len(anyStar):]
+	}
+	anySuffix := strings.HasSuffix(re, anyStar)
+	if anySuffix {
+		re = re[:len(re)-len(anyStar)]
+	}
+	lit, ok := literal(re)
+	if !ok || strings.Contains(lit, "\n") {
+		return nil
+	}
+
+	switch {
+	case anyPrefix && anySuffix:
+		return func(v string) bool { return strings.Contains(v, lit) && !strings.Contains(v, "\n") }
+	case anyPrefix:
+		return func(v string) bool {
+			return strings.HasSuffix(v, lit) && !strings.Contains(v[:len(v)-len(lit)], "\n")
+		}
+	default:
+		return func(v string) bool {
+			return strings.HasPrefix(v, lit) && !strings.Contains(v[len(lit):], "\n")
+		}
+	}
+}
+
+// literal returns the only string matched by re, if re is a plain case-sensitive literal, possibly with escaped
+// special characters.
+func literal(re string) (string, bool) {
+	if re == "" {
+		return "", true
+	}
+	p, err := syntax.Parse(re, syntax.Perl)
+	if err != nil || p.Op != syntax.OpLiteral || p.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	lit := string(p.Rune)
+	if strings.ContainsRune(lit, utf8.RuneError) {
+		return "", false
+	}
+	return lit, true
+}
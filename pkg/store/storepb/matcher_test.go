@@ -0,0 +1,110 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestFastMatcher(t *testing.T) {
+	values := []string{
+		"", "foo", "foobar", "barfoo", "xfoox", "bar", "baz", "fo", "FOO", "foo\n", "\nfoo", "x\nfooy", "foo\nbar",
+		"a.b", "axb", "a\\", "a\\b", "c", "foo.*", "\xff", "foo\xff", "\xfffoo", "�", "a|b", "a", "b",
+	}
+
+	for _, tcase := range []struct {
+		re   string
+		fast bool
+	}{
+		{re: "", fast: true},
+		{re: "foo", fast: true},
+		{re: "foo|bar|baz", fast: true},
+		{re: "foo|", fast: true},
+		{re: ".*", fast: true},
+		{re: ".*.*", fast: true},
+		{re: ".*foo", fast: true},
+		{re: "foo.*", fast: true},
+		{re: ".*foo.*", fast: true},
+		{re: "foo\n", fast: true},
+		{re: "a.b"},
+		{re: "a\\.b", fast: true},
+		{re: "a\\|b", fast: true},
+		{re: "a\\.b|c", fast: true},
+		{re: "a\\\\.*", fast: true},
+		{re: "(?:foo)", fast: true},
+		{re: "foo|ba.*"},
+		{re: ".*foo\n.*"},
+		{re: ".*?foo"},
+		{re: ".+foo"},
+		{re: "(?i)foo"},
+		{re: "(?s).*foo.*"},
+		{re: "foo\\.*"},
+		{re: "�"},
+		{re: "[a-z]+"},
+		{re: "^foo$"},
+	} {
+		t.Run(tcase.re, func(t *testing.T) {
+			testutil.Equals(t, tcase.fast, fastRegexMatch(tcase.re) != nil)
+
+			for _, typ := range []labels.MatchType{labels.MatchRegexp, labels.MatchNotRegexp} {
+				m := labels.MustNewMatcher(typ, "a", tcase.re)
+				f := newFastMatcher(m)
+				for _, v := range values {
+					testutil.Assert(t, m.Matches(v) == f.matches(v), "%s: mismatch for value %q", m, v)
+				}
+			}
+		})
+	}
+
+	// Other matcher types are passed through.
+	for _, typ := range []labels.MatchType{labels.MatchEqual, labels.MatchNotEqual} {
+		m := labels.MustNewMatcher(typ, "a", "foo")
+		f := newFastMatcher(m)
+		testutil.Equals(t, "a", f.name)
+		for _, v := range values {
+			testutil.Equals(t, m.Matches(v), f.matches(v))
+		}
+	}
+}
+
+func BenchmarkMatchingSeriesSet(b *testing.B) {
+	const num = 100000
+
+	series := make([]Series, 0, num)
+	for i := 0; i < num; i++ {
+		series = append(series, Series{Labels: []Label{
+			{Name: "instance", Value: fmt.Sprintf("host-%d.example.com:9100", i)},
+			{Name: "job", Value: fmt.Sprintf("job-%d", i%10)},
+		}})
+	}
+
+	for _, re := range []string{".*-42.*", "job-1|job-3|job-5"} {
+		name := "instance"
+		if re[0] == 'j' {
+			name = "job"
+		}
+		matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, name, re)}
+
+		for _, tcase := range []struct {
+			desc string
+			ms   []fastMatcher
+		}{
+			{desc: "regexp", ms: []fastMatcher{{name: name, matches: matchers[0].Matches}}},
+			{desc: "fast", ms: newFastMatchers(matchers)},
+		} {
+			b.Run(re+"/"+tcase.desc, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					s := &matchingSeriesSet{SeriesSet: &listSeriesSet{series: series, idx: -1}, matchers: tcase.ms}
+					for s.Next() {
+					}
+				}
+			})
+		}
+	}
+}
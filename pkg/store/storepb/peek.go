@@ -0,0 +1,84 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+// PeekSeriesSet is a SeriesSet that allows to look at the next series without consuming it.
+type PeekSeriesSet interface {
+	SeriesSet
+	// Peek returns the series that the next call to Next() will advance to.
+	// It returns false if there is no next series. Peek is idempotent until Next() is called.
+	Peek() ([]Label, []AggrChunk, bool)
+}
+
+// NewPeekSeriesSet returns a PeekSeriesSet wrapping the given series set.
+// The wrapped set must not reuse the returned label and chunk slices on Next(), as the series
+// returned by At() is kept while the set is already advanced for the look-ahead.
+func NewPeekSeriesSet(s SeriesSet) PeekSeriesSet {
+	if p, ok := s.(PeekSeriesSet); ok {
+		return p
+	}
+	return &peekSeriesSet{set: s}
+}
+
+type peekSeriesSet struct {
+	set SeriesSet
+
+	lset   []Label
+	chunks []AggrChunk
+
+	peekLset   []Label
+	peekChunks []AggrChunk
+	peeked     bool
+	done       bool
+}
+
+func (s *peekSeriesSet) Peek() ([]Label, []AggrChunk, bool) {
+	if s.done {
+		return nil, nil, false
+	}
+	if !s.peeked {
+		if !s.set.Next() {
+			s.done = true
+			return nil, nil, false
+		}
+		s.peekLset, s.peekChunks = s.set.At()
+		s.peeked = true
+	}
+	return s.peekLset, s.peekChunks, true
+}
+
+func (s *peekSeriesSet) Next() bool {
+	lset, chunks, ok := s.Peek()
+	if !ok {
+		s.lset, s.chunks = nil, nil
+		return false
+	}
+	s.lset, s.chunks = lset, chunks
+	s.peekLset, s.peekChunks = nil, nil
+	s.peeked = false
+	return true
+}
+
+func (s *peekSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
+
+func (s *peekSeriesSet) Err() error {
+	return s.set.Err()
+}
+
+// IsEmptySeriesSet returns whether the given series set has no series, e.g. to check whether a store returned
+// anything before committing to processing its response. It looks at the first series only, the returned set must
+// be used instead of s afterwards, as it still returns the first series. An error of s is returned if it fails
+// before returning any series.
+func IsEmptySeriesSet(s SeriesSet) (empty bool, wrapped SeriesSet, err error) {
+	p := NewPeekSeriesSet(s)
+	if _, _, ok := p.Peek(); ok {
+		return false, p, nil
+	}
+	if err := p.Err(); err != nil {
+		return false, p, err
+	}
+	return true, p, nil
+}
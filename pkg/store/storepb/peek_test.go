@@ -0,0 +1,118 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestPeekSeriesSet(t *testing.T) {
+	s := NewPeekSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{3, 3}, {4, 4}}},
+		},
+	}))
+
+	// Peek is idempotent until Next is called.
+	for i := 0; i < 3; i++ {
+		lset, chks, ok := s.Peek()
+		testutil.Assert(t, ok, "expected series to peek")
+		testutil.Equals(t, labels.FromStrings("a", "a"), LabelsToPromLabels(lset))
+		testutil.Equals(t, 1, len(chks))
+	}
+
+	testutil.Assert(t, s.Next(), "expected first series")
+	lset, _ := s.At()
+	testutil.Equals(t, labels.FromStrings("a", "a"), LabelsToPromLabels(lset))
+
+	peekLset, _, ok := s.Peek()
+	testutil.Assert(t, ok, "expected series to peek")
+	testutil.Equals(t, labels.FromStrings("a", "b"), LabelsToPromLabels(peekLset))
+
+	// Peeking must not change the current series.
+	lset, _ = s.At()
+	testutil.Equals(t, labels.FromStrings("a", "a"), LabelsToPromLabels(lset))
+
+	testutil.Assert(t, s.Next(), "expected second series")
+	lset, _ = s.At()
+	testutil.Equals(t, labels.FromStrings("a", "b"), LabelsToPromLabels(lset))
+
+	// End of stream.
+	for i := 0; i < 3; i++ {
+		_, _, ok = s.Peek()
+		testutil.Assert(t, !ok, "expected no series to peek")
+	}
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+}
+
+func TestPeekSeriesSet_Empty(t *testing.T) {
+	s := NewPeekSeriesSet(EmptySeriesSet())
+
+	_, _, ok := s.Peek()
+	testutil.Assert(t, !ok, "expected no series to peek")
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+}
+
+func TestIsEmptySeriesSet(t *testing.T) {
+	for _, tcase := range []struct {
+		desc  string
+		input []rawSeries
+	}{
+		{desc: "empty"},
+		{
+			desc: "one series",
+			input: []rawSeries{{
+				lset:   labels.FromStrings("a", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			}},
+		},
+		{
+			desc: "multiple series",
+			input: []rawSeries{
+				{
+					lset:   labels.FromStrings("a", "a"),
+					chunks: [][]sample{{{1, 1}, {2, 2}}},
+				},
+				{
+					lset:   labels.FromStrings("a", "b"),
+					chunks: [][]sample{{{3, 3}}},
+				},
+				{
+					lset:   labels.FromStrings("a", "c"),
+					chunks: [][]sample{{{4, 4}}},
+				},
+			},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			empty, s, err := IsEmptySeriesSet(newListSeriesSet(t, tcase.input))
+			testutil.Ok(t, err)
+			testutil.Equals(t, len(tcase.input) == 0, empty)
+
+			// No series is lost by probing.
+			seriesEquals(t, tcase.input, s)
+			testutil.Ok(t, s.Err())
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		expectedErr := errors.New("test")
+		empty, _, err := IsEmptySeriesSet(errSeriesSet{err: expectedErr})
+		testutil.Equals(t, expectedErr, err)
+		testutil.Assert(t, !empty, "expected failed set not to be reported empty")
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"sync"
+)
+
+// SeriesResponsePool recycles series responses, so that servers sending many series do not allocate a response
+// per series.
+//
+// A response must only be put back once it is not used anymore, i.e. after Send returned, as gRPC marshals the
+// message within Send. After Put neither the response nor its series may be accessed. Only the response is
+// recycled, the labels and chunks of the series stay owned by the caller and are never reused.
+type SeriesResponsePool struct {
+	p sync.Pool
+}
+
+// NewSeriesResponsePool returns a new pool of series responses.
+func NewSeriesResponsePool() *SeriesResponsePool {
+	return &SeriesResponsePool{p: sync.Pool{New: func() interface{} {
+		return &SeriesResponse{Result: &SeriesResponse_Series{Series: &Series{}}}
+	}}}
+}
+
+// Get returns a series response with an empty series.
+func (p *SeriesResponsePool) Get() *SeriesResponse {
+	return p.p.Get().(*SeriesResponse)
+}
+
+// Put resets the given series response and puts it back into the pool. Responses of other types are ignored.
+func (p *SeriesResponsePool) Put(r *SeriesResponse) {
+	s, ok := r.Result.(*SeriesResponse_Series)
+	if !ok || s.Series == nil {
+		return
+	}
+	*s.Series = Series{}
+	p.p.Put(r)
+}
+
+// NewSeriesResponseFromPool returns a series response of the pool for the given series. The response has to be put
+// back with SeriesResponsePool.Put once sent.
+func NewSeriesResponseFromPool(pool *SeriesResponsePool, series Series) *SeriesResponse {
+	r := pool.Get()
+	*r.GetSeries() = series
+	return r
+}
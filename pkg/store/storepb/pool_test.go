@@ -0,0 +1,68 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSeriesResponsePool(t *testing.T) {
+	p := NewSeriesResponsePool()
+	s := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}}})
+
+	r := NewSeriesResponseFromPool(p, s)
+	testutil.Equals(t, NewSeriesResponse(&s), r)
+
+	series := r.GetSeries()
+	p.Put(r)
+	// The series is reset, so the pool does not keep labels and chunks alive.
+	testutil.Equals(t, Series{}, *series)
+
+	r = p.Get()
+	testutil.Equals(t, NewSeriesResponse(&Series{}), r)
+
+	// Other responses are ignored.
+	p.Put(NewWarnSeriesResponse(errors.New("warning")))
+	p.Put(&SeriesResponse{})
+}
+
+func BenchmarkSeriesResponsePool(b *testing.B) {
+	series := make([]Series, 0, 10000)
+	for i := 0; i < cap(series); i++ {
+		series = append(series, newSeries(b, labels.FromStrings("a", fmt.Sprintf("%d", i)), [][]sample{{{1, 1}}}))
+	}
+	// Marshal like gRPC does in Send.
+	send := func(r *SeriesResponse) {
+		_, err := r.Marshal()
+		testutil.Ok(b, err)
+	}
+
+	b.Run("without pool", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, s := range series {
+				var out Series
+				out.Labels, out.Chunks = s.Labels, s.Chunks
+				send(NewSeriesResponse(&out))
+			}
+		}
+	})
+	b.Run("with pool", func(b *testing.B) {
+		p := NewSeriesResponsePool()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, s := range series {
+				r := NewSeriesResponseFromPool(p, Series{Labels: s.Labels, Chunks: s.Chunks})
+				send(r)
+				p.Put(r)
+			}
+		}
+	})
+}
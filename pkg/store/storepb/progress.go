@@ -0,0 +1,242 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"github.com/pkg/errors"
+)
+
+// NewProgressSeriesSet returns a series set which calls onProgress with the number of series returned so far
+// after every given number of series, e.g. to report progress of long running queries. The callback is called
+// synchronously from Next and never once the wrapped set is exhausted or failed. The set is returned as is if
+// onProgress is nil.
+func NewProgressSeriesSet(s SeriesSet, every int, onProgress func(seriesSoFar int)) SeriesSet {
+	if onProgress == nil {
+		return s
+	}
+	if every < 1 {
+		every = 1
+	}
+	return &progressSeriesSet{SeriesSet: s, every: every, onProgress: onProgress}
+}
+
+type progressSeriesSet struct {
+	SeriesSet
+
+	every      int
+	onProgress func(int)
+	n          int
+}
+
+func (s *progressSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.n++
+	if s.n%s.every == 0 {
+		s.onProgress(s.n)
+	}
+	return true
+}
+
+// NewSeriesSizeTrackingSeriesSet returns a series set which tracks the largest number of chunks and the largest
+// number of chunk data bytes of a single series, e.g. to find heavy hitter series of a query. Both maxima are
+// tracked independently, so they may come from different series. Only chunk metadata is looked at, no data is
+// decoded. The returned function reports the maxima of the series returned so far.
+func NewSeriesSizeTrackingSeriesSet(s SeriesSet) (SeriesSet, func() (maxChunks int, maxBytes int64)) {
+	t := &sizeTrackingSeriesSet{SeriesSet: s}
+	return t, func() (int, int64) { return t.maxChunks, t.maxBytes }
+}
+
+type sizeTrackingSeriesSet struct {
+	SeriesSet
+
+	maxChunks int
+	maxBytes  int64
+}
+
+func (s *sizeTrackingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	_, chks := s.SeriesSet.At()
+	if len(chks) > s.maxChunks {
+		s.maxChunks = len(chks)
+	}
+	if b := chunksBytes(chks); b > s.maxBytes {
+		s.maxBytes = b
+	}
+	return true
+}
+
+// chunksBytes returns the total data size of all present chunks, raw and aggregated.
+func chunksBytes(chks []AggrChunk) int64 {
+	var n int64
+	for i := range chks {
+		for _, aggr := range allAggrs {
+			if c, ok := chks[i].Get(aggr); ok {
+				n += int64(len(c.Data))
+			}
+		}
+	}
+	return n
+}
+
+// NewSampleCountingSeriesSet returns a series set which counts the samples of all series returned so far, e.g. to
+// estimate the cost of a query. Raw chunks contribute the number of samples from their chunk header, so their
+// samples are not decoded and downstream iteration does not decode them twice. Downsampled chunks contribute the
+// sum of their count aggregate, the number of raw samples they were created from. The returned function reports
+// the total, which is only final once the set is fully drained. Chunks which fail to decode fail the set.
+func NewSampleCountingSeriesSet(s SeriesSet) (SeriesSet, func() int64) {
+	c := &sampleCountingSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}
+	return c, func() int64 { return c.n }
+}
+
+type sampleCountingSeriesSet struct {
+	erroringSeriesSet
+
+	n int64
+}
+
+func (s *sampleCountingSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+	for i := range chks {
+		n, err := countSamples(&chks[i])
+		if err != nil {
+			s.err = errors.Wrapf(err, "series %s chunk %d", LabelsToString(lset), i)
+			return false
+		}
+		s.n += n
+	}
+	return true
+}
+
+// countSamples returns the number of raw samples of the chunk. Chunks with neither a raw nor a count chunk have
+// no samples.
+func countSamples(c *AggrChunk) (int64, error) {
+	if c.Raw != nil {
+		chk, err := c.Raw.Decode()
+		if err != nil {
+			return 0, err
+		}
+		return int64(chk.NumSamples()), nil
+	}
+	if c.Count == nil {
+		return 0, nil
+	}
+
+	samples, err := c.Count.Samples()
+	if err != nil {
+		return 0, errors.Wrap(err, "count chunk")
+	}
+	var n int64
+	for _, s := range samples {
+		n += int64(s.V)
+	}
+	return n, nil
+}
+
+// NewEncodingHistogramSeriesSet returns a series set which counts the chunks of each encoding of the series
+// returned so far, e.g. to show the mix of data read by a query. Every present chunk of an AggrChunk is counted,
+// so a downsampled AggrChunk counts once per aggregate. Only chunk metadata is looked at, no data is decoded. The
+// returned function reports a copy of the counts, which are only final once the set is fully drained.
+func NewEncodingHistogramSeriesSet(s SeriesSet) (SeriesSet, func() map[Chunk_Encoding]int) {
+	h := &encodingHistogramSeriesSet{SeriesSet: s, counts: map[Chunk_Encoding]int{}}
+	return h, func() map[Chunk_Encoding]int {
+		ret := make(map[Chunk_Encoding]int, len(h.counts))
+		for enc, n := range h.counts {
+			ret[enc] = n
+		}
+		return ret
+	}
+}
+
+type encodingHistogramSeriesSet struct {
+	SeriesSet
+
+	counts map[Chunk_Encoding]int
+}
+
+func (s *encodingHistogramSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	_, chks := s.SeriesSet.At()
+	for i := range chks {
+		for _, aggr := range allAggrs {
+			if c, ok := chks[i].Get(aggr); ok {
+				s.counts[c.Type]++
+			}
+		}
+	}
+	return true
+}
+
+// NewBoundaryTrackingSeriesSet returns a series set which remembers the labels of the first and the last series
+// returned so far, e.g. to check the range of series a store actually returned. The returned function reports
+// them, both are nil if no series was returned. The labels are deep copied, so they stay valid even if the wrapped
+// set reuses its buffers, e.g. for labels created by LabelsFromBytes.
+func NewBoundaryTrackingSeriesSet(s SeriesSet) (SeriesSet, func() (first, last []Label)) {
+	b := &boundaryTrackingSeriesSet{SeriesSet: s}
+	return b, func() ([]Label, []Label) { return b.first, b.last }
+}
+
+type boundaryTrackingSeriesSet struct {
+	SeriesSet
+
+	first, last []Label
+}
+
+func (s *boundaryTrackingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	lset, _ := s.SeriesSet.At()
+	s.last = deepCopyLabels(lset)
+	if s.first == nil {
+		s.first = s.last
+	}
+	return true
+}
+
+// SeriesSetLabelRange drains s and returns deep copies of the labels of its first and last series and the number of
+// series, e.g. to plan shards by label ranges. Chunks are not looked at. The set is consumed, so it cannot be used
+// afterwards. first and last are nil if the set is empty.
+func SeriesSetLabelRange(s SeriesSet) (first, last []Label, count int, err error) {
+	b, boundaries := NewBoundaryTrackingSeriesSet(s)
+	for b.Next() {
+		count++
+	}
+	if err := b.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+	first, last = boundaries()
+	return first, last, count, nil
+}
+
+// deepCopyLabels returns a copy of the labels not sharing any memory with the input. All names and values are
+// copied into a single buffer to keep allocations low. An empty result is not nil.
+func deepCopyLabels(lset []Label) []Label {
+	n := 0
+	for _, l := range lset {
+		n += len(l.Name) + len(l.Value)
+	}
+	buf := make([]byte, 0, n)
+	for _, l := range lset {
+		buf = append(buf, l.Name...)
+		buf = append(buf, l.Value...)
+	}
+
+	ret := make([]Label, len(lset))
+	for i, l := range lset {
+		ret[i].Name = yoloString(buf[:len(l.Name)])
+		buf = buf[len(l.Name):]
+		ret[i].Value = yoloString(buf[:len(l.Value)])
+		buf = buf[len(l.Value):]
+	}
+	return ret
+}
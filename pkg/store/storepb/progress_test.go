@@ -0,0 +1,220 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestProgressSeriesSet(t *testing.T) {
+	var in []rawSeries
+	for i := 0; i < 10; i++ {
+		in = append(in, rawSeries{
+			lset:   labels.FromStrings("a", fmt.Sprintf("%02d", i)),
+			chunks: [][]sample{{{1, 1}}},
+		})
+	}
+
+	for _, tcase := range []struct {
+		every    int
+		expected []int
+	}{
+		{every: 1, expected: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{every: 3, expected: []int{3, 6, 9}},
+		{every: 10, expected: []int{10}},
+		{every: 11},
+		{every: 0, expected: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+	} {
+		t.Run(fmt.Sprintf("every %d", tcase.every), func(t *testing.T) {
+			var got []int
+			s := NewProgressSeriesSet(newListSeriesSet(t, in), tcase.every, func(n int) { got = append(got, n) })
+			seriesEquals(t, in, s)
+			testutil.Ok(t, s.Err())
+			testutil.Equals(t, tcase.expected, got)
+		})
+	}
+}
+
+func TestProgressSeriesSet_Error(t *testing.T) {
+	called := false
+	s := NewProgressSeriesSet(errSeriesSet{err: errors.New("test")}, 1, func(int) { called = true })
+	testutil.Assert(t, !s.Next(), "expected no series")
+	testutil.NotOk(t, s.Err())
+	testutil.Assert(t, !called, "expected callback not to be called")
+}
+
+func TestProgressSeriesSet_NilCallback(t *testing.T) {
+	l := newListSeriesSet(t, nil)
+	testutil.Equals(t, SeriesSet(l), NewProgressSeriesSet(l, 1, nil))
+}
+
+func TestSeriesSizeTrackingSeriesSet(t *testing.T) {
+	raw := func(n int) AggrChunk { return AggrChunk{Raw: &Chunk{Data: make([]byte, n)}} }
+
+	s, sizes := NewSeriesSizeTrackingSeriesSet(&listSeriesSet{idx: -1, series: []Series{
+		{Labels: []Label{{Name: "a", Value: "1"}}, Chunks: []AggrChunk{raw(10)}},
+		// Most bytes, counting all aggregates.
+		{Labels: []Label{{Name: "a", Value: "2"}}, Chunks: []AggrChunk{
+			raw(20),
+			{Count: &Chunk{Data: make([]byte, 30)}, Sum: &Chunk{Data: make([]byte, 40)}},
+		}},
+		// Most chunks.
+		{Labels: []Label{{Name: "a", Value: "3"}}, Chunks: []AggrChunk{raw(5), raw(5), raw(5), raw(5)}},
+		{Labels: []Label{{Name: "a", Value: "4"}}},
+	}})
+
+	maxChunks, maxBytes := sizes()
+	testutil.Equals(t, 0, maxChunks)
+	testutil.Equals(t, int64(0), maxBytes)
+
+	testutil.Assert(t, s.Next(), "expected series")
+	maxChunks, maxBytes = sizes()
+	testutil.Equals(t, 1, maxChunks)
+	testutil.Equals(t, int64(10), maxBytes)
+
+	for s.Next() {
+	}
+	testutil.Ok(t, s.Err())
+
+	maxChunks, maxBytes = sizes()
+	testutil.Equals(t, 4, maxChunks)
+	testutil.Equals(t, int64(90), maxBytes)
+}
+
+func TestSampleCountingSeriesSet(t *testing.T) {
+	in := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}, {2, 2}, {3, 3}}},
+		},
+		{
+			lset: labels.FromStrings("a", "3"),
+			// Count aggregates of two downsampled chunks.
+			chunks: [][]sample{{{10, 5}, {20, 7}}, {{30, 3}}},
+		},
+	})
+	for i, c := range in.series[2].Chunks {
+		in.series[2].Chunks[i] = AggrChunk{MinTime: c.MinTime, MaxTime: c.MaxTime, Count: c.Raw, Sum: c.Raw}
+	}
+
+	s, count := NewSampleCountingSeriesSet(in)
+	testutil.Equals(t, int64(0), count())
+
+	testutil.Assert(t, s.Next(), "expected series")
+	testutil.Equals(t, int64(3), count())
+
+	for s.Next() {
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, int64(21), count())
+
+	t.Run("invalid chunk", func(t *testing.T) {
+		s, _ := NewSampleCountingSeriesSet(&listSeriesSet{idx: -1, series: []Series{
+			{Labels: []Label{{Name: "a", Value: "1"}}, Chunks: []AggrChunk{{Raw: &Chunk{Type: Chunk_XOR}}}},
+		}})
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+	})
+}
+
+func TestEncodingHistogramSeriesSet(t *testing.T) {
+	var (
+		xor = &Chunk{Type: Chunk_XOR}
+		dd  = &Chunk{Type: Chunk_DOUBLE_DELTA}
+	)
+	s, counts := NewEncodingHistogramSeriesSet(&listSeriesSet{idx: -1, series: []Series{
+		{Labels: []Label{{Name: "a", Value: "1"}}, Chunks: []AggrChunk{{Raw: xor}, {Raw: xor}, {Raw: dd}}},
+		{Labels: []Label{{Name: "a", Value: "2"}}, Chunks: []AggrChunk{
+			{Count: xor, Sum: xor, Min: xor, Max: xor, Counter: xor},
+			{Count: xor, Sum: xor},
+		}},
+		{Labels: []Label{{Name: "a", Value: "3"}}},
+	}})
+	testutil.Equals(t, map[Chunk_Encoding]int{}, counts())
+
+	testutil.Assert(t, s.Next(), "expected series")
+	testutil.Equals(t, map[Chunk_Encoding]int{Chunk_XOR: 2, Chunk_DOUBLE_DELTA: 1}, counts())
+
+	for s.Next() {
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, map[Chunk_Encoding]int{Chunk_XOR: 9, Chunk_DOUBLE_DELTA: 1}, counts())
+}
+
+func TestBoundaryTrackingSeriesSet(t *testing.T) {
+	s, boundaries := NewBoundaryTrackingSeriesSet(newListSeriesSet(t, nil))
+	testutil.Assert(t, !s.Next(), "expected no series")
+	first, last := boundaries()
+	testutil.Assert(t, first == nil && last == nil, "expected no boundaries")
+
+	// Labels pointing to a buffer which is reused for every series.
+	buf := []byte("a1")
+	var series []Series
+	for i := 0; i < 3; i++ {
+		series = append(series, Series{Labels: LabelsFromBytes([][2][]byte{{buf[:1], buf[1:]}})})
+	}
+	reusing := &bufferReusingSeriesSet{SeriesSet: &listSeriesSet{series: series, idx: -1}, buf: buf, values: []byte("123")}
+
+	s, boundaries = NewBoundaryTrackingSeriesSet(reusing)
+	testutil.Assert(t, s.Next(), "expected first series")
+	first, last = boundaries()
+	testutil.Equals(t, []Label{{Name: "a", Value: "1"}}, first)
+	testutil.Equals(t, []Label{{Name: "a", Value: "1"}}, last)
+
+	for s.Next() {
+	}
+	testutil.Ok(t, s.Err())
+
+	first, last = boundaries()
+	testutil.Equals(t, []Label{{Name: "a", Value: "1"}}, first)
+	testutil.Equals(t, []Label{{Name: "a", Value: "3"}}, last)
+}
+
+func TestSeriesSetLabelRange(t *testing.T) {
+	first, last, count, err := SeriesSetLabelRange(newListSeriesSet(t, nil))
+	testutil.Ok(t, err)
+	testutil.Assert(t, first == nil && last == nil, "expected no range")
+	testutil.Equals(t, 0, count)
+
+	buf := []byte("a1")
+	var series []Series
+	for i := 0; i < 3; i++ {
+		series = append(series, Series{Labels: LabelsFromBytes([][2][]byte{{buf[:1], buf[1:]}})})
+	}
+	first, last, count, err = SeriesSetLabelRange(&bufferReusingSeriesSet{SeriesSet: &listSeriesSet{series: series, idx: -1}, buf: buf, values: []byte("123")})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Label{{Name: "a", Value: "1"}}, first)
+	testutil.Equals(t, []Label{{Name: "a", Value: "3"}}, last)
+	testutil.Equals(t, 3, count)
+
+	_, _, _, err = SeriesSetLabelRange(&failingSeriesSet{SeriesSet: &listSeriesSet{series: series, idx: -1}, n: 1, err: errors.New("failure")})
+	testutil.NotOk(t, err)
+}
+
+// bufferReusingSeriesSet overwrites the label value buffer of the wrapped set on every Next.
+type bufferReusingSeriesSet struct {
+	SeriesSet
+
+	buf    []byte
+	values []byte
+	i      int
+}
+
+func (s *bufferReusingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.buf[1] = s.values[s.i]
+	s.i++
+	return true
+}
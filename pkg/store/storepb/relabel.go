@@ -0,0 +1,406 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// NewLabelPrefixFilterSeriesSet returns a series set which keeps (if keep is true) or drops (otherwise) all labels
+// with names starting with prefix, e.g. to hide internal "__" labels from clients.
+// Series are re-sorted and merged if they end up equal, see newRelabelSeriesSet for the implied memory cost.
+func NewLabelPrefixFilterSeriesSet(s SeriesSet, prefix string, keep bool) SeriesSet {
+	return newRelabelSeriesSet(s, func(lset []Label) []Label {
+		ret := make([]Label, 0, len(lset))
+		for _, l := range lset {
+			if strings.HasPrefix(l.Name, prefix) == keep {
+				ret = append(ret, l)
+			}
+		}
+		return ret
+	})
+}
+
+// NewAnnotatedSeriesSet returns a series set which adds the given annotation labels, e.g. __store__="gateway-1",
+// to every series. Annotations take precedence over existing labels with the same name. It is meant as a
+// diagnostic aid to see where series come from. Adding labels can change the order of series, so they are
+// re-sorted, see newRelabelSeriesSet for the implied memory cost.
+func NewAnnotatedSeriesSet(s SeriesSet, annotations []Label) SeriesSet {
+	annotations = append([]Label(nil), annotations...)
+	SortLabels(annotations)
+
+	return newRelabelSeriesSet(s, func(lset []Label) []Label {
+		ret := make([]Label, 0, len(lset)+len(annotations))
+		i, j := 0, 0
+		for i < len(lset) && j < len(annotations) {
+			switch {
+			case lset[i].Name < annotations[j].Name:
+				ret = append(ret, lset[i])
+				i++
+			case lset[i].Name > annotations[j].Name:
+				ret = append(ret, annotations[j])
+				j++
+			default:
+				ret = append(ret, annotations[j])
+				i++
+				j++
+			}
+		}
+		ret = append(ret, lset[i:]...)
+		return append(ret, annotations[j:]...)
+	})
+}
+
+// NewNameCollapsingSeriesSet returns a series set with the metric name label dropped from all series, so that
+// series which differ only by metric name are merged into one with concatenated chunks. All series are buffered
+// and re-sorted, so memory usage is proportional to the whole set, see newRelabelSeriesSet.
+func NewNameCollapsingSeriesSet(s SeriesSet) SeriesSet {
+	return newRelabelSeriesSet(s, func(lset []Label) []Label {
+		ret := make([]Label, 0, len(lset))
+		for _, l := range lset {
+			if l.Name != labels.MetricName {
+				ret = append(ret, l)
+			}
+		}
+		return ret
+	})
+}
+
+// NewLabelValueRewriteSeriesSet returns a series set which rewrites label values according to the given rules,
+// mapping label names to a table of old to new values, e.g. to rename instances after a migration. Values without
+// a mapping are kept. Series are re-sorted and merged if they end up equal, see newRelabelSeriesSet for the
+// implied memory cost.
+func NewLabelValueRewriteSeriesSet(s SeriesSet, rules map[string]map[string]string) SeriesSet {
+	return newRelabelSeriesSet(s, func(lset []Label) []Label {
+		var ret []Label
+		for i, l := range lset {
+			v, ok := rules[l.Name][l.Value]
+			if !ok {
+				continue
+			}
+			if ret == nil {
+				ret = append(make([]Label, 0, len(lset)), lset...)
+			}
+			ret[i].Value = v
+		}
+		if ret == nil {
+			return lset
+		}
+		return ret
+	})
+}
+
+// NewMetricRenameSeriesSet returns a series set with the metric name of every series replaced by the result of
+// rename, e.g. to prefix metric names with a tenant for multi-tenant read views. Series without a metric name are
+// passed through. Series are re-sorted and merged if they end up equal, see newRelabelSeriesSet for the implied
+// memory cost.
+func NewMetricRenameSeriesSet(s SeriesSet, rename func(string) string) SeriesSet {
+	return newRelabelSeriesSet(s, func(lset []Label) []Label {
+		for i, l := range lset {
+			if l.Name != labels.MetricName {
+				continue
+			}
+			ret := append(make([]Label, 0, len(lset)), lset...)
+			ret[i].Value = rename(l.Value)
+			return ret
+		}
+		return lset
+	})
+}
+
+// NewDuplicatePairDropSeriesSet returns a series set which removes exact duplicates of label pairs with equal name
+// and value from every series, e.g. to defend against relabeling bugs. Labels of the series have to be sorted, so
+// that duplicates are adjacent. Labels with equal names but different values are kept. Series are re-sorted and
+// merged if they end up equal, see newRelabelSeriesSet for the implied memory cost.
+func NewDuplicatePairDropSeriesSet(s SeriesSet) SeriesSet {
+	return newRelabelSeriesSet(s, func(lset []Label) []Label {
+		var ret []Label
+		for i, l := range lset {
+			if i == 0 || l != lset[i-1] {
+				if ret != nil {
+					ret = append(ret, l)
+				}
+				continue
+			}
+			if ret == nil {
+				ret = append(make([]Label, 0, len(lset)-1), lset[:i]...)
+			}
+		}
+		if ret == nil {
+			return lset
+		}
+		return ret
+	})
+}
+
+// NewFuzzyGroupSeriesSet returns a series set with one series per distinct combination of values of the given key
+// labels, having the labels common to all series of the group and their concatenated chunks, e.g. to group series
+// by some labels for exploratory analysis. Missing key labels are treated as empty. Unlike NewCustomMergeSeriesSet,
+// grouped series don't have to be adjacent, as all series are buffered and re-sorted by the key labels, so memory
+// usage is proportional to the whole set.
+func NewFuzzyGroupSeriesSet(s SeriesSet, keys []string) SeriesSet {
+	return &relabelSeriesSet{load: func() ([]Series, error) { return groupAndSort(s, keys) }, idx: -1}
+}
+
+func groupAndSort(set SeriesSet, keys []string) ([]Series, error) {
+	type keyed struct {
+		key    []string
+		series Series
+	}
+	var series []keyed
+	for set.Next() {
+		x := deepCopySeries(set.At())
+		key := make([]string, 0, len(keys))
+		for _, k := range keys {
+			key = append(key, labelValue(x.Labels, k))
+		}
+		series = append(series, keyed{key: key, series: x})
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return compareKeys(series[i].key, series[j].key) < 0
+	})
+
+	var ret []Series
+	for i, x := range series {
+		if i > 0 && compareKeys(series[i-1].key, x.key) == 0 {
+			last := &ret[len(ret)-1]
+			last.Labels = commonLabels(last.Labels, x.series.Labels)
+			last.Chunks = append(last.Chunks, x.series.Chunks...)
+			continue
+		}
+		ret = append(ret, Series{
+			Labels: x.series.Labels,
+			Chunks: append([]AggrChunk(nil), x.series.Chunks...),
+		})
+	}
+	// The common labels of groups are not necessarily in the order of their keys.
+	sort.SliceStable(ret, func(i, j int) bool {
+		return CompareLabels(ret[i].Labels, ret[j].Labels) < 0
+	})
+	return ret, nil
+}
+
+// SummaryLabel is the name of the label marking the synthetic series added by NewSummarizingSeriesSet.
+const SummaryLabel = "__summary__"
+
+// NewSummarizingSeriesSet returns a series set which keeps at most maxSeriesPerGroup series of every group of series
+// with equal values of the groupBy labels, e.g. to avoid overloading overview dashboards. The remaining series of a
+// group are replaced by a single synthetic series without chunks, having the groupBy labels of the group and a
+// SummaryLabel label with the value "... and N more". The first series of a group in the order of s are kept.
+// Since groups don't have to be adjacent, all series are buffered and re-sorted, so memory usage is proportional to
+// the whole set. 0 disables the limit.
+func NewSummarizingSeriesSet(s SeriesSet, groupBy []string, maxSeriesPerGroup int) (SeriesSet, error) {
+	if maxSeriesPerGroup < 0 {
+		return nil, errors.Errorf("invalid max series per group %d", maxSeriesPerGroup)
+	}
+	if maxSeriesPerGroup == 0 {
+		return s, nil
+	}
+	return &relabelSeriesSet{load: func() ([]Series, error) { return summarize(s, groupBy, maxSeriesPerGroup) }, idx: -1}, nil
+}
+
+func summarize(set SeriesSet, groupBy []string, maxSeriesPerGroup int) ([]Series, error) {
+	type group struct {
+		labels  []Label
+		members int
+	}
+	var (
+		ret    []Series
+		groups = map[string]*group{}
+		// Groups in order of their first occurrence, so that the result does not depend on map iteration.
+		ordered []*group
+		key     []byte
+	)
+	for set.Next() {
+		x := deepCopySeries(set.At())
+		lset := x.Labels
+
+		// Missing group labels are treated as empty, like in PromQL.
+		var glset []Label
+		for _, n := range groupBy {
+			if v := labelValue(lset, n); v != "" {
+				glset = append(glset, Label{Name: n, Value: v})
+			}
+		}
+		SortLabels(glset)
+
+		key = AppendLabels(key[:0], glset)
+		g, ok := groups[string(key)]
+		if !ok {
+			g = &group{labels: glset}
+			groups[string(key)] = g
+			ordered = append(ordered, g)
+		}
+		g.members++
+		if g.members <= maxSeriesPerGroup {
+			ret = append(ret, x)
+		}
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range ordered {
+		if g.members <= maxSeriesPerGroup {
+			continue
+		}
+		lset := append(g.labels, Label{Name: SummaryLabel, Value: fmt.Sprintf("... and %d more", g.members-maxSeriesPerGroup)})
+		SortLabels(lset)
+		ret = append(ret, Series{Labels: lset})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return CompareLabels(ret[i].Labels, ret[j].Labels) < 0
+	})
+	return ret, nil
+}
+
+// commonLabels returns the labels present with equal values in both sorted label sets.
+func commonLabels(a, b []Label) []Label {
+	ret := make([]Label, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i].Name < b[j].Name:
+			i++
+		case a[i].Name > b[j].Name:
+			j++
+		default:
+			if a[i].Value == b[j].Value {
+				ret = append(ret, a[i])
+			}
+			i++
+			j++
+		}
+	}
+	return ret
+}
+
+// relabelSeriesSet is a series set of all series of the wrapped set, transformed by load on the first Next() call.
+// An error of load, including an error of the wrapped set, fails the set. Loaders copy the series they buffer, as
+// the wrapped set may reuse its buffers on Next().
+type relabelSeriesSet struct {
+	load func() ([]Series, error)
+
+	series []Series
+	init   bool
+	idx    int
+	err    error
+}
+
+// newRelabelSeriesSet returns a series set with the labels of each series replaced by the result of relabel,
+// which must return a sorted label set and must not modify its input. Since relabeled series are not necessarily
+// sorted anymore, all series are buffered and sorted on the first Next() call, so memory usage is proportional
+// to the whole set. Series with equal labels after relabeling are merged into a single one with concatenated chunks.
+func newRelabelSeriesSet(s SeriesSet, relabel func([]Label) []Label) *relabelSeriesSet {
+	return &relabelSeriesSet{load: func() ([]Series, error) { return relabelAndSort(s, relabel) }, idx: -1}
+}
+
+func (s *relabelSeriesSet) Next() bool {
+	if !s.init {
+		s.init = true
+		s.series, s.err = s.load()
+	}
+	if s.err != nil || s.idx >= len(s.series) {
+		return false
+	}
+	s.idx++
+	return s.idx < len(s.series)
+}
+
+func (s *relabelSeriesSet) At() ([]Label, []AggrChunk) {
+	if s.idx < 0 || s.idx >= len(s.series) {
+		return nil, nil
+	}
+	return s.series[s.idx].Labels, s.series[s.idx].Chunks
+}
+
+func (s *relabelSeriesSet) Err() error {
+	return s.err
+}
+
+func relabelAndSort(set SeriesSet, relabel func([]Label) []Label) ([]Series, error) {
+	var series []Series
+	for set.Next() {
+		x := deepCopySeries(set.At())
+		x.Labels = relabel(x.Labels)
+		series = append(series, x)
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return CompareLabels(series[i].Labels, series[j].Labels) < 0
+	})
+
+	// Merge series which became equal.
+	ret := series[:0]
+	for _, x := range series {
+		if len(ret) > 0 && CompareLabels(ret[len(ret)-1].Labels, x.Labels) == 0 {
+			last := &ret[len(ret)-1]
+			chks := make([]AggrChunk, 0, len(last.Chunks)+len(x.Chunks))
+			chks = append(chks, last.Chunks...)
+			last.Chunks = append(chks, x.Chunks...)
+			continue
+		}
+		ret = append(ret, x)
+	}
+	return ret, nil
+}
+
+// NewLabelReorderSeriesSet returns a series set with the labels of each series reordered for display, so that the
+// labels named in preferredOrder come first, in that order, followed by all other labels sorted by name.
+//
+// This is a terminal transform for output only: the returned label sets are generally not sorted by name and the
+// series not sorted by labels anymore, which breaks the invariants of the merge and all other series sets, so the
+// result must not be passed on to them. Series are not buffered.
+func NewLabelReorderSeriesSet(s SeriesSet, preferredOrder []string) SeriesSet {
+	rank := make(map[string]int, len(preferredOrder))
+	for i, n := range preferredOrder {
+		if _, ok := rank[n]; !ok {
+			rank[n] = i
+		}
+	}
+	return &labelReorderSeriesSet{SeriesSet: s, rank: rank}
+}
+
+type labelReorderSeriesSet struct {
+	SeriesSet
+
+	rank map[string]int
+	lset []Label
+	chks []AggrChunk
+}
+
+func (s *labelReorderSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	s.lset = append(make([]Label, 0, len(lset)), lset...)
+	sort.SliceStable(s.lset, func(i, j int) bool {
+		ri, iok := s.rank[s.lset[i].Name]
+		rj, jok := s.rank[s.lset[j].Name]
+		switch {
+		case iok && jok:
+			return ri < rj
+		case iok || jok:
+			return iok
+		}
+		return s.lset[i].Name < s.lset[j].Name
+	})
+	s.chks = chks
+	return true
+}
+
+func (s *labelReorderSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chks
+}
@@ -0,0 +1,462 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestLabelPrefixFilterSeriesSet(t *testing.T) {
+	input := func() SeriesSet {
+		return newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("__shard", "1", "a", "2"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("__shard", "2", "a", "1"),
+				chunks: [][]sample{{{3, 3}, {4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("__shard", "3", "a", "2"),
+				chunks: [][]sample{{{5, 5}, {6, 6}}},
+			},
+			{
+				lset:   labels.FromStrings("__shard", "4", "b", "1"),
+				chunks: [][]sample{{{7, 7}}},
+			},
+		})
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		s := NewLabelPrefixFilterSeriesSet(input(), "__", false)
+		// Dropping the prefixed labels breaks the sort order and makes two series equal.
+		seriesEquals(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{3, 3}, {4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{5, 5}, {6, 6}}},
+			},
+			{
+				lset:   labels.FromStrings("b", "1"),
+				chunks: [][]sample{{{7, 7}}},
+			},
+		}, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("keep", func(t *testing.T) {
+		s := NewLabelPrefixFilterSeriesSet(input(), "__", true)
+		seriesEquals(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("__shard", "1"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("__shard", "2"),
+				chunks: [][]sample{{{3, 3}, {4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("__shard", "3"),
+				chunks: [][]sample{{{5, 5}, {6, 6}}},
+			},
+			{
+				lset:   labels.FromStrings("__shard", "4"),
+				chunks: [][]sample{{{7, 7}}},
+			},
+		}, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("error", func(t *testing.T) {
+		expectedErr := errors.New("test error")
+		s := NewLabelPrefixFilterSeriesSet(MergeSeriesSets(input(), errSeriesSet{err: expectedErr}), "__", false)
+		testutil.Assert(t, !s.Next(), "expected no series")
+		testutil.Equals(t, expectedErr, s.Err())
+	})
+}
+
+func TestAnnotatedSeriesSet(t *testing.T) {
+	s := NewAnnotatedSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "c", "1"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("__store__", "other", "z", "1"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+	}), []Label{{Name: "zz", Value: "x"}, {Name: "__store__", Value: "gateway-1"}})
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__store__", "gateway-1", "a", "1", "c", "1", "zz", "x"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("__store__", "gateway-1", "a", "1", "zz", "x"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("__store__", "gateway-1", "z", "1", "zz", "x"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestNameCollapsingSeriesSet(t *testing.T) {
+	s := NewNameCollapsingSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "metric_a", "job", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "metric_a", "job", "b"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "metric_b", "job", "a"),
+			chunks: [][]sample{{{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("job", "c"),
+			chunks: [][]sample{{{5, 5}}},
+		},
+	}))
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("job", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("job", "b"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("job", "c"),
+			chunks: [][]sample{{{5, 5}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestMetricRenameSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "a_metric", "job", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "b_metric", "job", "a"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("__name__", "z_metric", "job", "a"),
+			chunks: [][]sample{{{4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("job", "b"),
+			chunks: [][]sample{{{5, 5}}},
+		},
+	}
+
+	t.Run("re-sort", func(t *testing.T) {
+		s := NewMetricRenameSeriesSet(newListSeriesSet(t, in), func(name string) string {
+			return strings.Replace(name, "z_", "0_", 1)
+		})
+		seriesEquals(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("__name__", "0_metric", "job", "a"),
+				chunks: [][]sample{{{4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("__name__", "a_metric", "job", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("__name__", "b_metric", "job", "a"),
+				chunks: [][]sample{{{3, 3}}},
+			},
+			{
+				lset:   labels.FromStrings("job", "b"),
+				chunks: [][]sample{{{5, 5}}},
+			},
+		}, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("collision", func(t *testing.T) {
+		s := NewMetricRenameSeriesSet(newListSeriesSet(t, in), func(name string) string {
+			return strings.TrimPrefix(strings.TrimPrefix(name, "a_"), "b_")
+		})
+		seriesEquals(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("__name__", "metric", "job", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}},
+			},
+			{
+				lset:   labels.FromStrings("__name__", "z_metric", "job", "a"),
+				chunks: [][]sample{{{4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("job", "b"),
+				chunks: [][]sample{{{5, 5}}},
+			},
+		}, s)
+		testutil.Ok(t, s.Err())
+	})
+}
+
+func TestLabelValueRewriteSeriesSet(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("instance", "a", "job", "x"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "new-b", "job", "x"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "old-b", "job", "x"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "old-c", "job", "old-c"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	})
+	s := NewLabelValueRewriteSeriesSet(l, map[string]map[string]string{
+		"instance": {"old-b": "new-b", "old-c": "0"},
+	})
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("instance", "0", "job", "old-c"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "a", "job", "x"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "new-b", "job", "x"),
+			chunks: [][]sample{{{3, 3}}, {{1, 1}, {2, 2}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+
+	// The input labels are not modified.
+	testutil.Equals(t, "old-b", l.series[2].Labels[0].Value)
+}
+
+func TestDuplicatePairDropSeriesSet(t *testing.T) {
+	s := NewDuplicatePairDropSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.Labels{{Name: "a", Value: "1"}, {Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			// Equal names with different values are kept.
+			lset:   labels.Labels{{Name: "a", Value: "1"}, {Name: "a", Value: "2"}},
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "b", "1"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+	}))
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.Labels{{Name: "a", Value: "1"}, {Name: "a", Value: "2"}},
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "b", "1"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1", "b", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestFuzzyGroupSeriesSet(t *testing.T) {
+	s := NewFuzzyGroupSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("env", "dev", "instance", "a", "job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("env", "prod", "instance", "b", "job", "api"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("env", "prod", "instance", "c", "job", "api"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("env", "prod", "instance", "a", "job", "db"),
+			chunks: [][]sample{{{4, 4}}},
+		},
+	}), []string{"instance"})
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("env", "prod", "instance", "b", "job", "api"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("env", "prod", "instance", "c", "job", "api"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "a"),
+			chunks: [][]sample{{{1, 1}}, {{4, 4}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+
+	// Series sharing more than the key keep all common labels.
+	s = NewFuzzyGroupSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("env", "prod", "instance", "a"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("env", "dev", "instance", "b"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("env", "prod", "instance", "c"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+	}), []string{"env"})
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("env", "dev", "instance", "b"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("env", "prod"),
+			chunks: [][]sample{{{1, 1}}, {{3, 3}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestSummarizingSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("instance", "a", "job", "api"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "a", "job", "db"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "b", "job", "api"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "c", "job", "api"),
+			chunks: [][]sample{{{4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("instance", "d", "job", "api"),
+			chunks: [][]sample{{{5, 5}}},
+		},
+	}
+
+	s, err := NewSummarizingSeriesSet(newListSeriesSet(t, in), []string{"job"}, 2)
+	testutil.Ok(t, err)
+	seriesEquals(t, []rawSeries{
+		{lset: labels.FromStrings(SummaryLabel, "... and 2 more", "job", "api")},
+		in[0],
+		in[1],
+		in[2],
+	}, s)
+	testutil.Ok(t, s.Err())
+
+	s, err = NewSummarizingSeriesSet(newListSeriesSet(t, in), []string{"job"}, 4)
+	testutil.Ok(t, err)
+	seriesEquals(t, in, s)
+	testutil.Ok(t, s.Err())
+
+	s, err = NewSummarizingSeriesSet(newListSeriesSet(t, in), []string{"job"}, 0)
+	testutil.Ok(t, err)
+	seriesEquals(t, in, s)
+	testutil.Ok(t, s.Err())
+
+	_, err = NewSummarizingSeriesSet(newListSeriesSet(t, in), []string{"job"}, -1)
+	testutil.NotOk(t, err)
+}
+
+func TestLabelReorderSeriesSet(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("__name__", "up", "env", "prod", "instance", "a", "job", "api", "zone", "z1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("b", "1", "a", "2"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+	})
+	s := NewLabelReorderSeriesSet(l, []string{"job", "__name__", "missing", "instance"})
+
+	testutil.Assert(t, s.Next(), "expected first series")
+	lset, chks := s.At()
+	testutil.Equals(t, []Label{
+		{Name: "job", Value: "api"},
+		{Name: "__name__", Value: "up"},
+		{Name: "instance", Value: "a"},
+		{Name: "env", Value: "prod"},
+		{Name: "zone", Value: "z1"},
+	}, lset)
+	testutil.Equals(t, 1, len(chks))
+	// The wrapped set is not modified.
+	testutil.Equals(t, "__name__", l.series[0].Labels[0].Name)
+
+	testutil.Assert(t, s.Next(), "expected second series")
+	lset, _ = s.At()
+	testutil.Equals(t, []Label{{Name: "a", Value: "2"}, {Name: "b", Value: "1"}}, lset)
+
+	testutil.Assert(t, !s.Next(), "expected end of series set")
+	testutil.Ok(t, s.Err())
+}
+
+func TestRelabelSeriesSet_ReusedBuffers(t *testing.T) {
+	// Labels pointing to a buffer which is reused for every series.
+	buf := []byte("a1")
+	var series []Series
+	for i := 0; i < 3; i++ {
+		series = append(series, Series{Labels: LabelsFromBytes([][2][]byte{{buf[:1], buf[1:]}})})
+	}
+	s := NewLabelPrefixFilterSeriesSet(&bufferReusingSeriesSet{SeriesSet: &listSeriesSet{series: series, idx: -1}, buf: buf, values: []byte("123")}, "__", false)
+
+	var got []string
+	for s.Next() {
+		lset, _ := s.At()
+		got = append(got, lset[0].Value)
+	}
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, []string{"1", "2", "3"}, got)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// samplesPerChunk is the number of samples per chunk of re-encoded series, as used by the TSDB head.
+const samplesPerChunk = 120
+
+// SampleKind classifies sample values which need special handling when exported.
+type SampleKind int
+
+const (
+	// SampleNormal is a finite value.
+	SampleNormal SampleKind = iota
+	// SampleStaleNaN is the special NaN value Prometheus uses as staleness marker.
+	SampleStaleNaN
+	// SampleNaN is any other NaN value.
+	SampleNaN
+	// SamplePosInf is positive infinity.
+	SamplePosInf
+	// SampleNegInf is negative infinity.
+	SampleNegInf
+)
+
+func (k SampleKind) String() string {
+	switch k {
+	case SampleNormal:
+		return "normal"
+	case SampleStaleNaN:
+		return "stale NaN"
+	case SampleNaN:
+		return "NaN"
+	case SamplePosInf:
+		return "+Inf"
+	case SampleNegInf:
+		return "-Inf"
+	}
+	return "unknown"
+}
+
+// ClassifySample returns the kind of the given sample value.
+func ClassifySample(v float64) SampleKind {
+	switch {
+	case value.IsStaleNaN(v):
+		return SampleStaleNaN
+	case math.IsNaN(v):
+		return SampleNaN
+	case math.IsInf(v, 1):
+		return SamplePosInf
+	case math.IsInf(v, -1):
+		return SampleNegInf
+	}
+	return SampleNormal
+}
+
+// FormatSampleValue formats the sample value for text and JSON output the same way the Prometheus HTTP API does,
+// i.e. "NaN", "+Inf" and "-Inf" for special values. Staleness markers are formatted as "NaN".
+func FormatSampleValue(v float64) string {
+	switch ClassifySample(v) {
+	case SampleStaleNaN, SampleNaN:
+		return "NaN"
+	case SamplePosInf:
+		return "+Inf"
+	case SampleNegInf:
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// DropStaleNaNs removes staleness markers from the samples in place and returns the shortened slice.
+// Other NaN values are kept.
+func DropStaleNaNs(samples []Sample) []Sample {
+	ret := samples[:0]
+	for _, s := range samples {
+		if ClassifySample(s.V) != SampleStaleNaN {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// encodeSamples encodes the sorted samples into XOR chunks of at most maxSamples samples.
+func encodeSamples(samples []Sample, maxSamples int) ([]AggrChunk, error) {
+	var ret []AggrChunk
+	for len(samples) > 0 {
+		n := maxSamples
+		if n > len(samples) {
+			n = len(samples)
+		}
+
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		if err != nil {
+			return nil, err
+		}
+		for _, smpl := range samples[:n] {
+			app.Append(smpl.T, smpl.V)
+		}
+		ret = append(ret, AggrChunk{
+			MinTime: samples[0].T,
+			MaxTime: samples[n-1].T,
+			Raw:     &Chunk{Type: Chunk_XOR, Data: c.Bytes()},
+		})
+		samples = samples[n:]
+	}
+	return ret, nil
+}
@@ -0,0 +1,45 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestClassifySample(t *testing.T) {
+	for _, tcase := range []struct {
+		v        float64
+		kind     SampleKind
+		expected string
+	}{
+		{v: 0, kind: SampleNormal, expected: "0"},
+		{v: -1.5, kind: SampleNormal, expected: "-1.5"},
+		{v: 1e21, kind: SampleNormal, expected: "1000000000000000000000"},
+		{v: math.Float64frombits(value.StaleNaN), kind: SampleStaleNaN, expected: "NaN"},
+		{v: math.NaN(), kind: SampleNaN, expected: "NaN"},
+		{v: math.Inf(1), kind: SamplePosInf, expected: "+Inf"},
+		{v: math.Inf(-1), kind: SampleNegInf, expected: "-Inf"},
+	} {
+		t.Run(tcase.kind.String(), func(t *testing.T) {
+			testutil.Equals(t, tcase.kind, ClassifySample(tcase.v))
+			testutil.Equals(t, tcase.expected, FormatSampleValue(tcase.v))
+		})
+	}
+}
+
+func TestDropStaleNaNs(t *testing.T) {
+	stale := math.Float64frombits(value.StaleNaN)
+	got := DropStaleNaNs([]Sample{{T: 1, V: 1}, {T: 2, V: stale}, {T: 3, V: math.Inf(1)}, {T: 4, V: stale}})
+	testutil.Equals(t, []Sample{{T: 1, V: 1}, {T: 3, V: math.Inf(1)}}, got)
+	testutil.Equals(t, 0, len(DropStaleNaNs(nil)))
+
+	// Regular NaNs are not dropped.
+	got = DropStaleNaNs([]Sample{{T: 1, V: math.NaN()}})
+	testutil.Equals(t, 1, len(got))
+	testutil.Equals(t, SampleNaN, ClassifySample(got[0].V))
+}
@@ -0,0 +1,25 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"github.com/pkg/errors"
+)
+
+// SendSeriesSet sends all series of the set to the Series stream. With skipChunks, as requested by
+// SeriesRequest.SkipChunks, only deduplicated labels are sent, see NewLabelsOnlySeriesSet. Stores which can avoid
+// fetching chunk data altogether should do so and pass a set without chunks.
+func SendSeriesSet(srv Store_SeriesServer, s SeriesSet, skipChunks bool) error {
+	if skipChunks {
+		s = NewLabelsOnlySeriesSet(s)
+	}
+	for s.Next() {
+		var series Series
+		series.Labels, series.Chunks = s.At()
+		if err := srv.Send(NewSeriesResponse(&series)); err != nil {
+			return errors.Wrap(err, "send series response")
+		}
+	}
+	return errors.Wrap(s.Err(), "expand series set")
+}
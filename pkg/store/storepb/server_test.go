@@ -0,0 +1,71 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"google.golang.org/grpc"
+)
+
+type testSeriesServer struct {
+	grpc.ServerStream
+
+	responses []*SeriesResponse
+	err       error
+}
+
+func (s *testSeriesServer) Send(r *SeriesResponse) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.responses = append(s.responses, r)
+	return nil
+}
+
+func TestSendSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}
+
+	t.Run("with chunks", func(t *testing.T) {
+		l := newListSeriesSet(t, in)
+		srv := &testSeriesServer{}
+		testutil.Ok(t, SendSeriesSet(srv, l, false))
+		testutil.Equals(t, 3, len(srv.responses))
+		for i, r := range srv.responses {
+			testutil.Equals(t, l.series[i], *r.GetSeries())
+		}
+	})
+	t.Run("skip chunks", func(t *testing.T) {
+		srv := &testSeriesServer{}
+		testutil.Ok(t, SendSeriesSet(srv, newListSeriesSet(t, in), true))
+		testutil.Equals(t, []*SeriesResponse{
+			NewSeriesResponse(&Series{Labels: []Label{{Name: "a", Value: "1"}}}),
+			NewSeriesResponse(&Series{Labels: []Label{{Name: "a", Value: "2"}}}),
+		}, srv.responses)
+	})
+	t.Run("send error", func(t *testing.T) {
+		err := SendSeriesSet(&testSeriesServer{err: errors.New("broken pipe")}, newListSeriesSet(t, in), false)
+		testutil.NotOk(t, err)
+	})
+	t.Run("set error", func(t *testing.T) {
+		err := SendSeriesSet(&testSeriesServer{}, errSeriesSet{err: errors.New("test")}, true)
+		testutil.NotOk(t, err)
+	})
+}
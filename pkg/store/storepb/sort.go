@@ -0,0 +1,133 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"sort"
+)
+
+// NewChunkSortedSeriesSet returns a series set with the chunks of each series sorted by MinTime, and MaxTime for
+// equal MinTime. Unlike the merge, which only sorts chunks on a best-effort basis, this guarantees sorted chunks
+// e.g. for PromQL. Sorting is stable and done per series, so memory is bounded by the widest series.
+func NewChunkSortedSeriesSet(s SeriesSet) SeriesSet {
+	return &chunkSortingSeriesSet{SeriesSet: s, less: chunkMinTimeLess}
+}
+
+func chunkMinTimeLess(a, b AggrChunk) bool {
+	if a.MinTime != b.MinTime {
+		return a.MinTime < b.MinTime
+	}
+	return a.MaxTime < b.MaxTime
+}
+
+// NewMaxTimeSortedChunkSeriesSet works like NewChunkSortedSeriesSet, but sorts chunks by MaxTime, and MinTime for
+// equal MaxTime, e.g. for processing windows by their end time. Note that the merge and PromQL expect chunks sorted
+// by MinTime.
+func NewMaxTimeSortedChunkSeriesSet(s SeriesSet) SeriesSet {
+	return &chunkSortingSeriesSet{SeriesSet: s, less: chunkMaxTimeLess}
+}
+
+func chunkMaxTimeLess(a, b AggrChunk) bool {
+	if a.MaxTime != b.MaxTime {
+		return a.MaxTime < b.MaxTime
+	}
+	return a.MinTime < b.MinTime
+}
+
+type chunkSortingSeriesSet struct {
+	SeriesSet
+
+	less   func(a, b AggrChunk) bool
+	lset   []Label
+	chunks []AggrChunk
+}
+
+func (s *chunkSortingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.lset, s.chunks = s.SeriesSet.At()
+	s.chunks, _ = sortedChunks(s.chunks, s.less)
+	return true
+}
+
+func (s *chunkSortingSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
+
+// sortedChunks returns the chunks sorted by less and whether they had to be sorted. The input is returned as is if
+// it is already sorted, otherwise a sorted copy is returned, so that the input is never modified.
+func sortedChunks(chks []AggrChunk, less func(a, b AggrChunk) bool) ([]AggrChunk, bool) {
+	if sort.SliceIsSorted(chks, func(i, j int) bool { return less(chks[i], chks[j]) }) {
+		return chks, false
+	}
+	ret := make([]AggrChunk, len(chks))
+	copy(ret, chks)
+	sort.SliceStable(ret, func(i, j int) bool { return less(ret[i], ret[j]) })
+	return ret, true
+}
+
+// NewRepairingSeriesSet returns a series set which sorts the chunks of a series by MinTime only when they are out
+// of order, instead of failing on it, e.g. to keep queries working against slightly misbehaving stores. The
+// returned function reports the number of series which needed repair so far. Repair is per series, so memory is
+// bounded by the widest series.
+func NewRepairingSeriesSet(s SeriesSet) (SeriesSet, func() int) {
+	r := &repairingSeriesSet{SeriesSet: s}
+	return r, func() int { return r.repaired }
+}
+
+type repairingSeriesSet struct {
+	SeriesSet
+
+	lset     []Label
+	chunks   []AggrChunk
+	repaired int
+}
+
+func (s *repairingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	var repaired bool
+	s.lset, s.chunks = s.SeriesSet.At()
+	s.chunks, repaired = sortedChunks(s.chunks, chunkMinTimeLess)
+	if repaired {
+		s.repaired++
+	}
+	return true
+}
+
+func (s *repairingSeriesSet) At() ([]Label, []AggrChunk) {
+	return s.lset, s.chunks
+}
+
+// sortedLabels returns the labels sorted by name. The input is returned as is if it is already sorted, otherwise
+// a sorted copy is returned, so that the input is never modified.
+func sortedLabels(lset []Label) []Label {
+	if LabelsAreSorted(lset) {
+		return lset
+	}
+	ret := make([]Label, len(lset))
+	copy(ret, lset)
+	SortLabels(ret)
+	return ret
+}
+
+// SortLabels sorts the labels in place by name, which is the canonical order of label sets that e.g. the merge and
+// the conversion to Prometheus labels rely on. Like in Prometheus, values are not compared, as a valid label set
+// never contains the same name twice.
+func SortLabels(lset []Label) {
+	sort.Sort(labelsByName(lset))
+}
+
+// LabelsAreSorted returns whether the labels are sorted by name, see SortLabels.
+func LabelsAreSorted(lset []Label) bool {
+	return sort.IsSorted(labelsByName(lset))
+}
+
+type labelsByName []Label
+
+func (ls labelsByName) Len() int           { return len(ls) }
+func (ls labelsByName) Swap(i, j int)      { ls[i], ls[j] = ls[j], ls[i] }
+func (ls labelsByName) Less(i, j int) bool { return ls[i].Name < ls[j].Name }
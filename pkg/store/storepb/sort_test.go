@@ -0,0 +1,143 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestChunkSortedSeriesSet(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{7, 1}, {8, 2}}, {{1, 1}, {5, 2}}, {{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+	})
+	s := NewChunkSortedSeriesSet(l)
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{1, 1}, {5, 2}}, {{3, 3}, {4, 4}}, {{7, 1}, {8, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+
+	// The input chunks are not modified.
+	testutil.Equals(t, int64(7), l.series[0].Chunks[0].MinTime)
+}
+
+func TestMaxTimeSortedChunkSeriesSet(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a", "b", "1"),
+			chunks: [][]sample{{{7, 1}, {8, 2}}, {{1, 1}, {10, 2}}, {{3, 3}, {5, 4}}, {{1, 1}, {5, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+	})
+	s := NewMaxTimeSortedChunkSeriesSet(l)
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset: labels.FromStrings("a", "a", "b", "1"),
+			// Unlike with MinTime order, the long chunk starting at 1 comes last.
+			chunks: [][]sample{{{1, 1}, {5, 2}}, {{3, 3}, {5, 4}}, {{7, 1}, {8, 2}}, {{1, 1}, {10, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+}
+
+func TestRepairingSeriesSet(t *testing.T) {
+	s, repaired := NewRepairingSeriesSet(newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{3, 3}, {4, 4}}, {{1, 1}, {2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "c"),
+			chunks: [][]sample{{{5, 5}}, {{1, 1}}, {{3, 3}}},
+		},
+	}))
+	testutil.Equals(t, 0, repaired())
+
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "c"),
+			chunks: [][]sample{{{1, 1}}, {{3, 3}}, {{5, 5}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+	testutil.Equals(t, 2, repaired())
+}
+
+func TestSortLabels(t *testing.T) {
+	var sorted []Label
+	for i := 0; i < 20; i++ {
+		sorted = append(sorted, Label{Name: fmt.Sprintf("n%02d", i), Value: fmt.Sprintf("v%d", 20-i)})
+	}
+	reversed := make([]Label, len(sorted))
+	for i, l := range sorted {
+		reversed[len(sorted)-1-i] = l
+	}
+	shuffled := append([]Label(nil), sorted...)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	for _, tcase := range []struct {
+		desc   string
+		input  []Label
+		sorted bool
+	}{
+		{desc: "nil", sorted: true},
+		{desc: "single label", input: []Label{{Name: "a", Value: "1"}}, sorted: true},
+		{desc: "already sorted", input: append([]Label(nil), sorted...), sorted: true},
+		{desc: "reversed", input: reversed},
+		{desc: "shuffled", input: shuffled},
+		// Only names are compared.
+		{desc: "values not sorted", input: []Label{{Name: "a", Value: "2"}, {Name: "b", Value: "1"}}, sorted: true},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			testutil.Equals(t, tcase.sorted, LabelsAreSorted(tcase.input))
+
+			expected := append([]Label(nil), tcase.input...)
+			sort.SliceStable(expected, func(i, j int) bool { return expected[i].Name < expected[j].Name })
+
+			SortLabels(tcase.input)
+			testutil.Assert(t, LabelsAreSorted(tcase.input), "expected sorted labels")
+			testutil.Equals(t, expected, tcase.input)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// SegmentLabel is the name of the label holding the segment index of series split by
+// NewValueThresholdSplitSeriesSet.
+const SegmentLabel = "__segment__"
+
+// NewValueThresholdSplitSeriesSet returns a series set which splits every series into segments wherever the
+// absolute difference between two consecutive sample values exceeds threshold, e.g. to analyse regime changes of
+// a series separately. Each segment is a series of its own, tagged with its zero-based index in the SegmentLabel
+// label and re-encoded into new XOR chunks. Series without samples are returned unchanged.
+//
+// Only raw chunks are supported, the set fails on any chunk without raw data. Chunks are expected to be sorted by
+// MinTime and not to overlap. Since the segment label changes the order of series, all series are buffered and
+// sorted on the first Next() call, so memory usage is proportional to the whole set.
+func NewValueThresholdSplitSeriesSet(s SeriesSet, threshold float64) (SeriesSet, error) {
+	if threshold < 0 || math.IsNaN(threshold) {
+		return nil, errors.Errorf("invalid threshold %v", threshold)
+	}
+	return &relabelSeriesSet{load: func() ([]Series, error) { return splitAndSort(s, threshold) }, idx: -1}, nil
+}
+
+func splitAndSort(set SeriesSet, threshold float64) ([]Series, error) {
+	var ret []Series
+	for set.Next() {
+		x := deepCopySeries(set.At())
+		lset, chks := x.Labels, x.Chunks
+
+		var samples []Sample
+		for i, c := range chks {
+			if c.Raw == nil {
+				return nil, errors.Errorf("series %s: chunk %d is not a raw chunk", LabelsToString(lset), i)
+			}
+			s, err := c.Raw.Samples()
+			if err != nil {
+				return nil, errors.Wrapf(err, "series %s chunk %d", LabelsToString(lset), i)
+			}
+			samples = append(samples, s...)
+		}
+		if len(samples) == 0 {
+			ret = append(ret, Series{Labels: lset, Chunks: chks})
+			continue
+		}
+
+		start, segments := 0, 0
+		for i := 1; i <= len(samples); i++ {
+			if i < len(samples) && math.Abs(samples[i].V-samples[i-1].V) <= threshold {
+				continue
+			}
+			segment, err := encodeSamples(samples[start:i], samplesPerChunk)
+			if err != nil {
+				return nil, errors.Wrapf(err, "encode series %s", LabelsToString(lset))
+			}
+			ret = append(ret, Series{Labels: withSegmentLabel(lset, segments), Chunks: segment})
+			start, segments = i, segments+1
+		}
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return CompareLabels(ret[i].Labels, ret[j].Labels) < 0
+	})
+	return ret, nil
+}
+
+// withSegmentLabel returns a sorted copy of lset with the SegmentLabel set to the given index.
+func withSegmentLabel(lset []Label, segment int) []Label {
+	ret := make([]Label, 0, len(lset)+1)
+	for _, l := range lset {
+		if l.Name != SegmentLabel {
+			ret = append(ret, l)
+		}
+	}
+	ret = append(ret, Label{Name: SegmentLabel, Value: strconv.Itoa(segment)})
+	SortLabels(ret)
+	return ret
+}
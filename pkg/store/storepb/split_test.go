@@ -0,0 +1,61 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestValueThresholdSplitSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			// A jump from 3 to 100 in the middle of the second chunk.
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 100}, {5, 101}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}, {2, 6}}},
+		},
+	}
+
+	s, err := NewValueThresholdSplitSeriesSet(newListSeriesSet(t, in), 5)
+	testutil.Ok(t, err)
+	seriesEquals(t, []rawSeries{
+		{
+			lset:   labels.FromStrings(SegmentLabel, "0", "a", "1"),
+			chunks: [][]sample{{{1, 1}, {2, 2}, {3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings(SegmentLabel, "0", "a", "2"),
+			chunks: [][]sample{{{1, 1}, {2, 6}}},
+		},
+		{
+			lset:   labels.FromStrings(SegmentLabel, "1", "a", "1"),
+			chunks: [][]sample{{{4, 100}, {5, 101}}},
+		},
+	}, s)
+	testutil.Ok(t, s.Err())
+
+	t.Run("aggregated chunk", func(t *testing.T) {
+		l := newListSeriesSet(t, in)
+		l.series[0].Chunks[1].Count, l.series[0].Chunks[1].Raw = l.series[0].Chunks[1].Raw, nil
+
+		s, err := NewValueThresholdSplitSeriesSet(l, 5)
+		testutil.Ok(t, err)
+		testutil.Assert(t, !s.Next(), "expected no series")
+		testutil.NotOk(t, s.Err())
+	})
+
+	t.Run("invalid threshold", func(t *testing.T) {
+		_, err := NewValueThresholdSplitSeriesSet(EmptySeriesSet(), -1)
+		testutil.NotOk(t, err)
+		_, err = NewValueThresholdSplitSeriesSet(EmptySeriesSet(), math.NaN())
+		testutil.NotOk(t, err)
+	})
+}
@@ -0,0 +1,233 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"io"
+
+	protoio "github.com/gogo/protobuf/io"
+	"github.com/pkg/errors"
+)
+
+// SeriesResponseWriter writes series responses as length delimited protobuf frames, e.g. to record StoreAPI
+// traffic for later replay. Frames are prefixed with their uvarint encoded length and can be read back with
+// SeriesResponseReader.
+type SeriesResponseWriter struct {
+	w protoio.WriteCloser
+}
+
+// NewSeriesResponseWriter returns a writer of series responses to w.
+func NewSeriesResponseWriter(w io.Writer) *SeriesResponseWriter {
+	return &SeriesResponseWriter{w: protoio.NewDelimitedWriter(w)}
+}
+
+// Write writes a single response.
+func (w *SeriesResponseWriter) Write(r *SeriesResponse) error {
+	return errors.Wrap(w.w.WriteMsg(r), "write series response")
+}
+
+// Close closes the underlying writer if it implements io.Closer.
+func (w *SeriesResponseWriter) Close() error {
+	return w.w.Close()
+}
+
+// SeriesResponseReader reads series responses written by SeriesResponseWriter.
+type SeriesResponseReader struct {
+	r protoio.ReadCloser
+}
+
+// NewSeriesResponseReader returns a reader of series responses from r.
+// Frames larger than maxSize bytes are rejected.
+func NewSeriesResponseReader(r io.Reader, maxSize int) *SeriesResponseReader {
+	return &SeriesResponseReader{r: protoio.NewDelimitedReader(r, maxSize)}
+}
+
+// Read reads the next response. It returns io.EOF if there are no more responses.
+func (r *SeriesResponseReader) Read() (*SeriesResponse, error) {
+	resp := &SeriesResponse{}
+	if err := r.r.ReadMsg(resp); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "read series response")
+	}
+	return resp, nil
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (r *SeriesResponseReader) Close() error {
+	return r.r.Close()
+}
+
+// Snapshots start with snapshotMagic followed by a single byte format version.
+const (
+	snapshotMagic   = "THSS"
+	snapshotVersion = 1
+
+	// snapshotMaxFrameSize bounds the size of a single series in a snapshot, to fail early on corrupted input.
+	snapshotMaxFrameSize = 1 << 30
+)
+
+// WriteSeriesSetSnapshot drains s and writes all of its series to w, so that they can be replayed with
+// ReadSeriesSetSnapshot, e.g. to capture real query results as test corpora. Warnings of a WarningsSeriesSet are
+// written after the series. The snapshot consists of a magic string and a version byte, followed by series and
+// warning responses in the format of SeriesResponseWriter.
+func WriteSeriesSetSnapshot(w io.Writer, s SeriesSet) error {
+	if _, err := w.Write(append([]byte(snapshotMagic), snapshotVersion)); err != nil {
+		return errors.Wrap(err, "write snapshot header")
+	}
+
+	sw := NewSeriesResponseWriter(w)
+	for s.Next() {
+		lset, chks := s.At()
+		if err := sw.Write(NewSeriesResponse(&Series{Labels: lset, Chunks: chks})); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return errors.Wrap(err, "iterate series set")
+	}
+
+	if ws, ok := s.(WarningsSeriesSet); ok {
+		for _, warn := range ws.Warnings() {
+			if err := sw.Write(NewWarnSeriesResponse(errors.New(warn))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadSeriesSetSnapshot reads a snapshot written by WriteSeriesSetSnapshot. The whole snapshot is read upfront, so
+// errors are returned right away. The returned set replays the series in their original order and is a
+// WarningsSeriesSet returning the warnings of the snapshot.
+func ReadSeriesSetSnapshot(r io.Reader) (SeriesSet, error) {
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.Wrap(err, "read snapshot header")
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a series set snapshot")
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return nil, errors.Errorf("unsupported snapshot version %d", v)
+	}
+
+	var (
+		series []Series
+		warns  []string
+		sr     = NewSeriesResponseReader(r, snapshotMaxFrameSize)
+	)
+	for {
+		resp, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch res := resp.Result.(type) {
+		case *SeriesResponse_Series:
+			series = append(series, *res.Series)
+		case *SeriesResponse_Warning:
+			warns = append(warns, res.Warning)
+		default:
+			return nil, errors.Errorf("unexpected snapshot response %T", res)
+		}
+	}
+
+	return &warningsSeriesSet{SeriesSet: newSliceSeriesSet(series), warns: warns}, nil
+}
+
+// NewResponseSeriesSet returns a series set of the series of the given responses and all of their warnings, e.g.
+// to feed recorded responses into the merge in tests. Hints are dropped. The series are returned in the order of
+// the responses, so they have to be sorted already.
+func NewResponseSeriesSet(resps []*SeriesResponse) (SeriesSet, []string) {
+	var (
+		series []Series
+		warns  []string
+	)
+	for _, r := range resps {
+		switch res := r.Result.(type) {
+		case *SeriesResponse_Series:
+			series = append(series, *res.Series)
+		case *SeriesResponse_Warning:
+			warns = append(warns, res.Warning)
+		}
+	}
+	return newSliceSeriesSet(series), warns
+}
+
+// newSliceSeriesSet returns a series set of the given series.
+func newSliceSeriesSet(series []Series) SeriesSet {
+	return &relabelSeriesSet{load: func() ([]Series, error) { return series, nil }, idx: -1}
+}
+
+// NewRecordingSeriesSet returns a series set which passes all series of s through unchanged and records deep copies
+// of them, e.g. to capture the output of a live query and replay it verbatim in a test. The returned function yields
+// the recording of all series returned so far and the error of s, so it is only complete once the set is drained.
+func NewRecordingSeriesSet(s SeriesSet) (SeriesSet, func() *RecordedSeriesSet) {
+	r := &recordingSeriesSet{SeriesSet: s}
+	return r, func() *RecordedSeriesSet {
+		return &RecordedSeriesSet{Series: r.series, Err: s.Err()}
+	}
+}
+
+type recordingSeriesSet struct {
+	SeriesSet
+
+	series []Series
+}
+
+func (s *recordingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.series = append(s.series, deepCopySeries(s.SeriesSet.At()))
+	return true
+}
+
+// deepCopySeries returns a copy of the series not sharing any memory with the input, e.g. to buffer series of sets
+// which reuse their buffers on Next().
+func deepCopySeries(lset []Label, chks []AggrChunk) Series {
+	ret := Series{Labels: deepCopyLabels(lset), Chunks: make([]AggrChunk, 0, len(chks))}
+	for _, c := range chks {
+		rc := AggrChunk{MinTime: c.MinTime, MaxTime: c.MaxTime}
+		rc.Raw, rc.Count, rc.Sum = deepCopyChunk(c.Raw), deepCopyChunk(c.Count), deepCopyChunk(c.Sum)
+		rc.Min, rc.Max, rc.Counter = deepCopyChunk(c.Min), deepCopyChunk(c.Max), deepCopyChunk(c.Counter)
+		ret.Chunks = append(ret.Chunks, rc)
+	}
+	return ret
+}
+
+func deepCopyChunk(c *Chunk) *Chunk {
+	if c == nil {
+		return nil
+	}
+	return &Chunk{Type: c.Type, Data: append([]byte(nil), c.Data...)}
+}
+
+// RecordedSeriesSet is the recording of a series set returned by NewRecordingSeriesSet.
+type RecordedSeriesSet struct {
+	// Series are the series returned by the recorded set, in order.
+	Series []Series
+	// Err is the error of the recorded set.
+	Err error
+}
+
+// Replay returns a new series set returning the recorded series, failing with the recorded error once they are
+// exhausted. It can be called any number of times. The series must not be modified while replayed.
+func (r *RecordedSeriesSet) Replay() SeriesSet {
+	return &replaySeriesSet{SeriesSet: newSliceSeriesSet(r.Series), err: r.Err}
+}
+
+type replaySeriesSet struct {
+	SeriesSet
+
+	err error
+}
+
+func (s *replaySeriesSet) Err() error {
+	return s.err
+}
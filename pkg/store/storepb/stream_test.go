@@ -0,0 +1,215 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSeriesResponseWriterReader(t *testing.T) {
+	s1 := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}})
+	s2 := newSeries(t, labels.FromStrings("a", "2"), [][]sample{{{1, 1}}})
+	responses := []*SeriesResponse{
+		NewSeriesResponse(&s1),
+		NewWarnSeriesResponse(errors.New("partial response")),
+		NewSeriesResponse(&s2),
+		NewHintsSeriesResponse(&types.Any{TypeUrl: "hints", Value: []byte{1, 2, 3}}),
+	}
+
+	var buf bytes.Buffer
+	w := NewSeriesResponseWriter(&buf)
+	for _, r := range responses {
+		testutil.Ok(t, w.Write(r))
+	}
+	testutil.Ok(t, w.Close())
+
+	r := NewSeriesResponseReader(bytes.NewReader(buf.Bytes()), 1024)
+	for _, exp := range responses {
+		got, err := r.Read()
+		testutil.Ok(t, err)
+		testutil.Equals(t, exp, got)
+	}
+	_, err := r.Read()
+	testutil.Equals(t, io.EOF, err)
+	testutil.Ok(t, r.Close())
+
+	t.Run("truncated", func(t *testing.T) {
+		r := NewSeriesResponseReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), 1024)
+		for range responses[:len(responses)-1] {
+			_, err := r.Read()
+			testutil.Ok(t, err)
+		}
+		_, err := r.Read()
+		testutil.NotOk(t, err)
+		testutil.Assert(t, err != io.EOF, "expected error other than EOF")
+	})
+	t.Run("frame too large", func(t *testing.T) {
+		r := NewSeriesResponseReader(bytes.NewReader(buf.Bytes()), 2)
+		_, err := r.Read()
+		testutil.NotOk(t, err)
+	})
+}
+
+func TestSeriesSetSnapshot(t *testing.T) {
+	input := func() SeriesSet {
+		s := newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2", "b", "1"),
+				chunks: [][]sample{{{1, 1}}},
+			},
+		})
+		raw := s.series[1].Chunks[0].Raw
+		s.series[1].Chunks = append(s.series[1].Chunks,
+			AggrChunk{MinTime: 5, MaxTime: 10, Count: raw, Sum: raw, Counter: raw},
+			AggrChunk{MinTime: 11, MaxTime: 11, Raw: &Chunk{Type: Chunk_DOUBLE_DELTA, Data: doubleDeltaData(1, 4, false, 11, 1, 0, 0)}},
+		)
+		s.series = append(s.series, Series{Labels: []Label{{Name: "a", Value: "3"}}})
+		return MergeSeriesSetsWithStrategy(PartialResponseStrategy_WARN, s, errSeriesSet{err: errors.New("store down")})
+	}
+
+	var buf bytes.Buffer
+	testutil.Ok(t, WriteSeriesSetSnapshot(&buf, input()))
+
+	got, err := ReadSeriesSetSnapshot(bytes.NewReader(buf.Bytes()))
+	testutil.Ok(t, err)
+
+	exp := input()
+	for exp.Next() {
+		testutil.Assert(t, got.Next(), "expected more series")
+		expLset, expChks := exp.At()
+		gotLset, gotChks := got.At()
+		testutil.Equals(t, expLset, gotLset)
+		testutil.Equals(t, len(expChks), len(gotChks))
+		for i := range expChks {
+			testutil.Equals(t, expChks[i], gotChks[i])
+		}
+	}
+	testutil.Assert(t, !got.Next(), "expected end of stream")
+	testutil.Ok(t, got.Err())
+	testutil.Ok(t, exp.Err())
+
+	testutil.Equals(t, []string{"store down"}, got.(WarningsSeriesSet).Warnings())
+	testutil.Equals(t, exp.(WarningsSeriesSet).Warnings(), got.(WarningsSeriesSet).Warnings())
+
+	t.Run("invalid header", func(t *testing.T) {
+		_, err := ReadSeriesSetSnapshot(bytes.NewReader([]byte("THXX\x01")))
+		testutil.NotOk(t, err)
+
+		b := append([]byte{}, buf.Bytes()...)
+		b[len(snapshotMagic)] = snapshotVersion + 1
+		_, err = ReadSeriesSetSnapshot(bytes.NewReader(b))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "unsupported snapshot version 2", err.Error())
+	})
+	t.Run("truncated", func(t *testing.T) {
+		_, err := ReadSeriesSetSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+		testutil.NotOk(t, err)
+	})
+	t.Run("set error", func(t *testing.T) {
+		testutil.NotOk(t, WriteSeriesSetSnapshot(&bytes.Buffer{}, errSeriesSet{err: errors.New("test")}))
+	})
+}
+
+func TestResponseSeriesSet(t *testing.T) {
+	s1 := newSeries(t, labels.FromStrings("a", "1"), [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}})
+	s2 := newSeries(t, labels.FromStrings("a", "2"), [][]sample{{{1, 1}}})
+	s3 := newSeries(t, labels.FromStrings("a", "3"), [][]sample{{{4, 4}}})
+
+	s, warns := NewResponseSeriesSet([]*SeriesResponse{
+		NewWarnSeriesResponse(errors.New("warning 1")),
+		NewSeriesResponse(&s1),
+		NewHintsSeriesResponse(&types.Any{TypeUrl: "hints"}),
+		NewSeriesResponse(&s2),
+		NewWarnSeriesResponse(errors.New("warning 2")),
+		NewSeriesResponse(&s3),
+	})
+	testutil.Equals(t, []string{"warning 1", "warning 2"}, warns)
+
+	for _, exp := range []Series{s1, s2, s3} {
+		testutil.Assert(t, s.Next(), "expected series")
+		lset, chks := s.At()
+		testutil.Equals(t, exp.Labels, lset)
+		testutil.Equals(t, exp.Chunks, chks)
+	}
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+	testutil.Ok(t, s.Err())
+
+	s, warns = NewResponseSeriesSet(nil)
+	testutil.Equals(t, 0, len(warns))
+	testutil.Assert(t, !s.Next(), "expected end of stream")
+}
+
+func TestRecordingSeriesSet(t *testing.T) {
+	l := newListSeriesSet(t, []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3"),
+			chunks: [][]sample{{{4, 4}}},
+		},
+	})
+	l.series[1].Chunks[0].Count, l.series[1].Chunks[0].Raw = l.series[1].Chunks[0].Raw, nil
+
+	drain := func(s SeriesSet) ([]Series, error) {
+		var ret []Series
+		for s.Next() {
+			lset, chks := s.At()
+			ret = append(ret, Series{Labels: lset, Chunks: chks})
+		}
+		return ret, s.Err()
+	}
+	deepCopy := func(series []Series) []Series {
+		var ret []Series
+		for _, s := range series {
+			b, err := s.Marshal()
+			testutil.Ok(t, err)
+			var c Series
+			testutil.Ok(t, c.Unmarshal(b))
+			ret = append(ret, c)
+		}
+		return ret
+	}
+
+	s, recording := NewRecordingSeriesSet(&failingSeriesSet{SeriesSet: l, n: 2, err: errors.New("failure")})
+	got, err := drain(s)
+	testutil.NotOk(t, err)
+	// Series are passed through as is.
+	testutil.Equals(t, l.series[:2], got)
+	expected := deepCopy(got)
+
+	// The recording does not share memory with the recorded set.
+	l.series[0].Chunks[0].Raw.Data[0]++
+
+	rec := recording()
+	for i := 0; i < 2; i++ {
+		replayed, err := drain(rec.Replay())
+		testutil.Equals(t, expected, replayed)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "failure", err.Error())
+	}
+
+	s, recording = NewRecordingSeriesSet(EmptySeriesSet())
+	_, err = drain(s)
+	testutil.Ok(t, err)
+	replayed, err := drain(recording().Replay())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(replayed))
+}
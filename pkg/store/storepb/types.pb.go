@@ -28,14 +28,18 @@ type Chunk_Encoding int32
 
 const (
 	Chunk_XOR Chunk_Encoding = 0
+	// DOUBLE_DELTA is the legacy Prometheus 1.x double-delta encoding. It is only supported for reading.
+	Chunk_DOUBLE_DELTA Chunk_Encoding = 1
 )
 
 var Chunk_Encoding_name = map[int32]string{
 	0: "XOR",
+	1: "DOUBLE_DELTA",
 }
 
 var Chunk_Encoding_value = map[string]int32{
-	"XOR": 0,
+	"XOR":          0,
+	"DOUBLE_DELTA": 1,
 }
 
 func (x Chunk_Encoding) String() string {
@@ -288,35 +292,36 @@ func init() {
 func init() { proto.RegisterFile("types.proto", fileDescriptor_d938547f84707355) }
 
 var fileDescriptor_d938547f84707355 = []byte{
-	// 445 bytes of a gzipped FileDescriptorProto
+	// 458 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0xcd, 0x6e, 0xd3, 0x40,
-	0x10, 0xc7, 0xbd, 0xfe, 0x4c, 0xa7, 0x05, 0x99, 0xa5, 0x42, 0x5b, 0x0e, 0x6e, 0x64, 0x84, 0x88,
-	0x40, 0xb8, 0xa2, 0x3c, 0x01, 0x45, 0xbe, 0xf1, 0xa1, 0x2e, 0x3d, 0x20, 0x84, 0x84, 0x36, 0xe9,
-	0xe2, 0x58, 0xc4, 0xeb, 0xc8, 0x5e, 0x43, 0xfa, 0x16, 0x20, 0x5e, 0x2a, 0xc7, 0x1e, 0x39, 0x21,
-	0x48, 0x5e, 0x04, 0xed, 0xd8, 0xa6, 0xad, 0xe4, 0xdb, 0xec, 0xfc, 0x7f, 0xf3, 0xa1, 0x9d, 0x3f,
-	0xec, 0xea, 0x8b, 0xa5, 0xac, 0x93, 0x65, 0x55, 0xea, 0x92, 0xfa, 0x7a, 0x2e, 0x54, 0x59, 0xdf,
-	0xdf, 0xcf, 0xca, 0xac, 0xc4, 0xd4, 0x91, 0x89, 0x5a, 0x35, 0x7e, 0x06, 0xde, 0x2b, 0x31, 0x95,
-	0x0b, 0x4a, 0xc1, 0x55, 0xa2, 0x90, 0x8c, 0x8c, 0xc9, 0x64, 0x87, 0x63, 0x4c, 0xf7, 0xc1, 0xfb,
-	0x2a, 0x16, 0x8d, 0x64, 0x36, 0x26, 0xdb, 0x47, 0xfc, 0x11, 0xbc, 0x97, 0xf3, 0x46, 0x7d, 0xa1,
-	0x8f, 0xc1, 0x35, 0x83, 0xb0, 0xe4, 0xf6, 0xf1, 0xbd, 0xa4, 0x1d, 0x94, 0xa0, 0x98, 0xa4, 0x6a,
-	0x56, 0x9e, 0xe7, 0x2a, 0xe3, 0xc8, 0x98, 0xf6, 0xe7, 0x42, 0x0b, 0xec, 0xb4, 0xc7, 0x31, 0x8e,
-	0xef, 0xc2, 0xa8, 0xa7, 0x68, 0x00, 0xce, 0xfb, 0xb7, 0x3c, 0xb4, 0xe2, 0xcf, 0xe0, 0xbf, 0x93,
-	0x55, 0x2e, 0x6b, 0xfa, 0x04, 0xfc, 0x85, 0x59, 0xad, 0x66, 0x64, 0xec, 0x4c, 0x76, 0x8f, 0x6f,
-	0xf5, 0x03, 0x70, 0xe1, 0x13, 0x77, 0xfd, 0xfb, 0xd0, 0xe2, 0x1d, 0x42, 0x8f, 0xc0, 0x9f, 0x99,
-	0xb9, 0x35, 0xb3, 0x11, 0xbe, 0xd3, 0xc3, 0x2f, 0xb2, 0xac, 0xc2, 0x8d, 0xfa, 0x82, 0x16, 0x8b,
-	0x7f, 0xda, 0xb0, 0xf3, 0x5f, 0xa3, 0x07, 0x30, 0x2a, 0x72, 0xf5, 0x49, 0xe7, 0xdd, 0x0f, 0x38,
-	0x3c, 0x28, 0x72, 0x75, 0x96, 0x17, 0x12, 0x25, 0xb1, 0x6a, 0x25, 0xbb, 0x93, 0xc4, 0x0a, 0xa5,
-	0x43, 0x70, 0x2a, 0xf1, 0x8d, 0x39, 0x63, 0x72, 0x7d, 0x3d, 0xec, 0xc8, 0x8d, 0x42, 0x1f, 0x80,
-	0x37, 0x2b, 0x1b, 0xa5, 0x99, 0x3b, 0x84, 0xb4, 0x9a, 0xe9, 0x52, 0x37, 0x05, 0xf3, 0x06, 0xbb,
-	0xd4, 0x4d, 0x61, 0x80, 0x22, 0x57, 0xcc, 0x1f, 0x04, 0x8a, 0x5c, 0x21, 0x20, 0x56, 0x2c, 0x18,
-	0x06, 0xc4, 0x8a, 0x3e, 0x82, 0x00, 0x67, 0xc9, 0x8a, 0x8d, 0x86, 0xa0, 0x5e, 0x8d, 0x7f, 0x10,
-	0xd8, 0xc3, 0xef, 0x7d, 0x2d, 0xf4, 0x6c, 0x2e, 0x2b, 0xfa, 0xf4, 0xc6, 0x8d, 0x0f, 0x6e, 0x9c,
-	0xa0, 0x63, 0x92, 0xb3, 0x8b, 0xa5, 0xbc, 0x3a, 0x33, 0xba, 0xc8, 0x1e, 0x72, 0x91, 0x73, 0xdd,
-	0x45, 0x13, 0x70, 0x4d, 0x1d, 0xf5, 0xc1, 0x4e, 0x4f, 0x43, 0xcb, 0x18, 0xe0, 0x4d, 0x7a, 0x1a,
-	0x12, 0x93, 0xe0, 0x69, 0x68, 0x63, 0x82, 0xa7, 0xa1, 0x73, 0xf2, 0x70, 0xfd, 0x37, 0xb2, 0xd6,
-	0x9b, 0x88, 0x5c, 0x6e, 0x22, 0xf2, 0x67, 0x13, 0x91, 0xef, 0xdb, 0xc8, 0xba, 0xdc, 0x46, 0xd6,
-	0xaf, 0x6d, 0x64, 0x7d, 0x08, 0x6a, 0x5d, 0x56, 0x72, 0x39, 0x9d, 0xfa, 0x68, 0xe8, 0xe7, 0xff,
-	0x02, 0x00, 0x00, 0xff, 0xff, 0x61, 0x46, 0x03, 0x25, 0xfd, 0x02, 0x00, 0x00,
+	0x10, 0xc7, 0xbd, 0xfe, 0x4c, 0xa7, 0x01, 0x99, 0x55, 0x85, 0xb6, 0x1c, 0xdc, 0xc8, 0xa8, 0x22,
+	0x02, 0xe1, 0x8a, 0xf2, 0x04, 0x0d, 0xf5, 0x2d, 0x50, 0xd5, 0x04, 0x09, 0x71, 0xa9, 0x36, 0xe9,
+	0xe2, 0x58, 0xc4, 0xeb, 0xc8, 0x1f, 0x90, 0xbe, 0x05, 0x88, 0x97, 0xca, 0xb1, 0x47, 0x4e, 0x08,
+	0x92, 0x17, 0x41, 0x3b, 0xb6, 0xa1, 0x11, 0xbe, 0xad, 0xe7, 0xff, 0x9b, 0xff, 0x8c, 0x67, 0x06,
+	0xf6, 0xcb, 0x9b, 0xa5, 0x28, 0x82, 0x65, 0x9e, 0x95, 0x19, 0xb5, 0xcb, 0x39, 0x97, 0x59, 0xf1,
+	0xe8, 0x20, 0xce, 0xe2, 0x0c, 0x43, 0x27, 0xea, 0x55, 0xab, 0xfe, 0x0b, 0xb0, 0xc6, 0x7c, 0x2a,
+	0x16, 0x94, 0x82, 0x29, 0x79, 0x2a, 0x18, 0x19, 0x90, 0xe1, 0x5e, 0x84, 0x6f, 0x7a, 0x00, 0xd6,
+	0x67, 0xbe, 0xa8, 0x04, 0xd3, 0x31, 0x58, 0x7f, 0xf8, 0x12, 0xac, 0x57, 0xf3, 0x4a, 0x7e, 0xa2,
+	0x4f, 0xc1, 0x54, 0x85, 0x30, 0xe5, 0xfe, 0xe9, 0xc3, 0xa0, 0x2e, 0x14, 0xa0, 0x18, 0x84, 0x72,
+	0x96, 0x5d, 0x27, 0x32, 0x8e, 0x90, 0x51, 0xf6, 0xd7, 0xbc, 0xe4, 0xe8, 0xd4, 0x8f, 0xf0, 0xed,
+	0x1f, 0x43, 0xaf, 0xa5, 0xa8, 0x03, 0xc6, 0xfb, 0x8b, 0xc8, 0xd5, 0xa8, 0x0b, 0xfd, 0xf3, 0x8b,
+	0x77, 0xa3, 0x71, 0x78, 0x75, 0x1e, 0x8e, 0x27, 0x67, 0x2e, 0xf1, 0x3f, 0x82, 0xfd, 0x56, 0xe4,
+	0x89, 0x28, 0xe8, 0x33, 0xb0, 0x17, 0xaa, 0xd9, 0x82, 0x91, 0x81, 0x31, 0xdc, 0x3f, 0xbd, 0xd7,
+	0x96, 0xc4, 0x5f, 0x18, 0x99, 0xeb, 0x9f, 0x47, 0x5a, 0xd4, 0x20, 0xf4, 0x04, 0xec, 0x99, 0xea,
+	0xa4, 0x60, 0x3a, 0xc2, 0x0f, 0x5a, 0xf8, 0x2c, 0x8e, 0x73, 0xec, 0xb1, 0x4d, 0xa8, 0x31, 0xff,
+	0xbb, 0x0e, 0x7b, 0x7f, 0x35, 0x7a, 0x08, 0xbd, 0x34, 0x91, 0x57, 0x65, 0xd2, 0xcc, 0xc4, 0x88,
+	0x9c, 0x34, 0x91, 0x93, 0x24, 0x15, 0x28, 0xf1, 0x55, 0x2d, 0xe9, 0x8d, 0xc4, 0x57, 0x28, 0x1d,
+	0x81, 0x91, 0xf3, 0x2f, 0xcc, 0x18, 0x90, 0xbb, 0xed, 0xa1, 0x63, 0xa4, 0x14, 0xfa, 0x18, 0xac,
+	0x59, 0x56, 0xc9, 0x92, 0x99, 0x5d, 0x48, 0xad, 0x29, 0x97, 0xa2, 0x4a, 0x99, 0xd5, 0xe9, 0x52,
+	0x54, 0xa9, 0x02, 0xd2, 0x44, 0x32, 0xbb, 0x13, 0x48, 0x13, 0x89, 0x00, 0x5f, 0x31, 0xa7, 0x1b,
+	0xe0, 0x2b, 0xfa, 0x04, 0x1c, 0xac, 0x25, 0x72, 0xd6, 0xeb, 0x82, 0x5a, 0xd5, 0xff, 0x46, 0xa0,
+	0x8f, 0xe3, 0x7d, 0xcd, 0xcb, 0xd9, 0x5c, 0xe4, 0xf4, 0xf9, 0xce, 0xd6, 0x0f, 0x77, 0x56, 0xd0,
+	0x30, 0xc1, 0xe4, 0x66, 0x29, 0xfe, 0x2d, 0x5e, 0xf2, 0x66, 0x50, 0xff, 0xdd, 0x95, 0x71, 0xf7,
+	0xae, 0x86, 0x60, 0xaa, 0x3c, 0x6a, 0x83, 0x1e, 0x5e, 0xba, 0x9a, 0x3a, 0x89, 0x37, 0xe1, 0xa5,
+	0x4b, 0x54, 0x20, 0x0a, 0x5d, 0x1d, 0x03, 0x51, 0xe8, 0x1a, 0xa3, 0xe3, 0xf5, 0x6f, 0x4f, 0x5b,
+	0x6f, 0x3c, 0x72, 0xbb, 0xf1, 0xc8, 0xaf, 0x8d, 0x47, 0xbe, 0x6e, 0x3d, 0xed, 0x76, 0xeb, 0x69,
+	0x3f, 0xb6, 0x9e, 0xf6, 0xc1, 0x29, 0xca, 0x2c, 0x17, 0xcb, 0xe9, 0xd4, 0xc6, 0x13, 0x7f, 0xf9,
+	0x67, 0x00, 0x2a, 0x0e, 0x02, 0xb8, 0x0f, 0x03, 0x00, 0x00,
 }
 
 func (m *Label) Marshal() (dAtA []byte, err error) {
@@ -0,0 +1,514 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// erroringSeriesSet is embedded by series sets which can fail on their own. Once err is set, it is returned by Err
+// in place of the error of the wrapped set.
+type erroringSeriesSet struct {
+	SeriesSet
+
+	err error
+}
+
+func (s *erroringSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.SeriesSet.Err()
+}
+
+// NewMonotonicCheckSeriesSet returns a series set that fails with an error if any raw chunk contains samples
+// with timestamps that are not strictly increasing. It decodes every raw chunk, so it is meant as an opt-in
+// validation tool for new store implementations. Aggregated chunks are skipped.
+func NewMonotonicCheckSeriesSet(s SeriesSet) SeriesSet {
+	return &monotonicCheckSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}
+}
+
+type monotonicCheckSeriesSet struct {
+	erroringSeriesSet
+}
+
+func (s *monotonicCheckSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+	for i, c := range chks {
+		if c.Raw == nil {
+			continue
+		}
+		if err := checkMonotonic(c.Raw); err != nil {
+			s.err = errors.Wrapf(err, "series %s chunk %d", LabelsToString(lset), i)
+			return false
+		}
+	}
+	return true
+}
+
+func checkMonotonic(c *Chunk) error {
+	chk, err := c.Decode()
+	if err != nil {
+		return err
+	}
+
+	it := chk.Iterator(nil)
+	for i, prev := 0, int64(0); it.Next(); i++ {
+		t, _ := it.At()
+		if i > 0 && t <= prev {
+			return errors.Errorf("sample %d timestamp %d is not after previous timestamp %d", i, t, prev)
+		}
+		prev = t
+	}
+	return errors.Wrap(it.Err(), "decode samples")
+}
+
+// NewUniqueChunkTimeSeriesSet returns a series set that fails with an error if any series contains two chunks
+// with the same MinTime. It is meant as an opt-in validation for stores which guarantee one chunk per time bucket,
+// as the merge only removes byte-identical chunks and passes conflicting ones on.
+func NewUniqueChunkTimeSeriesSet(s SeriesSet) SeriesSet {
+	return &uniqueChunkTimeSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}
+}
+
+type uniqueChunkTimeSeriesSet struct {
+	erroringSeriesSet
+}
+
+func (s *uniqueChunkTimeSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	seen := make(map[int64]int, len(chks))
+	for i, c := range chks {
+		if j, ok := seen[c.MinTime]; ok {
+			s.err = errors.Errorf("series %s: chunks %d and %d have the same min time %d", LabelsToString(lset), j, i, c.MinTime)
+			return false
+		}
+		seen[c.MinTime] = i
+	}
+	return true
+}
+
+// NewStrictChunkOrderSeriesSet returns a series set that fails with an error if the chunk MinTimes of any series
+// are not strictly increasing, e.g. to check data before handing it to a consumer which cannot handle overlapping or
+// out of order chunks. Unlike NewUniqueChunkTimeSeriesSet, it requires chunks to be sorted.
+func NewStrictChunkOrderSeriesSet(s SeriesSet) SeriesSet {
+	return &strictChunkOrderSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}
+}
+
+type strictChunkOrderSeriesSet struct {
+	erroringSeriesSet
+}
+
+func (s *strictChunkOrderSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+	for i := 1; i < len(chks); i++ {
+		if chks[i].MinTime <= chks[i-1].MinTime {
+			s.err = errors.Errorf("series %s: chunk %d min time %d is not after chunk %d min time %d", LabelsToString(lset), i, chks[i].MinTime, i-1, chks[i-1].MinTime)
+			return false
+		}
+	}
+	return true
+}
+
+// NewMaxSpanSeriesSet returns a series set that fails with an error if the time span of any series, from the lowest
+// chunk MinTime to the highest chunk MaxTime, exceeds maxSpan. It only checks chunk metadata, so it is cheap enough
+// to guard every query against unbounded time ranges.
+func NewMaxSpanSeriesSet(s SeriesSet, maxSpan int64) SeriesSet {
+	return &maxSpanSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, maxSpan: maxSpan}
+}
+
+type maxSpanSeriesSet struct {
+	erroringSeriesSet
+
+	maxSpan int64
+}
+
+func (s *maxSpanSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+	if len(chks) == 0 {
+		return true
+	}
+
+	mint, maxt := chks[0].MinTime, chks[0].MaxTime
+	for _, c := range chks[1:] {
+		if c.MinTime < mint {
+			mint = c.MinTime
+		}
+		if c.MaxTime > maxt {
+			maxt = c.MaxTime
+		}
+	}
+	if span := maxt - mint; span > s.maxSpan {
+		s.err = errors.Errorf("series %s: time span %d exceeds maximum of %d", LabelsToString(lset), span, s.maxSpan)
+		return false
+	}
+	return true
+}
+
+// NewRangeAssertingSeriesSet returns a series set that fails with an error if any chunk lies entirely outside of
+// [mint, maxt], which means that the store ignored the time range of the request. Unlike filtering such chunks, it
+// surfaces misbehaving stores instead of silently hiding the extra data. Chunks overlapping the range are accepted.
+func NewRangeAssertingSeriesSet(s SeriesSet, mint, maxt int64) SeriesSet {
+	return &rangeAssertingSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, mint: mint, maxt: maxt}
+}
+
+type rangeAssertingSeriesSet struct {
+	erroringSeriesSet
+
+	mint, maxt int64
+}
+
+func (s *rangeAssertingSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+	for i, c := range chks {
+		if c.MaxTime < s.mint || c.MinTime > s.maxt {
+			s.err = errors.Errorf("series %s: chunk %d with time range [%d, %d] is outside of the requested range [%d, %d]",
+				LabelsToString(lset), i, c.MinTime, c.MaxTime, s.mint, s.maxt)
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequireLabelsSeriesSet returns a series set that fails with an error if any series lacks one of the required
+// label names, e.g. the external labels a store is expected to add to all of its series.
+func NewRequireLabelsSeriesSet(s SeriesSet, required []string) SeriesSet {
+	return &requireLabelsSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, required: required}
+}
+
+type requireLabelsSeriesSet struct {
+	erroringSeriesSet
+
+	required []string
+}
+
+func (s *requireLabelsSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, _ := s.SeriesSet.At()
+	for _, n := range s.required {
+		if !hasLabel(lset, n) {
+			s.err = errors.Errorf("series %s: missing required label %q", LabelsToString(lset), n)
+			return false
+		}
+	}
+	return true
+}
+
+func hasLabel(lset []Label, name string) bool {
+	for _, l := range lset {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewUTF8ValidatingSeriesSet returns a series set that fails with an error on the first series with a label name or
+// value which is not valid UTF-8, e.g. to catch corrupt stores before the labels break JSON encoding downstream.
+// The offending series is not returned. See NewUTF8SanitizingSeriesSet for replacing invalid sequences instead.
+func NewUTF8ValidatingSeriesSet(s SeriesSet) SeriesSet {
+	return &utf8ValidatingSeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}}
+}
+
+type utf8ValidatingSeriesSet struct {
+	erroringSeriesSet
+}
+
+func (s *utf8ValidatingSeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, _ := s.SeriesSet.At()
+	for _, l := range lset {
+		if !utf8.ValidString(l.Name) {
+			s.err = errors.Errorf("series %s: label name %q is not valid UTF-8", LabelsToString(lset), l.Name)
+			return false
+		}
+		if !utf8.ValidString(l.Value) {
+			s.err = errors.Errorf("series %s: label %q value %q is not valid UTF-8", LabelsToString(lset), l.Name, l.Value)
+			return false
+		}
+	}
+	return true
+}
+
+// NewUTF8SanitizingSeriesSet returns a series set which replaces invalid UTF-8 sequences in label names and values
+// with the Unicode replacement character and adds a warning for every series sanitized. Series are passed through
+// as is until the first one needing sanitization. Sanitizing can make series equal, so from then on the remaining
+// series are re-sorted and merged, see newRelabelSeriesSet for the implied memory cost. Series returned before are
+// not re-sorted, which only matters if they are equal to a sanitized series up to its invalid sequence.
+func NewUTF8SanitizingSeriesSet(s SeriesSet) WarningsSeriesSet {
+	p := NewPeekSeriesSet(s)
+	return &utf8SanitizingSeriesSet{warningsSeriesSet: warningsSeriesSet{SeriesSet: p}, p: p}
+}
+
+type utf8SanitizingSeriesSet struct {
+	warningsSeriesSet
+
+	p         PeekSeriesSet
+	buffering bool
+}
+
+func (s *utf8SanitizingSeriesSet) Next() bool {
+	if s.buffering {
+		return s.SeriesSet.Next()
+	}
+	lset, _, ok := s.p.Peek()
+	if !ok {
+		return false
+	}
+	if validUTF8Labels(lset) {
+		return s.p.Next()
+	}
+	s.buffering = true
+	s.SeriesSet = newRelabelSeriesSet(s.p, s.sanitize)
+	return s.SeriesSet.Next()
+}
+
+func (s *utf8SanitizingSeriesSet) sanitize(lset []Label) []Label {
+	if validUTF8Labels(lset) {
+		return lset
+	}
+	sanitized := make([]Label, 0, len(lset))
+	for _, l := range lset {
+		sanitized = append(sanitized, Label{
+			Name:  strings.ToValidUTF8(l.Name, string(utf8.RuneError)),
+			Value: strings.ToValidUTF8(l.Value, string(utf8.RuneError)),
+		})
+	}
+	s.warns = append(s.warns, errors.Errorf("series %s: invalid UTF-8 in labels replaced", LabelsToString(lset)).Error())
+	return sanitized
+}
+
+func validUTF8Labels(lset []Label) bool {
+	for _, l := range lset {
+		if !utf8.ValidString(l.Name) || !utf8.ValidString(l.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifySeriesSetChecksums verifies the integrity of all chunks of s using the given number of worker goroutines,
+// e.g. to check a whole block quickly. StoreAPI chunks carry no CRC, so instead of comparing checksums every chunk
+// is validated with AggrChunk.Validate, which decodes all of its samples. The series are read from s by the calling
+// goroutine and copied before being handed to the workers, so s may reuse its buffers.
+//
+// The error of the first invalid chunk in the order of s is returned, regardless of which worker finishes first.
+// Once an invalid chunk is found, no further chunks are validated and s is not drained.
+func VerifySeriesSetChecksums(s SeriesSet, workers int) error {
+	if workers < 1 {
+		return errors.Errorf("invalid number of workers %d", workers)
+	}
+
+	type job struct {
+		seq  int
+		lset []Label
+		i    int
+		c    AggrChunk
+	}
+	var (
+		jobs = make(chan job)
+		wg   sync.WaitGroup
+
+		mtx      sync.Mutex
+		firstSeq int
+		firstErr error
+	)
+	failed := func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return firstErr != nil
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				err := j.c.Validate()
+				if err == nil {
+					continue
+				}
+				mtx.Lock()
+				if firstErr == nil || j.seq < firstSeq {
+					firstSeq, firstErr = j.seq, errors.Wrapf(err, "series %s chunk %d", LabelsToString(j.lset), j.i)
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+
+	// All chunks before an invalid one have been handed to workers already, so stopping once any chunk failed
+	// keeps the result deterministic.
+	seq := 0
+Outer:
+	for s.Next() {
+		series := deepCopySeries(s.At())
+		for i, c := range series.Chunks {
+			if failed() {
+				break Outer
+			}
+			jobs <- job{seq: seq, lset: series.Labels, i: i, c: c}
+			seq++
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return s.Err()
+}
+
+// EncodingMix decides whether chunks a and b of different encodings may be part of the same series.
+type EncodingMix func(a, b AggrChunk) bool
+
+// AllowRawEncodingMix allows raw chunks of different chunk encodings within a series, e.g. legacy double-delta
+// chunks next to XOR chunks.
+func AllowRawEncodingMix(a, b AggrChunk) bool {
+	return a.Raw != nil && b.Raw != nil && len(a.Aggregates()) == 1 && len(b.Aggregates()) == 1
+}
+
+// AllowRawAggrMix allows raw chunks next to downsampled chunks within a series, e.g. for a time range spanning
+// blocks of different resolutions.
+func AllowRawAggrMix(a, b AggrChunk) bool {
+	return (a.Raw != nil) != (b.Raw != nil)
+}
+
+// NewEncodingConsistencySeriesSet returns a series set that fails with an error if a series contains chunks of
+// different encodings, which usually indicates a bug in a store. The encoding of a chunk is the set of present
+// aggregates together with their chunk encodings. Chunks of different encodings are accepted if any of the allowed
+// mixes returns true for them.
+func NewEncodingConsistencySeriesSet(s SeriesSet, allowed ...EncodingMix) SeriesSet {
+	return &encodingConsistencySeriesSet{erroringSeriesSet: erroringSeriesSet{SeriesSet: s}, allowed: allowed}
+}
+
+type encodingConsistencySeriesSet struct {
+	erroringSeriesSet
+
+	allowed []EncodingMix
+}
+
+func (s *encodingConsistencySeriesSet) Next() bool {
+	if s.err != nil || !s.SeriesSet.Next() {
+		return false
+	}
+	lset, chks := s.SeriesSet.At()
+
+	// Indexes of the first chunk of every distinct encoding seen so far.
+	var firsts []int
+Chunks:
+	for i, c := range chks {
+		for _, j := range firsts {
+			if sameEncoding(chks[j], c) {
+				continue Chunks
+			}
+		}
+		for _, j := range firsts {
+			if !s.mixAllowed(chks[j], c) {
+				s.err = errors.Errorf("series %s: chunk %d encoding %s is incompatible with chunk %d encoding %s",
+					LabelsToString(lset), i, encodingString(c), j, encodingString(chks[j]))
+				return false
+			}
+		}
+		firsts = append(firsts, i)
+	}
+	return true
+}
+
+func (s *encodingConsistencySeriesSet) mixAllowed(a, b AggrChunk) bool {
+	for _, allowed := range s.allowed {
+		if allowed(a, b) || allowed(b, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameEncoding(a, b AggrChunk) bool {
+	for _, aggr := range allAggrs {
+		ca, oka := a.Get(aggr)
+		cb, okb := b.Get(aggr)
+		if oka != okb || oka && ca.Type != cb.Type {
+			return false
+		}
+	}
+	return true
+}
+
+// encodingString returns the present aggregates of the chunk with their encodings, e.g. "[RAW:XOR]".
+func encodingString(c AggrChunk) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, aggr := range c.Aggregates() {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		x, _ := c.Get(aggr)
+		b.WriteString(aggr.String())
+		b.WriteByte(':')
+		b.WriteString(x.Type.String())
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// CheckSeriesSetInvariants drains the given series set and verifies that series are strictly sorted by labels,
+// that no two consecutive series have equal labels and that no series has byte-identical duplicate chunks.
+// It returns the first violation found or an error of the set itself.
+func CheckSeriesSetInvariants(s SeriesSet) error {
+	var (
+		prev []Label
+		i    int
+	)
+	for ; s.Next(); i++ {
+		lset, chks := s.At()
+		if i > 0 {
+			switch d := CompareLabels(prev, lset); {
+			case d == 0:
+				return errors.Errorf("series %d: labels %s equal to previous series", i, LabelsToString(lset))
+			case d > 0:
+				return errors.Errorf("series %d: labels %s not sorted after previous series %s", i, LabelsToString(lset), LabelsToString(prev))
+			}
+		}
+		// The wrapped set may reuse the memory of the labels on Next.
+		prev = deepCopyLabels(lset)
+
+		seen := make(map[string]int, len(chks))
+		for j, c := range chks {
+			b, err := c.Marshal()
+			if err != nil {
+				return errors.Wrapf(err, "series %d: %s: marshal chunk %d", i, LabelsToString(lset), j)
+			}
+			if k, ok := seen[string(b)]; ok {
+				return errors.Errorf("series %d: %s: chunk %d is a duplicate of chunk %d", i, LabelsToString(lset), j, k)
+			}
+			seen[string(b)] = j
+		}
+	}
+	return errors.Wrap(s.Err(), "iterate series set")
+}
@@ -0,0 +1,513 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storepb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestMonotonicCheckSeriesSet(t *testing.T) {
+	t.Run("monotonic", func(t *testing.T) {
+		in := []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "b"),
+				chunks: [][]sample{{{1, 1}, {5, 2}, {10, 3}}},
+			},
+		}
+		s := NewMonotonicCheckSeriesSet(newListSeriesSet(t, in))
+		seriesEquals(t, in, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("non monotonic", func(t *testing.T) {
+		s := NewMonotonicCheckSeriesSet(newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "b"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {5, 4}, {4, 5}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "c"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+		}))
+		testutil.Assert(t, s.Next(), "expected first series")
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+		testutil.Assert(t, strings.Contains(s.Err().Error(), LabelsToString([]Label{{Name: "a", Value: "b"}})+" chunk 1"), "unexpected error %v", s.Err())
+		testutil.Assert(t, !s.Next(), "expected iteration to stay stopped")
+	})
+	t.Run("aggregated chunks are skipped", func(t *testing.T) {
+		l := newListSeriesSet(t, []rawSeries{{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{2, 1}, {1, 2}}},
+		}})
+		c := l.series[0].Chunks[0]
+		l.series[0].Chunks[0] = AggrChunk{MinTime: c.MinTime, MaxTime: c.MaxTime, Count: c.Raw}
+
+		s := NewMonotonicCheckSeriesSet(l)
+		testutil.Assert(t, s.Next(), "expected series")
+		testutil.Assert(t, !s.Next(), "expected end of stream")
+		testutil.Ok(t, s.Err())
+	})
+}
+
+func TestCheckSeriesSetInvariants(t *testing.T) {
+	for _, tcase := range []struct {
+		desc        string
+		in          []rawSeries
+		mod         func(l *listSeriesSet)
+		expectedErr string
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc: "valid",
+			in: []rawSeries{
+				{
+					lset:   labels.FromStrings("a", "a"),
+					chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+				},
+				{
+					lset:   labels.FromStrings("a", "a", "b", "b"),
+					chunks: [][]sample{{{1, 1}, {2, 2}}},
+				},
+				{
+					lset:   labels.FromStrings("a", "b"),
+					chunks: [][]sample{{{1, 1}, {2, 2}}},
+				},
+			},
+		},
+		{
+			desc: "not sorted",
+			in: []rawSeries{
+				{
+					lset:   labels.FromStrings("a", "b"),
+					chunks: [][]sample{{{1, 1}}},
+				},
+				{
+					lset:   labels.FromStrings("a", "a"),
+					chunks: [][]sample{{{1, 1}}},
+				},
+			},
+			expectedErr: "series 1: labels",
+		},
+		{
+			desc: "equal labels",
+			in: []rawSeries{
+				{
+					lset:   labels.FromStrings("a", "a"),
+					chunks: [][]sample{{{1, 1}}},
+				},
+				{
+					lset:   labels.FromStrings("a", "a"),
+					chunks: [][]sample{{{2, 2}}},
+				},
+			},
+			expectedErr: "equal to previous series",
+		},
+		{
+			desc: "duplicated chunk",
+			in: []rawSeries{
+				{
+					lset:   labels.FromStrings("a", "a"),
+					chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{1, 1}, {2, 2}}},
+				},
+			},
+			expectedErr: "chunk 2 is a duplicate of chunk 0",
+		},
+		{
+			desc: "chunks with same samples but different time range are fine",
+			in: []rawSeries{
+				{
+					lset:   labels.FromStrings("a", "a"),
+					chunks: [][]sample{{{1, 1}, {2, 2}}, {{1, 1}, {2, 2}}},
+				},
+			},
+			mod: func(l *listSeriesSet) {
+				l.series[0].Chunks[1].MaxTime = 10
+			},
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			l := newListSeriesSet(t, tcase.in)
+			if tcase.mod != nil {
+				tcase.mod(l)
+			}
+			err := CheckSeriesSetInvariants(l)
+			if tcase.expectedErr == "" {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotOk(t, err)
+			testutil.Assert(t, strings.Contains(err.Error(), tcase.expectedErr), "unexpected error %v", err)
+		})
+	}
+
+	t.Run("reused label buffer", func(t *testing.T) {
+		buf := []byte("a2")
+		var series []Series
+		for i := 0; i < 2; i++ {
+			series = append(series, Series{Labels: LabelsFromBytes([][2][]byte{{buf[:1], buf[1:]}})})
+		}
+		err := CheckSeriesSetInvariants(&bufferReusingSeriesSet{SeriesSet: &listSeriesSet{series: series, idx: -1}, buf: buf, values: []byte("21")})
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), "not sorted after previous series"), "unexpected error %v", err)
+	})
+
+	expectedErr := errors.New("test error")
+	testutil.Equals(t, expectedErr, errors.Cause(CheckSeriesSetInvariants(errSeriesSet{err: expectedErr})))
+}
+
+func TestUniqueChunkTimeSeriesSet(t *testing.T) {
+	t.Run("unique", func(t *testing.T) {
+		in := []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "b"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+		}
+		s := NewUniqueChunkTimeSeriesSet(newListSeriesSet(t, in))
+		seriesEquals(t, in, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("same min time", func(t *testing.T) {
+		s := NewUniqueChunkTimeSeriesSet(newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "a"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "b"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{1, 5}, {2, 6}}},
+			},
+		}))
+		testutil.Assert(t, s.Next(), "expected first series")
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "series "+LabelsToString([]Label{{Name: "a", Value: "b"}})+": chunks 0 and 2 have the same min time 1", s.Err().Error())
+	})
+}
+
+func TestStrictChunkOrderSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{2, 3}, {4, 4}}, {{5, 5}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {2, 2}}},
+		},
+	}
+	s := NewStrictChunkOrderSeriesSet(newListSeriesSet(t, in))
+	seriesEquals(t, in, s)
+	testutil.Ok(t, s.Err())
+
+	for _, tcase := range []struct {
+		desc     string
+		chunks   [][]sample
+		expected string
+	}{
+		{
+			desc:     "equal min time",
+			chunks:   [][]sample{{{1, 1}, {2, 2}}, {{3, 3}}, {{3, 5}, {4, 6}}},
+			expected: "chunk 2 min time 3 is not after chunk 1 min time 3",
+		},
+		{
+			desc:     "decreasing min time",
+			chunks:   [][]sample{{{5, 1}, {6, 2}}, {{1, 3}}},
+			expected: "chunk 1 min time 1 is not after chunk 0 min time 5",
+		},
+	} {
+		t.Run(tcase.desc, func(t *testing.T) {
+			s := NewStrictChunkOrderSeriesSet(newListSeriesSet(t, []rawSeries{
+				in[0],
+				{lset: labels.FromStrings("a", "b"), chunks: tcase.chunks},
+			}))
+			testutil.Assert(t, s.Next(), "expected first series")
+			testutil.Assert(t, !s.Next(), "expected iteration to stop")
+			testutil.NotOk(t, s.Err())
+			testutil.Equals(t, "series "+LabelsToString([]Label{{Name: "a", Value: "b"}})+": "+tcase.expected, s.Err().Error())
+		})
+	}
+}
+
+func TestMaxSpanSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{10, 1}, {20, 2}}, {{1, 1}, {5, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{1, 1}, {30, 2}}},
+		},
+	}
+
+	t.Run("within span", func(t *testing.T) {
+		s := NewMaxSpanSeriesSet(newListSeriesSet(t, in), 29)
+		seriesEquals(t, in, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("span exceeded", func(t *testing.T) {
+		s := NewMaxSpanSeriesSet(newListSeriesSet(t, in), 20)
+		testutil.Assert(t, s.Next(), "expected first series")
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "series "+LabelsToString([]Label{{Name: "a", Value: "b"}})+": time span 29 exceeds maximum of 20", s.Err().Error())
+	})
+}
+
+func TestRangeAssertingSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "a"),
+			chunks: [][]sample{{{1, 1}, {10, 2}}, {{11, 1}, {20, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "b"),
+			chunks: [][]sample{{{5, 1}, {15, 2}}, {{21, 1}, {30, 2}}},
+		},
+	}
+
+	t.Run("within range", func(t *testing.T) {
+		// Chunks only have to overlap the range.
+		s := NewRangeAssertingSeriesSet(newListSeriesSet(t, in), 10, 21)
+		seriesEquals(t, in, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("chunk outside of range", func(t *testing.T) {
+		s := NewRangeAssertingSeriesSet(newListSeriesSet(t, in), 10, 20)
+		seriesEquals(t, in[:1], s)
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "series "+LabelsToString(PromLabelsToLabels(in[1].lset))+": chunk 1 with time range [21, 30] is outside of the requested range [10, 20]", s.Err().Error())
+
+		s = NewRangeAssertingSeriesSet(newListSeriesSet(t, in), 11, 30)
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+	})
+}
+
+func TestRequireLabelsSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "cluster", "eu", "replica", "0"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "replica", "0"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3", "cluster", "eu", "replica", "0"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+	}
+
+	t.Run("present", func(t *testing.T) {
+		s := NewRequireLabelsSeriesSet(newListSeriesSet(t, in), []string{"a", "replica"})
+		seriesEquals(t, in, s)
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("missing", func(t *testing.T) {
+		s := NewRequireLabelsSeriesSet(newListSeriesSet(t, in), []string{"replica", "cluster"})
+		seriesEquals(t, in[:1], s)
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "series "+LabelsToString(PromLabelsToLabels(in[1].lset))+": missing required label \"cluster\"", s.Err().Error())
+	})
+}
+
+func TestUTF8ValidatingSeriesSet(t *testing.T) {
+	in := []rawSeries{
+		{
+			lset:   labels.FromStrings("a", "1", "b", "ä"),
+			chunks: [][]sample{{{1, 1}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "2", "b", "x\xffy"),
+			chunks: [][]sample{{{2, 2}}},
+		},
+		{
+			lset:   labels.FromStrings("a", "3"),
+			chunks: [][]sample{{{3, 3}}},
+		},
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		s := NewUTF8ValidatingSeriesSet(newListSeriesSet(t, in))
+		seriesEquals(t, in[:1], s)
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "series "+LabelsToString(PromLabelsToLabels(in[1].lset))+`: label "b" value "x\xffy" is not valid UTF-8`, s.Err().Error())
+	})
+	t.Run("validate invalid name", func(t *testing.T) {
+		s := NewUTF8ValidatingSeriesSet(newListSeriesSet(t, []rawSeries{{lset: labels.FromStrings("\xff", "1")}}))
+		testutil.Assert(t, !s.Next(), "expected no series")
+		testutil.NotOk(t, s.Err())
+	})
+	t.Run("sanitize", func(t *testing.T) {
+		s := NewUTF8SanitizingSeriesSet(newListSeriesSet(t, in))
+		seriesEquals(t, []rawSeries{
+			in[0],
+			{
+				lset:   labels.FromStrings("a", "2", "b", "x\uFFFDy"),
+				chunks: [][]sample{{{2, 2}}},
+			},
+			in[2],
+		}, s)
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, []string{"series " + LabelsToString(PromLabelsToLabels(in[1].lset)) + ": invalid UTF-8 in labels replaced"}, s.Warnings())
+	})
+	t.Run("sanitize streams valid series", func(t *testing.T) {
+		c := &countingSeriesSet{SeriesSet: newListSeriesSet(t, in)}
+		s := NewUTF8SanitizingSeriesSet(c)
+		testutil.Assert(t, s.Next(), "expected first series")
+		testutil.Equals(t, 1, c.nexts)
+		testutil.Equals(t, 0, len(s.Warnings()))
+	})
+	t.Run("sanitize merges equal series", func(t *testing.T) {
+		s := NewUTF8SanitizingSeriesSet(newListSeriesSet(t, []rawSeries{
+			{lset: labels.FromStrings("a", "x\xfey"), chunks: [][]sample{{{1, 1}}}},
+			{lset: labels.FromStrings("a", "x\xffy"), chunks: [][]sample{{{2, 2}}}},
+		}))
+		seriesEquals(t, []rawSeries{
+			{lset: labels.FromStrings("a", "x\uFFFDy"), chunks: [][]sample{{{1, 1}}, {{2, 2}}}},
+		}, s)
+		testutil.Ok(t, s.Err())
+		testutil.Equals(t, 2, len(s.Warnings()))
+	})
+}
+
+func TestVerifySeriesSetChecksums(t *testing.T) {
+	var in []rawSeries
+	for i := 0; i < 100; i++ {
+		in = append(in, rawSeries{
+			lset:   labels.FromStrings("a", fmt.Sprintf("%03d", i)),
+			chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+		})
+	}
+	corrupt := func(l *listSeriesSet, series, chunk int) {
+		// Truncating the data leaves the header intact, but the samples cannot be decoded anymore.
+		c := l.series[series].Chunks[chunk].Raw
+		c.Data = c.Data[:len(c.Data)-3]
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("workers %d", workers), func(t *testing.T) {
+			defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+			testutil.Ok(t, VerifySeriesSetChecksums(newListSeriesSet(t, in), workers))
+
+			l := newListSeriesSet(t, in)
+			corrupt(l, 50, 1)
+			err := VerifySeriesSetChecksums(l, workers)
+			testutil.NotOk(t, err)
+			testutil.Assert(t, strings.HasPrefix(err.Error(), "series "+LabelsToString(l.series[50].Labels)+" chunk 1: "), "unexpected error %s", err)
+
+			// The first invalid chunk in order is reported.
+			for i := 0; i < 10; i++ {
+				l := newListSeriesSet(t, in)
+				corrupt(l, 20, 0)
+				corrupt(l, 21, 0)
+				corrupt(l, 90, 1)
+				err := VerifySeriesSetChecksums(l, workers)
+				testutil.NotOk(t, err)
+				testutil.Assert(t, strings.HasPrefix(err.Error(), "series "+LabelsToString(l.series[20].Labels)+" chunk 0: "), "unexpected error %s", err)
+			}
+		})
+	}
+
+	t.Run("reused buffers", func(t *testing.T) {
+		// The invalid chunk is long to decode, so the following series are read while it is validated.
+		var long []sample
+		for i := int64(0); i < 60000; i++ {
+			long = append(long, sample{i, float64(i)})
+		}
+		l := newListSeriesSet(t, append([]rawSeries{{lset: labels.FromStrings("a", "0"), chunks: [][]sample{long}}}, in[1:10]...))
+		corrupt(l, 0, 0)
+		buf := []byte("a0")
+		for i := range l.series {
+			l.series[i].Labels = LabelsFromBytes([][2][]byte{{buf[:1], buf[1:]}})
+		}
+		err := VerifySeriesSetChecksums(&bufferReusingSeriesSet{SeriesSet: l, buf: buf, values: []byte("0123456789")}, 4)
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.HasPrefix(err.Error(), "series "+LabelsToString([]Label{{Name: "a", Value: "0"}})+" chunk 0: "), "unexpected error %s", err)
+	})
+	t.Run("series set error", func(t *testing.T) {
+		err := VerifySeriesSetChecksums(&failingSeriesSet{SeriesSet: newListSeriesSet(t, in), n: 10, err: errors.New("failure")}, 4)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "failure", err.Error())
+	})
+	t.Run("invalid workers", func(t *testing.T) {
+		testutil.NotOk(t, VerifySeriesSetChecksums(newListSeriesSet(t, in), 0))
+	})
+}
+
+func TestEncodingConsistencySeriesSet(t *testing.T) {
+	input := func() *listSeriesSet {
+		s := newListSeriesSet(t, []rawSeries{
+			{
+				lset:   labels.FromStrings("a", "1"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+			},
+			{
+				lset:   labels.FromStrings("a", "2"),
+				chunks: [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}},
+			},
+		})
+		// Replace the second chunk of the second series by a downsampled one.
+		raw := s.series[1].Chunks[1].Raw
+		s.series[1].Chunks[1] = AggrChunk{MinTime: 3, MaxTime: 4, Count: raw, Sum: raw}
+		return s
+	}
+
+	t.Run("mixed raw and aggregates", func(t *testing.T) {
+		in := input()
+		s := NewEncodingConsistencySeriesSet(in)
+		testutil.Assert(t, s.Next(), "expected first series")
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+		testutil.Equals(t, "series "+LabelsToString(in.series[1].Labels)+": chunk 1 encoding [COUNT:XOR SUM:XOR] is incompatible with chunk 0 encoding [RAW:XOR]", s.Err().Error())
+	})
+	t.Run("allowed mix", func(t *testing.T) {
+		s := NewEncodingConsistencySeriesSet(input(), AllowRawEncodingMix, AllowRawAggrMix)
+		testutil.Assert(t, s.Next(), "expected first series")
+		testutil.Assert(t, s.Next(), "expected second series")
+		testutil.Assert(t, !s.Next(), "expected end of stream")
+		testutil.Ok(t, s.Err())
+	})
+	t.Run("mixed raw encodings", func(t *testing.T) {
+		in := input()
+		in.series = in.series[:1]
+		in.series[0].Chunks[1].Raw = &Chunk{Type: Chunk_DOUBLE_DELTA, Data: doubleDeltaData(1, 4, false, 3, 3, 1, 1)}
+
+		s := NewEncodingConsistencySeriesSet(in, AllowRawAggrMix)
+		testutil.Assert(t, !s.Next(), "expected iteration to stop")
+		testutil.NotOk(t, s.Err())
+
+		in.idx = -1
+		s = NewEncodingConsistencySeriesSet(in, AllowRawEncodingMix)
+		testutil.Assert(t, s.Next(), "expected series")
+		testutil.Ok(t, s.Err())
+	})
+}
@@ -6,7 +6,6 @@ package store
 import (
 	"context"
 	"math"
-	"sort"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
@@ -203,9 +202,7 @@ func (s *TSDBStore) translateAndExtendLabels(m, extend labels.Labels) []storepb.
 			Value: l.Value,
 		})
 	}
-	sort.Slice(lset, func(i, j int) bool {
-		return lset[i].Name < lset[j].Name
-	})
+	storepb.SortLabels(lset)
 	return lset
 }
 